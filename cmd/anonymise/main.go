@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/pkg/anonymiser"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+)
+
+func main() {
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <capture file, JSON file or directory>...\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// load configuration from command line or environment
+	pflag.String("output_directory", "./anonymised", "Directory the anonymised files are written to")
+	pflag.String("mapping", "", "File with the pseudonyms of earlier runs, updated with the new ones. It contains the original ids and names, do not share it")
+	pflag.Bool("development", false, "Enable development logging")
+	pflag.Parse()
+
+	viper.SetEnvPrefix("anonymise")
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind command line flags")
+	}
+
+	// setup logging
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if viper.GetBool("development") {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	}
+
+	if pflag.NArg() == 0 {
+		pflag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := expand(pflag.Args())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to find input files")
+	}
+
+	a := anonymiser.New()
+
+	mappingFile := viper.GetString("mapping")
+	if mappingFile != "" {
+		if err := loadMapping(a, mappingFile); err != nil {
+			log.Fatal().Err(err).Str("mapping", mappingFile).Msg("Failed to load mapping")
+		}
+	}
+
+	directory := viper.GetString("output_directory")
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		log.Fatal().Err(err).Msg("Failed to create output directory")
+	}
+
+	for _, file := range files {
+		target, err := a.File(file, directory)
+		if err != nil {
+			log.Fatal().Err(err).Str("file", file).Msg("Failed to anonymise file")
+		}
+		log.Info().Str("file", file).Str("target", target).Msg("Anonymised file")
+	}
+
+	if mappingFile != "" {
+		if err := saveMapping(a, mappingFile); err != nil {
+			log.Fatal().Err(err).Str("mapping", mappingFile).Msg("Failed to save mapping")
+		}
+	}
+}
+
+// expand replaces directories by the capture segments and JSON files they contain
+func expand(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		segments, err := eventcapture.Segments(path)
+		if err != nil {
+			return nil, err
+		}
+		documents, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(append(files, segments...), documents...)
+	}
+
+	return files, nil
+}
+
+func loadMapping(a *anonymiser.Anonymiser, path string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	mapping := map[string]map[string]string{}
+	if err := json.Unmarshal(content, &mapping); err != nil {
+		return err
+	}
+	return a.SetMapping(mapping)
+}
+
+func saveMapping(a *anonymiser.Anonymiser, path string) error {
+	content, err := json.MarshalIndent(a.Mapping(), "", "  ")
+	if err != nil {
+		return err
+	}
+	// the mapping reveals the original ids and names
+	return ioutil.WriteFile(path, content, 0600)
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+)
+
+func main() {
+	pluginruntime.Run(&eventcapture.Plugin{})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/swarpf/plugins/pkg/debugout"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+	"github.com/swarpf/plugins/pkg/profileexport"
+	"github.com/swarpf/plugins/pkg/siegeexport"
+	"github.com/swarpf/plugins/pkg/swaglogger"
+	"github.com/swarpf/plugins/pkg/swarfarm"
+)
+
+func main() {
+	pluginruntime.Run(pluginruntime.NewHost(
+		&debugout.Plugin{},
+		&eventcapture.Plugin{},
+		&profileexport.Plugin{},
+		&siegeexport.Plugin{},
+		&swaglogger.Plugin{},
+		&swarfarm.Plugin{},
+	))
+}
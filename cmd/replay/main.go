@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/internal/tlsconfig"
+	"github.com/swarpf/plugins/pkg/debugout"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+	"github.com/swarpf/plugins/pkg/profileexport"
+	"github.com/swarpf/plugins/pkg/replay"
+	"github.com/swarpf/plugins/pkg/siegeexport"
+	"github.com/swarpf/plugins/pkg/swaglogger"
+	"github.com/swarpf/plugins/pkg/swarfarm"
+)
+
+func main() {
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <capture file or directory>...\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+
+	// plugins which can be replayed to in-process, configured like in the plugin host
+	host := pluginruntime.NewHost(
+		&debugout.Plugin{},
+		&eventcapture.Plugin{},
+		&profileexport.Plugin{},
+		&siegeexport.Plugin{},
+		&swaglogger.Plugin{},
+		&swarfarm.Plugin{},
+	)
+
+	// load configuration from command line or environment
+	pflag.String("target_addr", "", "Address of a running plugin to send the events to")
+	pflag.StringSlice("plugin", nil, "Replay to these plugins in-process instead of a running plugin")
+	pflag.String("config", "", "Configuration file of the in-process plugins, in the format of the plugin host")
+	pflag.Float64("speed", 0, "Replay speed relative to the recorded time: 1 is real time, 2 twice as fast. 0 replays as fast as possible")
+	pflag.Duration("max_pause", 0, "Maximum time between two events. 0 keeps the recorded pauses")
+	pflag.StringSlice("commands", nil, "Only replay these commands")
+	pflag.StringSlice("exclude_commands", nil, "Do not replay these commands")
+	pflag.StringSlice("wizard_ids", nil, "Only replay events of these wizards")
+	pflag.Bool("stop_on_error", false, "Stop at the first event the plugin failed to handle")
+	pflag.String("shared_secret", "", "Shared secret of the running plugin")
+	pflag.Bool("tls", false, "Connect to the running plugin with TLS")
+	pflag.String("tls_ca", "", "CA file to verify the certificate of the plugin. Empty uses the system roots")
+	pflag.String("tls_cert", "", "Client certificate file presented to the plugin for mutual TLS")
+	pflag.String("tls_key", "", "Key file of the client certificate")
+	pflag.String("tls_server_name", "", "Name to verify the certificate of the plugin against instead of its host name")
+	pflag.Bool("development", false, "Enable development logging")
+	host.RegisterFlags(pflag.CommandLine)
+	pflag.Parse()
+
+	viper.SetEnvPrefix("replay")
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind command line flags")
+	}
+
+	// setup logging
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if viper.GetBool("development") {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	}
+
+	if pflag.NArg() == 0 {
+		pflag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := replay.Files(pflag.Args())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to find capture files")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Info().Msg("Stopping replay")
+		cancel()
+	}()
+
+	target, done := newTarget(host)
+	defer done()
+
+	result, err := replay.Replay(ctx, files, target, replay.Options{
+		Speed:           viper.GetFloat64("speed"),
+		MaxPause:        viper.GetDuration("max_pause"),
+		Commands:        viper.GetStringSlice("commands"),
+		ExcludeCommands: viper.GetStringSlice("exclude_commands"),
+		WizardIds:       viper.GetStringSlice("wizard_ids"),
+		StopOnError:     viper.GetBool("stop_on_error"),
+	})
+
+	log.Info().
+		Int("delivered", result.Delivered).
+		Int("skipped", result.Skipped).
+		Int("failed", result.Failed).
+		Int("files", len(files)).
+		Msg("Replay finished")
+
+	if err != nil && err != context.Canceled {
+		done()
+		log.Fatal().Err(err).Msg("Replay failed")
+	}
+}
+
+// newTarget connects to the running plugin or configures the in-process plugins. The returned function closes the
+// connection or shuts the plugins down.
+func newTarget(host *pluginruntime.Host) (replay.Target, func()) {
+	plugins := viper.GetStringSlice("plugin")
+	address := viper.GetString("target_addr")
+
+	switch {
+	case address != "" && len(plugins) > 0:
+		log.Fatal().Msg("Use either --target_addr or --plugin")
+	case address != "":
+		return grpcTarget(address)
+	case len(plugins) > 0:
+		return pluginTarget(host, plugins)
+	}
+
+	log.Fatal().Msg("Either --target_addr or --plugin is required")
+	return nil, nil
+}
+
+func grpcTarget(address string) (replay.Target, func()) {
+	dialOption := grpc.WithInsecure()
+	options := tlsconfig.Options{
+		CertFile:   viper.GetString("tls_cert"),
+		KeyFile:    viper.GetString("tls_key"),
+		CAFile:     viper.GetString("tls_ca"),
+		ServerName: viper.GetString("tls_server_name"),
+	}
+	if viper.GetBool("tls") || options.CertFile != "" || options.CAFile != "" {
+		creds, err := tlsconfig.NewClientCredentials(options)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load TLS configuration")
+		}
+		dialOption = grpc.WithTransportCredentials(creds)
+	}
+
+	conn, err := grpc.Dial(address, dialOption)
+	if err != nil {
+		log.Fatal().Err(err).Str("targetAddress", address).Msg("Failed to connect to plugin")
+	}
+
+	log.Info().Str("targetAddress", address).Msgf("Replaying events to plugin at %s", address)
+	return replay.GrpcTarget(conn, viper.GetString("shared_secret")), func() { _ = conn.Close() }
+}
+
+func pluginTarget(host *pluginruntime.Host, plugins []string) (replay.Target, func()) {
+	config := viper.GetViper()
+	if configFile := config.GetString("config"); configFile != "" {
+		config.SetConfigFile(configFile)
+		if err := config.ReadInConfig(); err != nil {
+			log.Fatal().Err(err).Str("configFile", configFile).Msg("Failed to read configuration file")
+		}
+	}
+
+	for _, name := range plugins {
+		if pflag.Lookup(name+".enabled") == nil {
+			log.Fatal().Str("plugin", name).Msgf("Unknown plugin %s", name)
+		}
+		config.Set(name+".enabled", true)
+	}
+
+	if errs := host.ValidateConfig(config); len(errs) > 0 {
+		fmt.Fprintln(os.Stderr, configcheck.Errors(errs))
+		os.Exit(2)
+	}
+
+	if err := host.Configure(config); err != nil {
+		log.Fatal().Err(err).Msg("Failed to configure plugins")
+	}
+
+	log.Info().Strs("plugins", plugins).Msg("Replaying events to plugins in-process")
+	return replay.PluginTarget(host), host.Shutdown
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/pkg/swarfarmfake"
+)
+
+func main() {
+	// load configuration from command line or environment
+	pflag.String("listen_addr", "127.0.0.1:8000", "Listen address of the fake SWARFARM server")
+	pflag.StringToString("api_tokens", map[string]string{}, "List of accepted API tokens. Format: 'wizardId=Token,...'")
+	pflag.Int("fail_status", 0, "Answer every request with this status code. 0 disables failures")
+	pflag.Duration("delay", 0, "Delay every response by this duration")
+	pflag.Bool("development", false, "Enable development logging")
+	pflag.Parse()
+
+	viper.SetEnvPrefix("swarfarmfake")
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind command line flags")
+	}
+
+	// setup logging
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	if viper.GetBool("development") {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+	}
+
+	server := swarfarmfake.New()
+	for wizardId, token := range viper.GetStringMapString("api_tokens") {
+		id, err := strconv.ParseInt(wizardId, 10, 64)
+		if err != nil {
+			log.Fatal().Err(err).Str("wizardId", wizardId).Msg("Invalid wizard id")
+		}
+
+		server.AddToken(token, id)
+	}
+	server.FailWith(viper.GetInt("fail_status"), 0)
+	server.SetDelay(viper.GetDuration("delay"))
+
+	listenAddress := viper.GetString("listen_addr")
+	log.Info().
+		Str("listenAddr", listenAddress).
+		Msgf("Fake SWARFARM server listening on %s", listenAddress)
+
+	if err := http.ListenAndServe(listenAddress, server); err != nil {
+		log.Fatal().Err(err).Msg("Server stopped listening")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/spf13/pflag"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+)
+
+// updategoldens runs the golden tests in update mode, which rewrites the golden files with the current results.
+// Review the diff of the golden files before committing it.
+func main() {
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [packages]\n\nRegenerates the golden files of the fixture tests "+
+			"in the packages (default ./...).\n\nFlags:\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
+	run := pflag.String("run", "Golden", "Regular expression selecting the tests which are run")
+	goFlags := pflag.StringSlice("go_flag", nil, "Additional flag passed to go test, e.g. --go_flag=-v")
+	pflag.Parse()
+
+	packages := pflag.Args()
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	args := append([]string{"test", "-count=1", "-run", *run}, *goFlags...)
+	cmd := exec.Command("go", append(args, packages...)...)
+	cmd.Env = append(os.Environ(), fixtures.UpdateEnv+"=1")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
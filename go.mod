@@ -3,13 +3,19 @@ module github.com/swarpf/plugins
 go 1.14
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/go-resty/resty/v2 v2.3.0
 	github.com/golang/protobuf v1.4.2
+	github.com/klauspost/compress v1.11.4
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/zerolog v1.19.0
+	github.com/spf13/cast v1.3.0
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.7.0
 	github.com/thecodeteam/goodbye v0.0.0-20170927022442-a83968bda2d3
+	google.golang.org/genproto v0.0.0-20191108220845-16a3f7862a1a
 	google.golang.org/grpc v1.30.0
 	google.golang.org/grpc/cmd/protoc-gen-go-grpc v0.0.0-20200617041141-9a465503579e // indirect
 	google.golang.org/protobuf v1.23.0
+	gopkg.in/yaml.v2 v2.2.5
 )
@@ -0,0 +1,187 @@
+package configcheck
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Errors collects the problems found in a configuration, so all of them can be reported at once.
+type Errors []error
+
+// Add records err for the configuration key. Nil errors are ignored.
+func (e *Errors) Add(key string, err error) {
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s: %w", key, err))
+	}
+}
+
+// Addf records a problem with the configuration key.
+func (e *Errors) Addf(key, format string, args ...interface{}) {
+	e.Add(key, fmt.Errorf(format, args...))
+}
+
+// Append adds all errors of other, prefixing their keys with section.
+func (e *Errors) Append(section string, other []error) {
+	for _, err := range other {
+		*e = append(*e, fmt.Errorf("%s.%w", section, err))
+	}
+}
+
+// Err returns the collected errors as a single error or nil if there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e Errors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, "invalid configuration:")
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Address checks a listen or dial address in the form host:port.
+func Address(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid address, expected host:port", address)
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%q has an invalid port, expected a number between 0 and 65535", address)
+	}
+
+	return nil
+}
+
+// HttpUrl checks an absolute http or https URL.
+func HttpUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not a valid http or https URL", rawUrl)
+	}
+
+	return nil
+}
+
+// ReadableFile checks that a file exists and can be read.
+func ReadableFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can not read %s: %w", path, unwrapPathError(err))
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		return fmt.Errorf("%s is a directory, expected a file", path)
+	}
+
+	return nil
+}
+
+// WritableDirectory checks that files can be created in a directory. A directory which does not exist yet is valid if
+// it can be created, nothing is created by the check itself.
+func WritableDirectory(directory string) error {
+	if directory == "" {
+		directory = "."
+	}
+
+	fi, err := os.Stat(directory)
+	if os.IsNotExist(err) {
+		parent := existingParent(directory)
+		if err := writable(parent); err != nil {
+			return fmt.Errorf("%s does not exist and can not be created in %s: %w", directory, parent, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can not access %s: %w", directory, unwrapPathError(err))
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", directory)
+	}
+
+	if err := writable(directory); err != nil {
+		return fmt.Errorf("%s is not writable: %w", directory, err)
+	}
+	return nil
+}
+
+// WritableFile checks that a file can be written, either because it is writable already or because it can be
+// created in its directory.
+func WritableFile(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return WritableDirectory(filepath.Dir(path))
+	}
+	if err != nil {
+		return fmt.Errorf("can not access %s: %w", path, unwrapPathError(err))
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory, expected a file", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", path, unwrapPathError(err))
+	}
+	return f.Close()
+}
+
+// NotNegative checks numbers and durations which may not be below zero.
+func NotNegative(value float64) error {
+	if value < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+// Positive checks numbers and durations which have to be above zero.
+func Positive(value float64) error {
+	if value <= 0 {
+		return errors.New("must be greater than 0")
+	}
+	return nil
+}
+
+func writable(directory string) error {
+	f, err := ioutil.TempFile(directory, ".write-check-*")
+	if err != nil {
+		return unwrapPathError(err)
+	}
+
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// existingParent returns the closest parent of path which exists
+func existingParent(path string) string {
+	dir := filepath.Clean(path)
+	for {
+		parent := filepath.Dir(dir)
+		if _, err := os.Stat(parent); err == nil || parent == dir {
+			return parent
+		}
+		dir = parent
+	}
+}
+
+// unwrapPathError strips the operation and path from os errors, they are part of the message of the caller already
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package configcheck
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	if errs.Err() != nil {
+		t.Errorf("Err() of no errors = %v, want nil", errs.Err())
+	}
+
+	errs.Add("valid", nil)
+	errs.Add("listen_addr", errors.New("invalid"))
+	errs.Addf("timeout", "must be below %d", 10)
+	errs.Append("profileexport", []error{errors.New("output_directory: missing")})
+
+	want := "invalid configuration:\n" +
+		"  listen_addr: invalid\n" +
+		"  timeout: must be below 10\n" +
+		"  profileexport.output_directory: missing"
+	if err := errs.Err(); err == nil || err.Error() != want {
+		t.Errorf("Err() = %v, want %q", err, want)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	directory := tempDirectory(t)
+	file := filepath.Join(directory, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(directory, "missing")
+
+	tests := []struct {
+		name string
+		err  error
+		// wantErr is part of the error, no error is expected if it is empty
+		wantErr string
+	}{
+		{"address", Address("localhost:11000"), ""},
+		{"address without host", Address(":0"), ""},
+		{"address without port", Address("localhost"), "is not a valid address"},
+		{"address with port out of range", Address("localhost:65536"), "has an invalid port"},
+		{"address with named port", Address("localhost:http"), "has an invalid port"},
+		{"http URL", HttpUrl("https://swarfarm.com/data/log/upload/"), ""},
+		{"URL without scheme", HttpUrl("swarfarm.com"), "is not a valid http or https URL"},
+		{"URL with other scheme", HttpUrl("ftp://swarfarm.com"), "is not a valid http or https URL"},
+		{"URL without host", HttpUrl("http://"), "is not a valid http or https URL"},
+		{"readable file", ReadableFile(file), ""},
+		{"missing file", ReadableFile(missing), "can not read " + missing + ": no such file or directory"},
+		{"directory instead of file", ReadableFile(directory), "is a directory, expected a file"},
+		{"writable directory", WritableDirectory(directory), ""},
+		{"directory which can be created", WritableDirectory(filepath.Join(missing, "sub")), ""},
+		{"file instead of directory", WritableDirectory(file), "is not a directory"},
+		{"directory below a file", WritableDirectory(filepath.Join(file, "sub")), "can not access"},
+		{"writable file", WritableFile(file), ""},
+		{"file which can be created", WritableFile(missing), ""},
+		{"directory instead of writable file", WritableFile(directory), "is a directory, expected a file"},
+		{"zero is not negative", NotNegative(0), ""},
+		{"negative", NotNegative(-1), "must not be negative"},
+		{"positive", Positive(1), ""},
+		{"zero is not positive", Positive(0), "must be greater than 0"},
+	}
+
+	for _, test := range tests {
+		switch {
+		case test.wantErr == "" && test.err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, test.err)
+		case test.wantErr != "" && test.err == nil:
+			t.Errorf("%s: no error, want %q", test.name, test.wantErr)
+		case test.wantErr != "" && !strings.Contains(test.err.Error(), test.wantErr):
+			t.Errorf("%s: error %q does not contain %q", test.name, test.err, test.wantErr)
+		}
+	}
+
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("checks created %s", missing)
+	}
+}
+
+func tempDirectory(t *testing.T) string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "configcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	return directory
+}
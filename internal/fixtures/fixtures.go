@@ -0,0 +1,153 @@
+// Package fixtures gives tests access to the corpus of game commands in testdata and compares test results with
+// golden files.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Version is the version of the corpus used by the tests. A game patch which changes the commands gets a new version
+// next to the old ones, so the plugins can be tested against both.
+const Version = "v1"
+
+// UpdateEnv is the environment variable which makes CheckGolden write the golden files instead of comparing them.
+const UpdateEnv = "SWARPF_UPDATE_GOLDEN"
+
+// Fixture is a recorded api event. Request and response are JSON objects in the corpus files, so they can be read
+// and diffed.
+type Fixture struct {
+	// Name is the file name without extension, e.g. GetGuildSiegeBattleLog-attack
+	Name     string          `json:"-"`
+	Command  string          `json:"command"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+// RequestString returns the request as the proxy sends it.
+func (f Fixture) RequestString() string { return compact(f.Request) }
+
+// ResponseString returns the response as the proxy sends it.
+func (f Fixture) ResponseString() string { return compact(f.Response) }
+
+func compact(raw json.RawMessage) string {
+	buf := bytes.Buffer{}
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// Dir returns the directory of the corpus version.
+func Dir(version string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", version)
+}
+
+// Load reads a fixture of the current corpus version by name.
+func Load(name string) (Fixture, error) {
+	return load(filepath.Join(Dir(Version), name+".json"))
+}
+
+// MustLoad is like Load but fails the test if the fixture can not be read.
+func MustLoad(t testing.TB, name string) Fixture {
+	t.Helper()
+
+	f, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// List returns all fixtures of the current corpus version for the command, sorted by name.
+func List(command string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(Dir(Version), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var fixtures []Fixture
+	for _, path := range paths {
+		f, err := load(path)
+		if err != nil {
+			return nil, err
+		}
+		if f.Command == command {
+			fixtures = append(fixtures, f)
+		}
+	}
+	return fixtures, nil
+}
+
+func load(path string) (Fixture, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	f := Fixture{Name: strings.TrimSuffix(filepath.Base(path), ".json")}
+	if err := json.Unmarshal(content, &f); err != nil {
+		return Fixture{}, fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// CheckGolden compares got with the golden file at path. JSON is compared in indented form, so the golden files
+// can be reviewed. With SWARPF_UPDATE_GOLDEN=1 the golden file is written instead.
+func CheckGolden(t testing.TB, path string, got []byte) {
+	t.Helper()
+
+	if indented := (bytes.Buffer{}); json.Indent(&indented, got, "", "  ") == nil {
+		got = append(indented.Bytes(), '\n')
+	}
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run updategoldens to create it: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("result differs from golden file %s, run updategoldens if the change is intended:\n%s",
+			path, diff(string(want), string(got)))
+	}
+}
+
+// diff returns the first lines which differ between want and got
+func diff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+
+		if w != g {
+			return fmt.Sprintf("line %d:\n- %s\n+ %s", i+1, w, g)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package httpclient
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+)
+
+// RegisterFlags adds the flags configuring the client of an upstream with the given defaults.
+func RegisterFlags(flags *pflag.FlagSet, upstream string, defaults Options) {
+	flags.Duration("http_timeout", defaults.Timeout, "Timeout of requests to "+upstream+". 0 disables the timeout")
+	flags.Float64("rate_limit", defaults.RateLimit, "Maximum number of requests per second sent to "+upstream+". 0 disables the limit")
+	flags.Int("rate_limit_burst", defaults.Burst, "Number of requests which may be sent to "+upstream+" at once")
+	flags.Int("rate_limit_retries", defaults.MaxRetries, "Number of times a request is repeated after "+upstream+" answered with 429")
+	flags.Duration("max_retry_after", defaults.MaxRetryAfter, "Maximum time to wait for "+upstream+" if it asks to retry later")
+}
+
+// OptionsFromConfig reads the options registered by RegisterFlags.
+func OptionsFromConfig(config *viper.Viper) Options {
+	return Options{
+		Timeout:       config.GetDuration("http_timeout"),
+		RateLimit:     config.GetFloat64("rate_limit"),
+		Burst:         config.GetInt("rate_limit_burst"),
+		MaxRetries:    config.GetInt("rate_limit_retries"),
+		MaxRetryAfter: config.GetDuration("max_retry_after"),
+	}
+}
+
+// ValidateConfig checks the options registered by RegisterFlags.
+func ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	for _, key := range []string{"rate_limit", "rate_limit_burst", "rate_limit_retries"} {
+		errs.Add(key, configcheck.NotNegative(config.GetFloat64(key)))
+	}
+	for _, key := range []string{"http_timeout", "max_retry_after"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetDuration(key))))
+	}
+
+	if config.GetFloat64("rate_limit") > 0 && config.GetInt("rate_limit_burst") < 1 {
+		errs.Addf("rate_limit_burst", "must be at least 1 if rate_limit is set")
+	}
+
+	return errs
+}
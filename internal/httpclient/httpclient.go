@@ -0,0 +1,165 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+// Options configures a client for one upstream.
+type Options struct {
+	// Timeout limits every request including reading the response. 0 disables the timeout.
+	Timeout time.Duration
+	// RateLimit is the number of requests per second sent to a single host. 0 disables the rate limit.
+	RateLimit float64
+	// Burst is the number of requests which may be sent at once before the rate limit applies.
+	Burst int
+	// MaxRetries is the number of times a request is repeated after a 429 response.
+	MaxRetries int
+	// MaxRetryAfter caps the time a Retry-After header may make the client wait.
+	MaxRetryAfter time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:       30 * time.Second,
+		RateLimit:     5,
+		Burst:         10,
+		MaxRetries:    3,
+		MaxRetryAfter: time.Minute,
+	}
+}
+
+// transport is shared by all clients, so connections to the same host are reused
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// New creates a client which limits the requests per host, waits for hosts which answered with 429 and repeats
+// those requests. All clients share their connections. Clients with the same RateLimit and Burst also share the rate
+// limit of a host, including the pause after a 429. The upstream name is used in metrics.
+func New(upstream string, options Options) *resty.Client {
+	client := resty.NewWithClient(&http.Client{
+		Transport: &instrumentedTransport{upstream: upstream, base: transport},
+		Timeout:   options.Timeout,
+	})
+
+	client.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+		host, ok := requestHost(r)
+		if !ok {
+			return nil
+		}
+
+		return limiterFor(host, options).Wait(r.Context())
+	})
+
+	client.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		if !isRateLimited(resp) {
+			return nil
+		}
+
+		host, ok := requestHost(resp.Request)
+		if !ok {
+			return nil
+		}
+
+		wait := retryAfter(resp, options.MaxRetryAfter)
+		limiterFor(host, options).PauseFor(wait)
+
+		log.Warn().
+			Str("host", host).
+			Int("statusCode", resp.StatusCode()).
+			Dur("retryAfter", wait).
+			Msg("Upstream is rate limiting requests")
+
+		return nil
+	})
+
+	client.
+		SetRetryCount(options.MaxRetries).
+		SetRetryMaxWaitTime(options.MaxRetryAfter).
+		SetRetryAfter(func(c *resty.Client, resp *resty.Response) (time.Duration, error) {
+			return retryAfter(resp, options.MaxRetryAfter), nil
+		}).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			// other errors are not repeated, the request may have been processed already
+			return err == nil && isRateLimited(resp)
+		})
+
+	return client
+}
+
+// isRateLimited reports whether the upstream asked to repeat the request later
+func isRateLimited(resp *resty.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	return resp.StatusCode() == http.StatusTooManyRequests ||
+		(resp.StatusCode() == http.StatusServiceUnavailable && resp.Header().Get("Retry-After") != "")
+}
+
+// retryAfter returns the time to wait requested by the Retry-After header, which is either a number of seconds or a
+// date. Without header the client waits one second.
+func retryAfter(resp *resty.Response, max time.Duration) time.Duration {
+	wait := time.Second
+
+	if header := resp.Header().Get("Retry-After"); header != "" {
+		if seconds, err := strconv.Atoi(header); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(header); err == nil {
+			wait = time.Until(date)
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+	return wait
+}
+
+func requestHost(r *resty.Request) (string, bool) {
+	if r.RawRequest != nil {
+		return r.RawRequest.URL.Host, true
+	}
+
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.Host, true
+}
+
+// instrumentedTransport records every request, including repeated ones, in the upstream metrics
+type instrumentedTransport struct {
+	upstream string
+	base     http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	metrics.UpstreamDuration.WithLabelValues(t.upstream, r.Method).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.UpstreamRequests.WithLabelValues(t.upstream, r.Method, code).Inc()
+
+	return resp, err
+}
@@ -0,0 +1,154 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/pkg/swarfarmfake"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(20, 2)
+
+	// the burst is available right away, the next event has to wait for a new token
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3 events with a burst of 2 at 20/s took %v, want about 50ms", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	limiter.PauseFor(time.Second)
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() during a pause = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	// without rate only pauses apply
+	limiter := NewLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a shorter pause does not end a longer one
+	limiter.PauseFor(100 * time.Millisecond)
+	limiter.PauseFor(10 * time.Millisecond)
+
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Wait() after PauseFor(100ms) took %v", elapsed)
+	}
+}
+
+func TestLimiterFor(t *testing.T) {
+	options := Options{RateLimit: 5, Burst: 10}
+	limiter := limiterFor("limiterfor.example.com", options)
+
+	if got := limiterFor("limiterfor.example.com", options); got != limiter {
+		t.Error("clients with the same options do not share the limiter of a host")
+	}
+	if got := limiterFor("other.example.com", options); got == limiter {
+		t.Error("limiter is shared with another host")
+	}
+	if got := limiterFor("limiterfor.example.com", Options{RateLimit: 1, Burst: 1}); got == limiter {
+		t.Error("limiter is shared with a client with another rate limit")
+	}
+	if got := limiterFor("limiterfor.example.com", Options{RateLimit: 5, Burst: 1}); got == limiter {
+		t.Error("limiter is shared with a client with another burst")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		max    time.Duration
+		want   time.Duration
+	}{
+		{"no header", "", time.Minute, time.Second},
+		{"seconds", "5", time.Minute, 5 * time.Second},
+		{"seconds capped", "120", time.Minute, time.Minute},
+		{"seconds without cap", "120", 0, 2 * time.Minute},
+		{"date", time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), time.Minute, 10 * time.Second},
+		{"date capped", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Minute, time.Minute},
+		{"date in the past", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), time.Minute, 0},
+		{"invalid", "soon", time.Minute, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Retry-After", tt.header)
+			}
+			resp := &resty.Response{RawResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}}
+
+			// dates only have a resolution of seconds
+			if got := retryAfter(resp, tt.max); got > tt.want || got < tt.want-time.Second {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitedRoundTrip(t *testing.T) {
+	fake := swarfarmfake.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := New("roundtrip", Options{Timeout: 5 * time.Second, MaxRetries: 2, MaxRetryAfter: 20 * time.Millisecond})
+	requests := func(code string) float64 {
+		return testutil.ToFloat64(metrics.UpstreamRequests.WithLabelValues("roundtrip", http.MethodGet, code))
+	}
+	limited, ok := requests("429"), requests("200")
+
+	// the 429 is repeated after the Retry-After time, which is capped by MaxRetryAfter
+	fake.FailWith(http.StatusTooManyRequests, 1)
+	start := time.Now()
+	resp, err := client.R().Get(server.URL + swarfarmfake.DataLogsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("request with one retry took %v, want about 20ms", elapsed)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		t.Errorf("status code = %d, want %d after the retry", resp.StatusCode(), http.StatusOK)
+	}
+	if requests("429")-limited != 1 || requests("200")-ok != 1 {
+		t.Errorf("sent %v requests answered with 429 and %v with 200, want 1 each",
+			requests("429")-limited, requests("200")-ok)
+	}
+	limited = requests("429")
+
+	// the request is given up after the retries
+	fake.FailWith(http.StatusTooManyRequests, 0)
+	resp, err = client.R().Get(server.URL + swarfarmfake.DataLogsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusTooManyRequests {
+		t.Errorf("status code = %d, want %d after the retries", resp.StatusCode(), http.StatusTooManyRequests)
+	}
+	if requests("429")-limited != 3 {
+		t.Errorf("sent %v requests answered with 429, want the request and 2 retries", requests("429")-limited)
+	}
+}
@@ -0,0 +1,120 @@
+package httpclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiterKey identifies the limiter of a host. Clients with different rate limits for the same host get limiters of
+// their own, so the options of one client never apply to another.
+type limiterKey struct {
+	host      string
+	rateLimit float64
+	burst     int
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[limiterKey]*Limiter)
+)
+
+func limiterFor(host string, options Options) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	key := limiterKey{host: host, rateLimit: options.RateLimit, burst: options.Burst}
+	limiter, ok := limiters[key]
+	if !ok {
+		limiter = NewLimiter(options.RateLimit, options.Burst)
+		limiters[key] = limiter
+	}
+
+	return limiter
+}
+
+// Limiter is a token bucket which can additionally be paused, e.g. while an upstream asked to retry later.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// NewLimiter creates a limiter allowing rate events per second with bursts of up to burst events. A rate of 0 or
+// less only applies pauses.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until an event may happen or the context is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		wait := l.reserve()
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// PauseFor makes Wait block for at least d.
+func (l *Limiter) PauseFor(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// reserve takes a token and returns 0 or returns how long to wait before trying again
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return wait
+}
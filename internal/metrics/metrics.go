@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "swarpf"
+
+// Outcomes of an api event
+const (
+	OutcomeHandled = "handled"
+	OutcomeIgnored = "ignored"
+	OutcomeFailed  = "failed"
+)
+
+var (
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Number of api events received from the proxy.",
+	}, []string{"plugin", "command"})
+
+	EventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_processed_total",
+		Help:      "Number of api events by outcome (handled, ignored or failed).",
+	}, []string{"plugin", "command", "outcome"})
+
+	EventDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_duration_seconds",
+		Help:      "Time it took the plugin to handle an api event.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"plugin", "command"})
+
+	RejectedCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_calls_total",
+		Help:      "Number of calls to the plugin rejected because of a missing or invalid shared secret.",
+	}, []string{"plugin", "reason"})
+
+	Panics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_total",
+		Help:      "Number of panics recovered while a plugin handled an api event.",
+	}, []string{"plugin", "command"})
+
+	UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests sent to upstream services by HTTP status code. Requests without response have the code 'error'.",
+	}, []string{"upstream", "method", "code"})
+
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time it took upstream services to answer a request.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream", "method"})
+
+	FilesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_written_total",
+		Help:      "Number of files written by the export plugins by result (ok or error).",
+	}, []string{"plugin", "result"})
+
+	QueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_length",
+		Help:      "Number of jobs which are queued or running.",
+	}, []string{"queue"})
+
+	QueuedJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queued_jobs_total",
+		Help:      "Number of jobs run by a queue worker by the gRPC status code the api event would have been reported with.",
+	}, []string{"queue", "job", "code"})
+
+	SpoolLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "swarfarm_spool_length",
+		Help:      "Number of SWARFARM uploads waiting in the spool.",
+	})
+)
+
+// ObserveEvent records the outcome of an api event handled by a plugin.
+func ObserveEvent(plugin, command, outcome string, duration time.Duration) {
+	EventsReceived.WithLabelValues(plugin, command).Inc()
+	EventsProcessed.WithLabelValues(plugin, command, outcome).Inc()
+
+	if outcome != OutcomeIgnored {
+		EventDuration.WithLabelValues(plugin, command).Observe(duration.Seconds())
+	}
+}
+
+// FileWritten records a file written by an export plugin.
+func FileWritten(plugin string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	FilesWritten.WithLabelValues(plugin, result).Inc()
+}
+
+// Handler serves all metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -2,81 +2,388 @@ package proxyapiutil
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
 )
 
-func ConnectToProxyApi(proxyAddress string, delay bool) (*grpc.ClientConn, pb.ProxyApiClient,
-	context.Context, context.CancelFunc) {
-	if delay {
-		const nSeconds = 5
+// RegistrationOptions controls how a plugin registers itself at the proxy host.
+type RegistrationOptions struct {
+	// InitialBackoff is the time to wait after the first failed registration attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper limit for the time between two registration attempts.
+	MaxBackoff time.Duration
+	// AttemptTimeout is the time a single registration attempt may take.
+	AttemptTimeout time.Duration
+	// Deadline is the overall time the initial registration may take. Zero means retry forever.
+	Deadline time.Duration
+	// KeepaliveInterval enables gRPC keepalive pings on the connection to the proxy. Zero disables them.
+	// Note that the proxy has to permit pings at that rate, otherwise it will close the connection.
+	KeepaliveInterval time.Duration
+	// TransportCredentials secure the connection to the proxy, e.g. with TLS. Nil connects without encryption.
+	TransportCredentials credentials.TransportCredentials
+	// SharedSecret is sent to the proxy on registration in the SecretMetadataKey metadata. The proxy has to send it
+	// back with every api event. Empty sends no secret.
+	SharedSecret string
+}
+
+// SecretMetadataKey is the gRPC metadata key holding the shared secret of a plugin
+const SecretMetadataKey = "x-swarpf-plugin-secret"
 
-		delayTimer := time.NewTimer(nSeconds * time.Second)
-		log.Debug().Msgf("Delaying host registration by %d seconds", nSeconds)
-		<-delayTimer.C
+func DefaultRegistrationOptions() RegistrationOptions {
+	return RegistrationOptions{
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		AttemptTimeout: 5 * time.Second,
 	}
+}
+
+// Registration keeps a plugin registered at the proxy host. After the initial registration succeeded it watches
+// the connection to the proxy and registers the plugin again once the proxy comes back after a restart.
+type Registration struct {
+	proxyAddress       string
+	listenAddress      string
+	subscribedCommands []string
+	options            RegistrationOptions
+
+	mu                 sync.Mutex
+	conn               *grpc.ClientConn
+	cancel             context.CancelFunc
+	done               chan struct{}
+	commandsChanged    chan struct{}
+	registeredCommands []string
+	registered         bool
+	closed             bool
+}
 
-	log.Debug().
-		Str("proxyAddress", proxyAddress).
-		Msgf("Trying to connect to proxy api")
+func NewRegistration(proxyAddress, listenAddress string, subscribedCommands []string,
+	options RegistrationOptions) *Registration {
+	return &Registration{
+		proxyAddress:       proxyAddress,
+		listenAddress:      listenAddress,
+		subscribedCommands: subscribedCommands,
+		options:            options,
+		done:               make(chan struct{}),
+		commandsChanged:    make(chan struct{}, 1),
+	}
+}
+
+// Run registers the plugin at the proxy and keeps the registration alive until Close is called. It returns an error
+// if the connection could not be established or the initial registration did not succeed within the deadline. If
+// Close was called before, Run returns right away.
+func (r *Registration) Run() error {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		cancel()
+		return nil
+	}
+	r.cancel = cancel
+	r.mu.Unlock()
+	defer cancel()
 
-	dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer dialCancel()
-	conn, err := grpc.DialContext(dialCtx, proxyAddress, grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := r.dial()
 	if err != nil {
-		log.Fatal().Err(err).
-			Str("proxyAddress", proxyAddress).
-			Msg("Failed to connect to proxy api")
+		return err
+	}
+
+	initialCtx := ctx
+	if r.options.Deadline > 0 {
+		var initialCancel context.CancelFunc
+		initialCtx, initialCancel = context.WithTimeout(ctx, r.options.Deadline)
+		defer initialCancel()
 	}
 
-	c := pb.NewProxyApiClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	if err := r.register(initialCtx, conn); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	// watchdog: wait until the connection to the proxy is lost or the subscribed commands changed and register again
+	for {
+		lost, ok := r.waitForChange(ctx, conn)
+		if !ok {
+			return nil
+		}
+
+		r.setRegistered(false)
 
-	return conn, c, ctx, cancel
+		if lost {
+			log.Warn().
+				Str("proxyAddress", r.proxyAddress).
+				Str("state", conn.GetState().String()).
+				Msg("Lost connection to proxy api. Waiting for the proxy to come back")
+		} else {
+			log.Info().
+				Strs("commands", r.currentCommands()).
+				Msg("Subscribed commands changed. Registering again at proxy api")
+			r.disconnect(ctx, conn)
+		}
+
+		if err := r.register(ctx, conn); err != nil {
+			// the context was cancelled, which means the registration got closed
+			return nil
+		}
+	}
 }
 
-func RegisterWithProxyApi(proxyAddress, listenAddress string, subscribedCommands []string) {
-	conn, c, ctx, cancel := ConnectToProxyApi(proxyAddress, true)
+// UpdateSubscribedCommands changes the commands the plugin is subscribed to. If the plugin is registered already,
+// it is registered again with the new commands.
+func (r *Registration) UpdateSubscribedCommands(commands []string) {
+	r.mu.Lock()
+	if sameStrings(r.subscribedCommands, commands) {
+		r.mu.Unlock()
+		return
+	}
+	r.subscribedCommands = commands
+	r.mu.Unlock()
 
+	select {
+	case r.commandsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// waitForChange blocks until the connection to the proxy left the ready state or the subscribed commands changed.
+// It reports whether the connection was lost and returns false as second value if ctx is done.
+func (r *Registration) waitForChange(ctx context.Context, conn *grpc.ClientConn) (lost bool, ok bool) {
+	waitCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	defer tryCloseConnection(conn)
 
-	if _, err := c.Register(ctx, &pb.ProxyApiOptions{
-		Address:  listenAddress,
-		Commands: subscribedCommands,
-	}); err != nil {
-		log.Fatal().Err(err).
-			Str("proxyAddress", proxyAddress).
-			Str("listenAddress", listenAddress).
-			Msg("Failed to register myself at the proxy host")
+	stateChanged := make(chan bool, 1)
+	go func() {
+		stateChanged <- conn.WaitForStateChange(waitCtx, connectivity.Ready)
+	}()
+
+	select {
+	case changed := <-stateChanged:
+		return true, changed
+	case <-r.commandsChanged:
+		cancel()
+		<-stateChanged
+		return false, ctx.Err() == nil
 	}
+}
 
-	log.Info().Msg("Successfully registered at proxy api")
+// Registered reports whether the plugin is currently registered at the proxy.
+func (r *Registration) Registered() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.registered
 }
 
-func DisconnectFromProxyApi(proxyAddress, listenAddress string, subscribedCommands []string) {
-	conn, c, ctx, cancel := ConnectToProxyApi(proxyAddress, false)
+func (r *Registration) setRegistered(registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	defer cancel()
+	r.registered = registered
+}
+
+// Close stops watching the connection to the proxy and disconnects the plugin from the proxy.
+func (r *Registration) Close() {
+	r.mu.Lock()
+	r.closed = true
+	cancel, conn := r.cancel, r.conn
+	r.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-r.done
+	r.setRegistered(false)
+
+	if conn == nil {
+		return
+	}
 	defer tryCloseConnection(conn)
 
-	if _, err := c.Disconnect(ctx, &pb.ProxyApiOptions{
-		Address:  listenAddress,
-		Commands: subscribedCommands,
+	r.disconnect(context.Background(), conn)
+}
+
+// disconnect removes the registration with the commands the plugin is currently registered with from the proxy
+func (r *Registration) disconnect(ctx context.Context, conn *grpc.ClientConn) {
+	r.mu.Lock()
+	commands := r.registeredCommands
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.withSecret(ctx), r.options.AttemptTimeout)
+	defer cancel()
+
+	if _, err := pb.NewProxyApiClient(conn).Disconnect(ctx, &pb.ProxyApiOptions{
+		Address:  r.listenAddress,
+		Commands: commands,
 	}); err != nil {
-		log.Fatal().Err(err).
-			Str("proxyAddress", proxyAddress).
-			Str("listenAddress", listenAddress).
+		log.Error().Err(err).
+			Str("proxyAddress", r.proxyAddress).
+			Str("listenAddress", r.listenAddress).
 			Msg("Failed to disconnect myself from the proxy api")
+		return
 	}
 
 	log.Info().Msg("Successfully disconnected from proxy api")
 }
 
+// withSecret adds the shared secret to the metadata of a call to the proxy
+func (r *Registration) withSecret(ctx context.Context) context.Context {
+	if r.options.SharedSecret == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, SecretMetadataKey, r.options.SharedSecret)
+}
+
+func (r *Registration) dial() (*grpc.ClientConn, error) {
+	dialOptions := []grpc.DialOption{grpc.WithInsecure()}
+	if r.options.TransportCredentials != nil {
+		dialOptions = []grpc.DialOption{grpc.WithTransportCredentials(r.options.TransportCredentials)}
+	}
+	if r.options.KeepaliveInterval > 0 {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                r.options.KeepaliveInterval,
+			Timeout:             r.options.AttemptTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	conn, err := grpc.Dial(r.proxyAddress, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	return conn, nil
+}
+
+// register tries to register the plugin at the proxy until it succeeds or ctx is done. Failed attempts are retried
+// with an exponential backoff.
+func (r *Registration) register(ctx context.Context, conn *grpc.ClientConn) error {
+	client := pb.NewProxyApiClient(conn)
+	backoff := r.options.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		log.Debug().
+			Str("proxyAddress", r.proxyAddress).
+			Int("attempt", attempt).
+			Msg("Trying to register at proxy api")
+
+		options := r.proxyApiOptions()
+		attemptCtx, cancel := context.WithTimeout(r.withSecret(ctx), r.options.AttemptTimeout)
+		_, err := client.Register(attemptCtx, options, grpc.WaitForReady(true))
+		cancel()
+
+		if err == nil {
+			r.mu.Lock()
+			r.registeredCommands = options.Commands
+			r.registered = true
+			r.mu.Unlock()
+
+			log.Info().Int("attempt", attempt).Msg("Successfully registered at proxy api")
+			return nil
+		}
+
+		wait := jitter(backoff)
+		log.Warn().Err(err).
+			Str("proxyAddress", r.proxyAddress).
+			Str("listenAddress", r.listenAddress).
+			Int("attempt", attempt).
+			Dur("retryIn", wait).
+			Msg("Failed to register myself at the proxy host")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errors.New("registration deadline exceeded")
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		// the initial backoff is the lower limit, so a maximum of zero does not retry in a busy loop
+		backoff *= 2
+		if backoff > r.options.MaxBackoff {
+			backoff = r.options.MaxBackoff
+		}
+		if backoff < r.options.InitialBackoff {
+			backoff = r.options.InitialBackoff
+		}
+	}
+}
+
+func (r *Registration) proxyApiOptions() *pb.ProxyApiOptions {
+	return &pb.ProxyApiOptions{
+		Address:  r.listenAddress,
+		Commands: r.currentCommands(),
+	}
+}
+
+func (r *Registration) currentCommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.subscribedCommands
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// jitterRand is seeded differently in every process, so plugins started together do not retry in lockstep
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration between d/2 and d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	half := int64(d / 2)
+	return time.Duration(half + jitterRand.Int63n(half+1))
+}
+
 func tryCloseConnection(conn *grpc.ClientConn) {
 	if err := conn.Close(); err != nil {
 		log.Error().Err(err).Msg("Failed to close connection")
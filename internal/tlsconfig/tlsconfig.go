@@ -0,0 +1,244 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// reloadCheckInterval is the minimum time between two checks whether the certificate files changed
+const reloadCheckInterval = time.Second
+
+// Options configures one side of a TLS connection.
+type Options struct {
+	// CertFile and KeyFile hold the certificate presented to the other side. For a client they are optional and
+	// only needed if the server requires client certificates.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the certificates used to verify the other side. For a client, empty uses the system roots. For
+	// a server, a CA file enables mutual TLS as configured by ClientAuth.
+	CAFile string
+	// ClientAuth decides whether a server with a CA file requires client certificates. Empty requires them.
+	// Server only.
+	ClientAuth ClientAuth
+	// ServerName overrides the name the server certificate is verified against. Client only.
+	ServerName string
+}
+
+// ClientAuth is the policy of a server for client certificates
+type ClientAuth string
+
+const (
+	// RequireClientCert rejects clients without a certificate signed by the CA file
+	RequireClientCert ClientAuth = "require"
+	// VerifyClientCertIfGiven accepts clients without certificate, but a certificate has to be signed by the CA file
+	VerifyClientCertIfGiven ClientAuth = "verify_if_given"
+	// NoClientCert does not ask clients for a certificate
+	NoClientCert ClientAuth = "none"
+)
+
+func ParseClientAuth(clientAuth string) (ClientAuth, error) {
+	switch ClientAuth(clientAuth) {
+	case RequireClientCert, VerifyClientCertIfGiven, NoClientCert:
+		return ClientAuth(clientAuth), nil
+	default:
+		return "", fmt.Errorf("unknown client auth '%s'. Valid values are require, verify_if_given and none",
+			clientAuth)
+	}
+}
+
+func (a ClientAuth) tlsClientAuth() tls.ClientAuthType {
+	switch a {
+	case VerifyClientCertIfGiven:
+		return tls.VerifyClientCertIfGiven
+	case NoClientCert:
+		return tls.NoClientCert
+	default:
+		return tls.RequireAndVerifyClientCert
+	}
+}
+
+func (o Options) files() []string {
+	files := make([]string, 0, 3)
+	for _, f := range []string{o.CertFile, o.KeyFile, o.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Credentials are gRPC transport credentials whose certificates are loaded again whenever one of the files changed,
+// so certificates can be renewed without restarting the plugin. The files are checked on every handshake.
+type Credentials struct {
+	options Options
+	server  bool
+
+	mu          sync.Mutex
+	config      *tls.Config
+	modTimes    map[string]time.Time
+	lastChecked time.Time
+}
+
+// NewServerCredentials loads the certificate of a server. CertFile and KeyFile are required.
+func NewServerCredentials(options Options) (*Credentials, error) {
+	if options.CertFile == "" || options.KeyFile == "" {
+		return nil, errors.New("TLS server needs a certificate and a key file")
+	}
+
+	return newCredentials(options, true)
+}
+
+// NewClientCredentials loads the certificates of a client.
+func NewClientCredentials(options Options) (*Credentials, error) {
+	if (options.CertFile == "") != (options.KeyFile == "") {
+		return nil, errors.New("TLS client certificate needs both a certificate and a key file")
+	}
+
+	return newCredentials(options, false)
+}
+
+func newCredentials(options Options, server bool) (*Credentials, error) {
+	c := &Credentials{options: options, server: server}
+
+	config, modTimes, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.config = config
+	c.modTimes = modTimes
+	c.lastChecked = time.Now()
+
+	return c, nil
+}
+
+func (c *Credentials) load() (*tls.Config, map[string]time.Time, error) {
+	// read the modification times first, so a file changed while loading is loaded again next time
+	modTimes := make(map[string]time.Time)
+	for _, f := range c.options.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		modTimes[f] = fi.ModTime()
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.options.ServerName}
+
+	if c.options.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.options.CertFile, c.options.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.options.CAFile != "" {
+		content, err := ioutil.ReadFile(c.options.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, nil, fmt.Errorf("no certificates found in TLS CA file %s", c.options.CAFile)
+		}
+
+		if c.server {
+			config.ClientCAs = pool
+			config.ClientAuth = c.options.ClientAuth.tlsClientAuth()
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	return config, modTimes, nil
+}
+
+// Config returns the current TLS configuration. If one of the files changed, it is loaded again first. If that
+// fails, the previous configuration is kept.
+func (c *Credentials) Config() *tls.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastChecked) < reloadCheckInterval {
+		return c.config
+	}
+	c.lastChecked = time.Now()
+
+	if !c.changed() {
+		return c.config
+	}
+
+	config, modTimes, err := c.load()
+	if err != nil {
+		log.Error().Err(err).Strs("files", c.options.files()).Msg("Failed to reload TLS certificates. Keeping the previous ones")
+		return c.config
+	}
+
+	log.Info().Strs("files", c.options.files()).Msg("Reloaded TLS certificates")
+
+	c.config = config
+	c.modTimes = modTimes
+	return c.config
+}
+
+func (c *Credentials) changed() bool {
+	for f, modTime := range c.modTimes {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Credentials) current() credentials.TransportCredentials {
+	return credentials.NewTLS(c.Config())
+}
+
+func (c *Credentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *Credentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ServerHandshake(conn)
+}
+
+func (c *Credentials) Info() credentials.ProtocolInfo {
+	return c.current().Info()
+}
+
+func (c *Credentials) Clone() credentials.TransportCredentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &Credentials{
+		options:     c.options,
+		server:      c.server,
+		config:      c.config,
+		modTimes:    c.modTimes,
+		lastChecked: c.lastChecked,
+	}
+}
+
+func (c *Credentials) OverrideServerName(serverName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.options.ServerName = serverName
+	c.config = c.config.Clone()
+	c.config.ServerName = serverName
+	return nil
+}
@@ -0,0 +1,331 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+func TestClientCertificateRequired(t *testing.T) {
+	ca := newTestCA(t)
+	server := serverCredentials(t, ca, "server", Options{CAFile: ca.certFile})
+
+	// without client certificate the server rejects the handshake
+	client, err := NewClientCredentials(Options{CAFile: ca.certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, serverErr := handshake(t, client, server); serverErr == nil {
+		t.Error("server accepted a handshake without client certificate")
+	}
+
+	// a client certificate signed by the CA is accepted
+	options := ca.issue(t, "client", false)
+	options.CAFile = ca.certFile
+	client, err = NewClientCredentials(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, serverErr := handshake(t, client, server); serverErr != nil {
+		t.Errorf("server rejected a handshake with client certificate: %v", serverErr)
+	}
+
+	// a client certificate of another CA is rejected
+	options = newTestCA(t).issue(t, "client", false)
+	options.CAFile = ca.certFile
+	client, err = NewClientCredentials(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, serverErr := handshake(t, client, server); serverErr == nil {
+		t.Error("server accepted a client certificate of another CA")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	withoutCert, err := NewClientCredentials(Options{CAFile: ca.certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := ca.issue(t, "client", false)
+	options.CAFile = ca.certFile
+	withCert, err := NewClientCredentials(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options = newTestCA(t).issue(t, "client", false)
+	options.CAFile = ca.certFile
+	withOtherCert, err := NewClientCredentials(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clientAuth ClientAuth
+		// accepted are the clients without certificate, with a certificate of the CA and of another CA
+		accepted [3]bool
+	}{
+		{"", [3]bool{false, true, false}},
+		{RequireClientCert, [3]bool{false, true, false}},
+		{VerifyClientCertIfGiven, [3]bool{true, true, false}},
+		{NoClientCert, [3]bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		server := serverCredentials(t, ca, "server", Options{CAFile: ca.certFile, ClientAuth: tt.clientAuth})
+
+		for i, client := range []*Credentials{withoutCert, withCert, withOtherCert} {
+			if _, serverErr := handshake(t, client, server); (serverErr == nil) != tt.accepted[i] {
+				t.Errorf("client auth %q: client %d accepted = %v, want %v (%v)", tt.clientAuth, i, serverErr == nil,
+					tt.accepted[i], serverErr)
+			}
+		}
+	}
+
+	if _, err := ParseClientAuth("optional"); err == nil {
+		t.Error("ParseClientAuth(optional) succeeded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca := newTestCA(t)
+	server := serverCredentials(t, ca, "first", Options{})
+	client, err := NewClientCredentials(Options{CAFile: ca.certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkServedCertificate(t, client, server, "first")
+
+	// the renewed certificate replaces the files of the previous one
+	rotated := ca.issue(t, "second", true)
+	for from, to := range map[string]string{rotated.CertFile: server.options.CertFile, rotated.KeyFile: server.options.KeyFile} {
+		if err := os.Rename(from, to); err != nil {
+			t.Fatal(err)
+		}
+		// make sure the modification time changed even on file systems with a coarse resolution
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(to, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the files are only checked again after reloadCheckInterval
+	checkServedCertificate(t, client, server, "first")
+	expireCheck(server)
+	checkServedCertificate(t, client, server, "second")
+
+	// a broken certificate is not loaded, the previous one is kept
+	if err := ioutil.WriteFile(server.options.CertFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(server)
+	checkServedCertificate(t, client, server, "second")
+}
+
+func TestNewCredentials(t *testing.T) {
+	ca := newTestCA(t)
+	options := ca.issue(t, "server", true)
+
+	if _, err := NewServerCredentials(Options{CertFile: options.CertFile}); err == nil {
+		t.Error("NewServerCredentials() without key file succeeded")
+	}
+	if _, err := NewClientCredentials(Options{KeyFile: options.KeyFile}); err == nil {
+		t.Error("NewClientCredentials() without certificate file succeeded")
+	}
+	if _, err := NewServerCredentials(Options{CertFile: options.CertFile, KeyFile: options.KeyFile, CAFile: options.KeyFile}); err == nil {
+		t.Error("NewServerCredentials() with a CA file without certificates succeeded")
+	}
+	if _, err := NewClientCredentials(Options{CAFile: filepath.Join(ca.directory, "missing.pem")}); err == nil {
+		t.Error("NewClientCredentials() with a missing CA file succeeded")
+	}
+}
+
+// testCA is a throwaway certificate authority which issues certificates for localhost
+type testCA struct {
+	directory string
+	certFile  string
+	cert      *x509.Certificate
+	key       *ecdsa.PrivateKey
+	serial    int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	ca := &testCA{directory: directory, certFile: filepath.Join(directory, "ca.pem"), serial: 1}
+	ca.key = generateKey(t)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(ca.serial),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, ca.certFile, "CERTIFICATE", der)
+
+	return ca
+}
+
+// issue writes a certificate and key signed by the CA and returns their files
+func (ca *testCA) issue(t *testing.T, commonName string, server bool) Options {
+	t.Helper()
+
+	ca.serial++
+	key := generateKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := Options{
+		CertFile: filepath.Join(ca.directory, commonName+".pem"),
+		KeyFile:  filepath.Join(ca.directory, commonName+"-key.pem"),
+	}
+	writePEM(t, options.CertFile, "CERTIFICATE", der)
+	writePEM(t, options.KeyFile, "EC PRIVATE KEY", keyDer)
+
+	return options
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverCredentials issues a server certificate and loads it together with the other options
+func serverCredentials(t *testing.T, ca *testCA, commonName string, options Options) *Credentials {
+	t.Helper()
+
+	issued := ca.issue(t, commonName, true)
+	options.CertFile, options.KeyFile = issued.CertFile, issued.KeyFile
+
+	server, err := NewServerCredentials(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// expireCheck lets the next handshake check the files again without waiting for reloadCheckInterval
+func expireCheck(c *Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastChecked = time.Time{}
+}
+
+// handshake connects client and server over TCP and returns the results of both sides of the handshake
+func handshake(t *testing.T, client, server *Credentials) (credentials.AuthInfo, error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, _, err = server.ServerHandshake(conn)
+		serverErr <- err
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// with TLS 1.3 the client finishes its handshake before the server verified the client certificate, so
+	// only the result of the server is conclusive
+	_, authInfo, _ := client.ClientHandshake(ctx, "localhost", conn)
+
+	return authInfo, <-serverErr
+}
+
+func checkServedCertificate(t *testing.T, client, server *Credentials, want string) {
+	t.Helper()
+
+	authInfo, err := handshake(t, client, server)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+
+	tlsInfo, ok := authInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		t.Fatalf("client handshake returned %#v, want the server certificate", authInfo)
+	}
+	if name := tlsInfo.State.PeerCertificates[0].Subject.CommonName; name != want {
+		t.Errorf("server presented certificate %q, want %q", name, want)
+	}
+}
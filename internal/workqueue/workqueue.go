@@ -0,0 +1,244 @@
+package workqueue
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+// Policy decides what happens to a job which is enqueued while the queue is full.
+type Policy string
+
+const (
+	// Block waits until a worker picked up a job and there is room in the queue again.
+	Block Policy = "block"
+	// DropOldest removes the oldest queued job to make room for the new one.
+	DropOldest Policy = "drop-oldest"
+	// Spill removes the oldest queued job and hands it to its Spill function, e.g. to store it on disk. A job is only
+	// spilled after the running job with the same key finished, so it can not overtake it. Jobs without a Spill
+	// function are handled like Block.
+	Spill Policy = "spill"
+)
+
+func ParsePolicy(policy string) (Policy, error) {
+	switch Policy(policy) {
+	case Block, DropOldest, Spill:
+		return Policy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown queue policy '%s'. Valid policies are block, drop-oldest and spill", policy)
+	}
+}
+
+// Job is a unit of work executed by a worker of the queue.
+type Job struct {
+	// Key identifies jobs which have to be executed in order. Jobs with the same key are always executed by the same
+	// worker in the order they were enqueued.
+	Key string
+	// Name is used in log messages.
+	Name string
+	// Run executes the job.
+	Run func()
+	// Spill is called instead of Run if the job is removed from a full queue with the Spill policy.
+	Spill func() error
+}
+
+// Options configures a queue.
+type Options struct {
+	Workers int
+	Size    int
+	Policy  Policy
+}
+
+// Queue is a bounded in-memory job queue served by a fixed number of workers.
+type Queue struct {
+	name    string
+	policy  Policy
+	shards  []chan Job
+	mu      sync.RWMutex
+	closed  bool
+	next    uint32
+	pending int64
+	wg      sync.WaitGroup
+
+	runningMu sync.Mutex
+	running   map[string]chan struct{}
+}
+
+// New creates a queue and starts its workers. The capacity of the queue is split evenly between the workers.
+func New(name string, options Options) *Queue {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+
+	size := options.Size / options.Workers
+	if size < 1 {
+		size = 1
+	}
+
+	q := &Queue{
+		name:    name,
+		policy:  options.Policy,
+		shards:  make([]chan Job, options.Workers),
+		running: make(map[string]chan struct{}),
+	}
+
+	for i := range q.shards {
+		q.shards[i] = make(chan Job, size)
+
+		q.wg.Add(1)
+		go q.work(q.shards[i])
+	}
+
+	return q
+}
+
+// Enqueue adds a job to the queue. Depending on the policy of the queue it blocks while the queue is full. Jobs
+// enqueued after the queue was closed are dropped. Jobs must not enqueue jobs with their own key.
+func (q *Queue) Enqueue(job Job) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		log.Warn().Str("queue", q.name).Str("job", job.Name).Msg("Queue is closed. Dropping job")
+		return
+	}
+
+	shard := q.shards[q.shardIndex(job.Key)]
+	q.addPending(1)
+
+	for {
+		select {
+		case shard <- job:
+			return
+		default:
+		}
+
+		if q.policy == Block || (q.policy == Spill && job.Spill == nil) {
+			shard <- job
+			return
+		}
+
+		// make room by removing the oldest job of the shard
+		select {
+		case oldest := <-shard:
+			q.addPending(-1)
+			q.evict(oldest)
+		default:
+		}
+	}
+}
+
+// Len returns the number of jobs which are queued or running.
+func (q *Queue) Len() int {
+	return int(atomic.LoadInt64(&q.pending))
+}
+
+// Close stops accepting new jobs and waits up to timeout for the queued jobs to finish. After the timeout Close
+// returns, but the workers keep running the remaining jobs in the background until the process ends.
+func (q *Queue) Close(timeout time.Duration) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, shard := range q.shards {
+		close(shard)
+	}
+	q.mu.Unlock()
+
+	log.Info().Str("queue", q.name).Int("pending", q.Len()).Msg("Draining queue")
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Str("queue", q.name).Msg("Queue drained")
+	case <-time.After(timeout):
+		log.Warn().Str("queue", q.name).Int("pending", q.Len()).Msg("Timeout while draining queue. Continuing with the remaining jobs in background")
+	}
+}
+
+func (q *Queue) work(shard chan Job) {
+	defer q.wg.Done()
+
+	for job := range shard {
+		q.run(job)
+		q.addPending(-1)
+	}
+}
+
+// run executes a job and records it as running until it finished
+func (q *Queue) run(job Job) {
+	if job.Key == "" {
+		job.Run()
+		return
+	}
+
+	done := make(chan struct{})
+	q.runningMu.Lock()
+	q.running[job.Key] = done
+	q.runningMu.Unlock()
+
+	defer func() {
+		q.runningMu.Lock()
+		delete(q.running, job.Key)
+		q.runningMu.Unlock()
+		close(done)
+	}()
+
+	job.Run()
+}
+
+// waitForRunning waits until the running job with the key finished
+func (q *Queue) waitForRunning(key string) {
+	q.runningMu.Lock()
+	done, ok := q.running[key]
+	q.runningMu.Unlock()
+
+	if ok {
+		<-done
+	}
+}
+
+func (q *Queue) addPending(delta int64) {
+	pending := atomic.AddInt64(&q.pending, delta)
+	metrics.QueueLength.WithLabelValues(q.name).Set(float64(pending))
+}
+
+func (q *Queue) evict(job Job) {
+	if q.policy == Spill && job.Spill != nil {
+		// the evicted job is the oldest queued one of its shard, so only the running job can be older
+		q.waitForRunning(job.Key)
+
+		err := job.Spill()
+		if err == nil {
+			log.Warn().Str("queue", q.name).Str("job", job.Name).Msg("Queue is full. Spilled oldest job")
+			return
+		}
+
+		log.Error().Err(err).Str("queue", q.name).Str("job", job.Name).Msg("Failed to spill job")
+	}
+
+	log.Warn().Str("queue", q.name).Str("job", job.Name).Msg("Queue is full. Dropped oldest job")
+}
+
+func (q *Queue) shardIndex(key string) int {
+	if key == "" {
+		return int(atomic.AddUint32(&q.next, 1) % uint32(len(q.shards)))
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(q.shards)))
+}
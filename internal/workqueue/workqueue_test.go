@@ -0,0 +1,208 @@
+package workqueue_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swarpf/plugins/internal/workqueue"
+)
+
+// recorder records the events of the jobs of a test in the order they happened
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.events...)
+}
+
+// startBlockingJob enqueues a job which runs until release is closed and waits until a worker picked it up
+func startBlockingJob(q *workqueue.Queue, r *recorder, key string, release chan struct{}) {
+	started := make(chan struct{})
+	q.Enqueue(workqueue.Job{Key: key, Name: "blocking", Run: func() {
+		close(started)
+		<-release
+		r.record("run blocking")
+	}})
+	<-started
+}
+
+func TestShardAffinity(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 4, Size: 100, Policy: workqueue.Block})
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+	executed := map[string][]int{}
+
+	for i := 0; i < 100; i++ {
+		key, i := fmt.Sprintf("wizard%d", i%5), i
+		q.Enqueue(workqueue.Job{Key: key, Name: "job", Run: func() {
+			mu.Lock()
+			if running[key] {
+				t.Errorf("two jobs of %s run at the same time", key)
+			}
+			running[key] = true
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running[key] = false
+			executed[key] = append(executed[key], i)
+			mu.Unlock()
+		}})
+	}
+	q.Close(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, jobs := range executed {
+		if len(jobs) != 20 {
+			t.Errorf("%d jobs of %s were executed, want 20", len(jobs), key)
+		}
+		for i := 1; i < len(jobs); i++ {
+			if jobs[i] < jobs[i-1] {
+				t.Errorf("jobs of %s were executed in order %v", key, jobs)
+				break
+			}
+		}
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 1, Size: 2, Policy: workqueue.DropOldest})
+	r := &recorder{}
+
+	release := make(chan struct{})
+	startBlockingJob(q, r, "a", release)
+
+	for i := 1; i <= 3; i++ {
+		i := i
+		q.Enqueue(workqueue.Job{Key: "a", Name: "job", Run: func() { r.record("run %d", i) }})
+	}
+	if n := q.Len(); n != 3 {
+		t.Errorf("Len() = %d, want the running and 2 queued jobs", n)
+	}
+
+	close(release)
+	q.Close(5 * time.Second)
+
+	if events, want := r.Events(), []string{"run blocking", "run 2", "run 3"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 1, Size: 1, Policy: workqueue.Spill})
+	r := &recorder{}
+
+	release := make(chan struct{})
+	startBlockingJob(q, r, "a", release)
+
+	job := func(i int) workqueue.Job {
+		return workqueue.Job{
+			Key:   "a",
+			Name:  "job",
+			Run:   func() { r.record("run %d", i) },
+			Spill: func() error { r.record("spill %d", i); return nil },
+		}
+	}
+	q.Enqueue(job(1))
+
+	// job 1 is spilled to make room for job 2, but only after the running job of its key finished
+	enqueued := make(chan struct{})
+	go func() {
+		q.Enqueue(job(2))
+		close(enqueued)
+	}()
+
+	select {
+	case <-enqueued:
+		t.Fatal("job 1 was spilled while an older job with the same key was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-enqueued
+	q.Close(5 * time.Second)
+
+	if events, want := r.Events(), []string{"run blocking", "spill 1", "run 2"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestSpillOtherKey(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 1, Size: 1, Policy: workqueue.Spill})
+	r := &recorder{}
+
+	release := make(chan struct{})
+	startBlockingJob(q, r, "a", release)
+
+	// jobs of other keys are spilled right away
+	for i := 1; i <= 2; i++ {
+		i := i
+		q.Enqueue(workqueue.Job{Key: "b", Name: "job", Run: func() { r.record("run %d", i) },
+			Spill: func() error { r.record("spill %d", i); return nil }})
+	}
+	if events, want := r.Events(), []string{"spill 1"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events while the job of a runs = %v, want %v", events, want)
+	}
+
+	close(release)
+	q.Close(5 * time.Second)
+}
+
+func TestClose(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 2, Size: 10, Policy: workqueue.Block})
+	r := &recorder{}
+
+	for i := 0; i < 5; i++ {
+		i := i
+		q.Enqueue(workqueue.Job{Key: "a", Name: "job", Run: func() {
+			time.Sleep(time.Millisecond)
+			r.record("run %d", i)
+		}})
+	}
+
+	// the queued jobs are drained, jobs enqueued afterwards are dropped
+	q.Close(5 * time.Second)
+	q.Enqueue(workqueue.Job{Key: "a", Name: "job", Run: func() { r.record("run late") }})
+
+	if events := r.Events(); len(events) != 5 || events[4] != "run 4" {
+		t.Errorf("events = %v, want 5 jobs executed before Close returned", events)
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("Len() after Close = %d, want 0", n)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	q := workqueue.New("test", workqueue.Options{Workers: 1, Size: 1, Policy: workqueue.Block})
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	startBlockingJob(q, &recorder{}, "a", release)
+
+	start := time.Now()
+	q.Close(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close() took %v with a timeout of 50ms", elapsed)
+	}
+	if n := q.Len(); n != 1 {
+		t.Errorf("Len() = %d, want the running job", n)
+	}
+}
@@ -0,0 +1,328 @@
+// Package anonymiser scrubs captured game traffic and exported files, so they can be shared in bug reports or added
+// to the fixture corpus. Ids of wizards, guilds and units are replaced by pseudonyms which are the same in every file
+// anonymised by one Anonymiser, so cross references between events and files still work. Names get pseudonyms as
+// well, chat content and secrets like session keys are emptied.
+package anonymiser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// kind of a value which gets a pseudonym
+type kind string
+
+const (
+	wizardKind     kind = "wizard"
+	guildKind      kind = "guild"
+	unitKind       kind = "unit"
+	wizardNameKind kind = "wizard_name"
+	guildNameKind  kind = "guild_name"
+)
+
+// pseudonymBases are added to the number of a pseudonym id, so pseudonyms of different kinds do not look alike
+var pseudonymBases = map[kind]int64{
+	wizardKind: 10000,
+	guildKind:  2000,
+	unitKind:   20000000,
+}
+
+// idFields are the fields holding ids which are replaced by pseudonyms. Lists like unit_lock_list contain ids only.
+var idFields = map[string]kind{
+	"wizard_id":         wizardKind,
+	"opp_wizard_id":     wizardKind,
+	"target_wizard_id":  wizardKind,
+	"friend_wizard_id":  wizardKind,
+	"attack_wizard_id":  wizardKind,
+	"defense_wizard_id": wizardKind,
+	"guild_id":          guildKind,
+	"opp_guild_id":      guildKind,
+	"unit_id":           unitKind,
+	"rep_unit_id":       unitKind,
+	"occupied_id":       unitKind,
+	"unit_lock_list":    unitKind,
+}
+
+// nameFields are the fields holding names which are replaced by pseudonyms
+var nameFields = map[string]kind{
+	"wizard_name":         wizardNameKind,
+	"opp_wizard_name":     wizardNameKind,
+	"attack_wizard_name":  wizardNameKind,
+	"defense_wizard_name": wizardNameKind,
+	"guild_name":          guildNameKind,
+	"opp_guild_name":      guildNameKind,
+}
+
+// guildObjects are the objects whose name field is the name of a guild
+var guildObjects = map[string]bool{
+	"guild":      true,
+	"guild_info": true,
+}
+
+// strippedFields are emptied: secrets of the session and the device, and chat or other free text written by players
+var strippedFields = map[string]bool{
+	"session_key":     true,
+	"infocsv":         true,
+	"channel_uid":     true,
+	"hive_uid":        true,
+	"uid":             true,
+	"did":             true,
+	"udid":            true,
+	"device_id":       true,
+	"adid":            true,
+	"idfa":            true,
+	"ip":              true,
+	"token":           true,
+	"auth_token":      true,
+	"access_token":    true,
+	"api_token":       true,
+	"push_token":      true,
+	"chat_message":    true,
+	"message":         true,
+	"msg":             true,
+	"notice":          true,
+	"guild_notice":    true,
+	"intro":           true,
+	"introduction":    true,
+	"comment":         true,
+	"homunculus_name": true,
+}
+
+// Anonymiser replaces ids and names by pseudonyms. It is safe for concurrent use.
+type Anonymiser struct {
+	mu         sync.Mutex
+	pseudonyms map[kind]map[string]string
+}
+
+func New() *Anonymiser {
+	return &Anonymiser{pseudonyms: make(map[kind]map[string]string)}
+}
+
+// JSON anonymises a JSON document. Numbers are kept exactly, object keys are sorted in the result.
+func (a *Anonymiser) JSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(a.Value(document))
+}
+
+// Value anonymises a JSON document decoded into interface{} values, preferably with json.Decoder.UseNumber. Maps and
+// slices are changed in place.
+func (a *Anonymiser) Value(document interface{}) interface{} {
+	return a.value("", "", document)
+}
+
+// value anonymises v, which is the value of the field key in the object parent
+func (a *Anonymiser) value(parent, key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// in the order of the keys, so the same input always gets the same pseudonyms
+		keys := make([]string, 0, len(v))
+		for childKey := range v {
+			keys = append(keys, childKey)
+		}
+		sort.Strings(keys)
+
+		for _, childKey := range keys {
+			v[childKey] = a.value(key, childKey, v[childKey])
+		}
+		return v
+	case []interface{}:
+		for i, element := range v {
+			v[i] = a.value(parent, key, element)
+		}
+		return v
+	}
+
+	if strippedFields[key] {
+		return empty(v)
+	}
+	if k, ok := idFields[key]; ok {
+		return a.id(k, v)
+	}
+	if k, ok := nameFields[key]; ok {
+		return a.name(k, v)
+	}
+	if key == "name" && guildObjects[parent] {
+		return a.name(guildNameKind, v)
+	}
+
+	return v
+}
+
+// empty returns the empty value of the type of v
+func empty(v interface{}) interface{} {
+	switch v.(type) {
+	case string:
+		return ""
+	case json.Number, float64:
+		return json.Number("0")
+	case bool:
+		return false
+	default:
+		return nil
+	}
+}
+
+// id returns the pseudonym of an id. Ids can be numbers or strings, the pseudonym has the same type. 0 means no
+// id and is kept.
+func (a *Anonymiser) id(k kind, v interface{}) interface{} {
+	var original string
+	switch v := v.(type) {
+	case json.Number:
+		original = v.String()
+	case float64:
+		original = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		original = v
+	default:
+		return v
+	}
+
+	if original == "" || original == "0" {
+		return v
+	}
+
+	pseudonym := a.pseudonym(k, original, func(n int) string {
+		return strconv.FormatInt(pseudonymBases[k]+int64(n), 10)
+	})
+
+	if _, ok := v.(string); ok {
+		return pseudonym
+	}
+	return json.Number(pseudonym)
+}
+
+func (a *Anonymiser) name(k kind, v interface{}) interface{} {
+	original, ok := v.(string)
+	if !ok || original == "" {
+		return v
+	}
+
+	prefix := "Wizard"
+	if k == guildNameKind {
+		prefix = "Guild"
+	}
+
+	return a.pseudonym(k, original, func(n int) string { return fmt.Sprintf("%s-%d", prefix, n) })
+}
+
+// pseudonym returns the pseudonym of the original value, assigning the next one with format if it has none yet
+func (a *Anonymiser) pseudonym(k kind, original string, format func(n int) string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pseudonyms, ok := a.pseudonyms[k]
+	if !ok {
+		pseudonyms = make(map[string]string)
+		a.pseudonyms[k] = pseudonyms
+	}
+
+	if pseudonym, ok := pseudonyms[original]; ok {
+		return pseudonym
+	}
+
+	pseudonym := format(len(pseudonyms) + 1)
+	pseudonyms[original] = pseudonym
+	return pseudonym
+}
+
+// FileName replaces a known wizard name or id in a file name by its pseudonym. Names of the profile export
+// (<wizard name>-<wizard id>.json) are recognized as well.
+func (a *Anonymiser) FileName(name string) string {
+	extension := ""
+	if i := strings.Index(name, "."); i >= 0 {
+		name, extension = name[:i], name[i:]
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if pseudonym, ok := a.known(name); ok {
+		return pseudonym + extension
+	}
+
+	// wizard names can contain dashes themselves, so only the last dash separates the parts
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		prefix, prefixKnown := a.known(name[:i])
+		suffix, suffixKnown := a.known(name[i+1:])
+		if prefixKnown || suffixKnown {
+			return prefix + "-" + suffix + extension
+		}
+	}
+
+	return name + extension
+}
+
+// known returns the pseudonym of a wizard name or id, or the value itself. It is called with mu held.
+func (a *Anonymiser) known(value string) (string, bool) {
+	for _, k := range []kind{wizardNameKind, wizardKind} {
+		if pseudonym, ok := a.pseudonyms[k][value]; ok {
+			return pseudonym, true
+		}
+	}
+	return value, false
+}
+
+// Mapping returns the pseudonyms assigned so far by kind and original value. It contains the original values and
+// must not be shared with the anonymised files.
+func (a *Anonymiser) Mapping() map[string]map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	mapping := make(map[string]map[string]string)
+	for k, pseudonyms := range a.pseudonyms {
+		mapping[string(k)] = make(map[string]string)
+		for original, pseudonym := range pseudonyms {
+			mapping[string(k)][original] = pseudonym
+		}
+	}
+	return mapping
+}
+
+// SetMapping continues with the pseudonyms of an earlier run, so files anonymised separately stay consistent.
+func (a *Anonymiser) SetMapping(mapping map[string]map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for name, pseudonyms := range mapping {
+		k := kind(name)
+		if !knownKind(k) {
+			return fmt.Errorf("unknown kind '%s' in mapping", name)
+		}
+
+		// new pseudonyms are numbered after the existing ones, which only works without duplicates
+		seen := make(map[string]bool)
+		for _, pseudonym := range pseudonyms {
+			if seen[pseudonym] {
+				return fmt.Errorf("pseudonym %s of kind %s is used twice", pseudonym, name)
+			}
+			seen[pseudonym] = true
+		}
+
+		a.pseudonyms[k] = make(map[string]string)
+		for original, pseudonym := range pseudonyms {
+			a.pseudonyms[k][original] = pseudonym
+		}
+	}
+
+	return nil
+}
+
+func knownKind(k kind) bool {
+	switch k {
+	case wizardKind, guildKind, unitKind, wizardNameKind, guildNameKind:
+		return true
+	}
+	return false
+}
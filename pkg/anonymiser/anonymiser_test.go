@@ -0,0 +1,228 @@
+package anonymiser_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+	"github.com/swarpf/plugins/pkg/anonymiser"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+)
+
+func TestJSON(t *testing.T) {
+	a := anonymiser.New()
+
+	got := anonymise(t, a, `{
+		"session_key": "secret", "channel_uid": 987654321, "infocsv": "device",
+		"wizard_info": {"wizard_id": 9007199254740993, "wizard_name": "Alice", "rep_unit_id": 555},
+		"guild": {"guild_info": {"guild_id": 77, "name": "Knights"}},
+		"unit_list": [{"unit_id": 555, "wizard_id": 9007199254740993, "runes": [{"occupied_id": 555, "rune_id": 1}]}],
+		"unit_lock_list": [555, 0],
+		"chat_list": [{"wizard_name": "Bob", "message": "hello"}]
+	}`)
+
+	// pseudonyms are assigned in the order of the keys
+	want := `{"channel_uid":0,"chat_list":[{"message":"","wizard_name":"Wizard-1"}],` +
+		`"guild":{"guild_info":{"guild_id":2001,"name":"Guild-1"}},"infocsv":"","session_key":"",` +
+		`"unit_list":[{"runes":[{"occupied_id":20000001,"rune_id":1}],"unit_id":20000001,"wizard_id":10001}],` +
+		`"unit_lock_list":[20000001,0],` +
+		`"wizard_info":{"rep_unit_id":20000001,"wizard_id":10001,"wizard_name":"Wizard-2"}}`
+	if got != want {
+		t.Errorf("JSON() =\n%s\nwant\n%s", got, want)
+	}
+
+	// the pseudonyms are kept for the next document, ids given as strings stay strings
+	got = anonymise(t, a, `{"wizard_id": "9007199254740993", "opp_wizard_name": "Bob", "guild_name": "Knights"}`)
+	want = `{"guild_name":"Guild-1","opp_wizard_name":"Wizard-1","wizard_id":"10001"}`
+	if got != want {
+		t.Errorf("JSON() = %s, want %s", got, want)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	a := anonymiser.New()
+	directory := tempDir(t)
+
+	paths, err := filepath.Glob(filepath.Join(fixtures.Dir(fixtures.Version), "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		target, err := a.File(path, directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadFile(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for original := range a.Mapping()["wizard_name"] {
+			if strings.Contains(string(content), `"`+original+`"`) {
+				t.Errorf("%s still contains the wizard name %s", target, original)
+			}
+		}
+	}
+
+	// the login and the siege matchup refer to the same wizard and guild
+	login, matchup := readFixture(t, directory, "HubUserLogin"), readFixture(t, directory, "GetGuildSiegeMatchupInfo")
+	wizardInfo := login.Response["wizard_info"].(map[string]interface{})
+	if wizardInfo["wizard_id"] != matchup.Request["wizard_id"] {
+		t.Errorf("wizard ids differ: %v and %v", wizardInfo["wizard_id"], matchup.Request["wizard_id"])
+	}
+
+	guildInfo := login.Response["guild"].(map[string]interface{})["guild_info"].(map[string]interface{})
+	matchInfo := matchup.Response["match_info"].(map[string]interface{})
+	if guildInfo["guild_id"] != matchInfo["guild_id"] {
+		t.Errorf("guild ids differ: %v and %v", guildInfo["guild_id"], matchInfo["guild_id"])
+	}
+
+	// runes still belong to their units
+	for _, entry := range login.Response["unit_list"].([]interface{}) {
+		unit := entry.(map[string]interface{})
+		runes, _ := unit["runes"].([]interface{})
+		for _, r := range runes {
+			if occupiedId := r.(map[string]interface{})["occupied_id"]; occupiedId != unit["unit_id"] {
+				t.Errorf("rune of unit %v is occupied by %v", unit["unit_id"], occupiedId)
+			}
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	a := anonymiser.New()
+	in, out := tempDir(t), tempDir(t)
+
+	// a compressed capture segment
+	w, err := eventcapture.NewWriter(eventcapture.Options{Directory: in, Compression: eventcapture.Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := eventcapture.Event{
+		Time:     time.Unix(1600000000, 0).UTC(),
+		Command:  "GetGuildSiegeBattleLog",
+		Request:  `{"wizard_id":123,"session_key":"secret"}`,
+		Response: `{"log_list":[{"battle_log_list":[{"wizard_id":123,"wizard_name":"Alice"}]}]}`,
+	}
+	if err := w.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := eventcapture.Segments(in)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("Segments() = %v, %v", segments, err)
+	}
+
+	target, err := a.File(segments[0], out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(target, ".jsonl") {
+		t.Errorf("capture was written to %s, want an uncompressed segment", target)
+	}
+
+	var events []eventcapture.Event
+	if err := eventcapture.ReadFiles([]string{target}, func(e eventcapture.Event) error {
+		events = append(events, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := eventcapture.Event{
+		Time:     event.Time,
+		Command:  event.Command,
+		Request:  `{"session_key":"","wizard_id":10001}`,
+		Response: `{"log_list":[{"battle_log_list":[{"wizard_id":10001,"wizard_name":"Wizard-1"}]}]}`,
+	}
+	if len(events) != 1 || events[0] != want {
+		t.Errorf("anonymised events = %+v, want %+v", events, want)
+	}
+
+	// a profile export is renamed after the pseudonyms
+	profile := filepath.Join(in, "Alice-123.json")
+	if err := ioutil.WriteFile(profile, []byte(`{"wizard_info":{"wizard_id":123,"wizard_name":"Alice"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := a.File(profile, out); err != nil || filepath.Base(target) != "Wizard-1-10001.json" {
+		t.Errorf("File() = %s, %v, want Wizard-1-10001.json", target, err)
+	}
+
+	// the input is never overwritten
+	match := filepath.Join(in, "SiegeMatch-55.json")
+	if err := ioutil.WriteFile(match, []byte(`{"wizard_id":123}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.File(match, in); err == nil {
+		t.Error("File() did not refuse to overwrite the input")
+	}
+}
+
+func TestMapping(t *testing.T) {
+	first := anonymiser.New()
+	anonymise(t, first, `{"wizard_id": 123, "wizard_name": "Alice"}`)
+
+	second := anonymiser.New()
+	if err := second.SetMapping(first.Mapping()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := anonymise(t, second, `[{"wizard_id": 456}, {"wizard_id": 123, "wizard_name": "Alice"}]`)
+	want := `[{"wizard_id":10002},{"wizard_id":10001,"wizard_name":"Wizard-1"}]`
+	if got != want {
+		t.Errorf("JSON() = %s, want %s", got, want)
+	}
+
+	if err := second.SetMapping(map[string]map[string]string{"rune": {"1": "2"}}); err == nil {
+		t.Error("SetMapping() accepted an unknown kind")
+	}
+}
+
+func anonymise(t *testing.T, a *anonymiser.Anonymiser, document string) string {
+	t.Helper()
+
+	out, err := a.JSON([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+type fixture struct {
+	Request  map[string]interface{} `json:"request"`
+	Response map[string]interface{} `json:"response"`
+}
+
+func readFixture(t *testing.T, directory, name string) fixture {
+	t.Helper()
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := fixture{}
+	if err := json.Unmarshal(content, &f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "anonymiser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(directory) })
+
+	return directory
+}
@@ -0,0 +1,129 @@
+package anonymiser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/swarpf/plugins/pkg/eventcapture"
+)
+
+// Event anonymises the request and the response of a captured api event.
+func (a *Anonymiser) Event(event eventcapture.Event) (eventcapture.Event, error) {
+	request, err := a.JSON([]byte(event.Request))
+	if err != nil {
+		return event, fmt.Errorf("request: %w", err)
+	}
+
+	response, err := a.JSON([]byte(event.Response))
+	if err != nil {
+		return event, fmt.Errorf("response: %w", err)
+	}
+
+	event.Request, event.Response = string(request), string(response)
+	return event, nil
+}
+
+// File anonymises a capture segment (.jsonl, optionally compressed) or a JSON file like a profile export and writes
+// the result into directory. Capture segments are written uncompressed. The name of the written file is anonymised
+// as well and returned.
+func (a *Anonymiser) File(path, directory string) (string, error) {
+	name := filepath.Base(path)
+
+	var content []byte
+	var err error
+	if isCapture(name) {
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")
+		content, err = a.capture(path)
+	} else {
+		content, err = a.document(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(directory, a.FileName(name))
+	if same, _ := sameFile(path, target); same {
+		return "", fmt.Errorf("%s would be overwritten by its anonymised copy", path)
+	}
+
+	if err := ioutil.WriteFile(target, content, 0644); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func isCapture(name string) bool {
+	for _, extension := range []string{".jsonl", ".jsonl.gz", ".jsonl.zst"} {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Anonymiser) capture(path string) ([]byte, error) {
+	r, err := eventcapture.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out := bytes.Buffer{}
+	for line := 1; ; line++ {
+		event, err := r.Next()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if event, err = a.Event(event); err != nil {
+			return nil, fmt.Errorf("%s: event %d: %w", path, line, err)
+		}
+
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(encoded)
+		out.WriteByte('\n')
+	}
+}
+
+func (a *Anonymiser) document(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	anonymised, err := a.JSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := bytes.Buffer{}
+	if err := json.Indent(&out, anonymised, "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func sameFile(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fa, fb), nil
+}
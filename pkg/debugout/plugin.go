@@ -1,17 +1,26 @@
 package debugout
 
 import (
-	"context"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
 
-	"github.com/golang/protobuf/ptypes/empty"
+// Plugin implements pluginruntime.Plugin for the debug output plugin
+type Plugin struct{}
 
-	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
-)
+func (p *Plugin) Name() string        { return "debugout" }
+func (p *Plugin) DisplayName() string { return "DebugOutput" }
+func (p *Plugin) DefaultPort() int    { return 11101 }
 
-type ProxyApiConsumer struct {
-	pb.UnimplementedProxyApiConsumerServer
-}
+func (p *Plugin) DefaultLogLevel() zerolog.Level { return zerolog.DebugLevel }
+
+func (p *Plugin) RegisterFlags(_ *pflag.FlagSet) {}
+
+func (p *Plugin) Configure(_ *viper.Viper) error { return nil }
+
+func (p *Plugin) SubscribedCommands() []string { return SubscribedCommands() }
 
-func (s *ProxyApiConsumer) OnReceiveApiEvent(_ context.Context, ev *pb.ApiEvent) (*empty.Empty, error) {
-	return &empty.Empty{}, OnReceiveApiEvent(ev.GetCommand(), ev.GetRequest(), ev.GetResponse())
+func (p *Plugin) OnReceiveApiEvent(command, request, response string) error {
+	return OnReceiveApiEvent(command, request, response)
 }
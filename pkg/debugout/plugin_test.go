@@ -0,0 +1,40 @@
+package debugout_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/swarpf/plugins/pkg/debugout"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+)
+
+func TestPlugin(t *testing.T) {
+	proxy, plugin := proxyfake.StartTest(t, &debugout.Plugin{}, nil)
+
+	registration, ok := proxy.Consumer(plugin.Address)
+	if !ok {
+		t.Fatal("plugin is not registered")
+	}
+	if len(registration.Commands) != 1 || registration.Commands[0] != "*" {
+		t.Fatalf("registered commands = %v, want [*]", registration.Commands)
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		request  string
+		response string
+	}{
+		{"json event", "HubUserLogin", `{"command":"HubUserLogin","wizard_id":1}`, `{"ret_code":0}`},
+		{"empty event", "Unknown", "", ""},
+		{"invalid json", "BattleDungeonResult_V2", "{", "not json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := proxy.Send(context.Background(), tt.command, tt.request, tt.response); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package eventcapture
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Event is one api event as it was received from the proxy. Request and response are kept as the original strings,
+// so replaying an event hands the plugin exactly what the proxy sent.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Request  string    `json:"request"`
+	Response string    `json:"response"`
+}
+
+const (
+	segmentPrefix    = "events-"
+	segmentExtension = ".jsonl"
+)
+
+// Reader reads the events of a segment written by Writer or any other JSON Lines file with the same fields.
+type Reader struct {
+	file    *os.File
+	closer  func()
+	decoder *json.Decoder
+	path    string
+	line    int
+}
+
+// Open opens a capture file. Files ending with .gz or .zst are decompressed.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{file: f, closer: func() {}, path: path}
+
+	var in io.Reader = f
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		in, r.closer = gz, func() { _ = gz.Close() }
+	case ".zst":
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		in, r.closer = zr, zr.Close
+	}
+
+	r.decoder = json.NewDecoder(in)
+	return r, nil
+}
+
+// Next returns the next event of the file or io.EOF at its end.
+func (r *Reader) Next() (Event, error) {
+	event := Event{}
+	if err := r.decoder.Decode(&event); err != nil {
+		if err == io.EOF {
+			return event, err
+		}
+		return event, fmt.Errorf("%s: event %d: %w", r.path, r.line+1, err)
+	}
+
+	r.line++
+	return event, nil
+}
+
+func (r *Reader) Close() error {
+	r.closer()
+	return r.file.Close()
+}
+
+// ReadFiles calls f for every event in the files, in the order of the files. It stops at the first error.
+func ReadFiles(paths []string, f func(event Event) error) error {
+	for _, path := range paths {
+		if err := readFile(path, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string, f func(event Event) error) error {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := f(event); err != nil {
+			return err
+		}
+	}
+}
+
+// Segments returns the segments in a capture directory, oldest first.
+func Segments(directory string) ([]string, error) {
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, fi := range infos {
+		if !fi.IsDir() && isSegment(fi.Name()) {
+			segments = append(segments, filepath.Join(directory, fi.Name()))
+		}
+	}
+
+	// segment names start with the time they were opened, so the names sort by age
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func isSegment(name string) bool {
+	if !strings.HasPrefix(name, segmentPrefix) {
+		return false
+	}
+
+	for _, ext := range []string{segmentExtension, segmentExtension + ".gz", segmentExtension + ".zst"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
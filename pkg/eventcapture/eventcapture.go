@@ -0,0 +1,54 @@
+package eventcapture
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var writer *Writer
+
+func SubscribedCommands() []string {
+	return []string{"*"}
+}
+
+// EnableCapture starts writing all api events to segments in the capture directory.
+func EnableCapture(options Options) error {
+	w, err := NewWriter(options)
+	if err != nil {
+		return err
+	}
+
+	writer = w
+	log.Info().
+		Str("captureDirectory", options.Directory).
+		Str("compression", string(options.Compression)).
+		Msg("Capturing api events")
+
+	return nil
+}
+
+func OnReceiveApiEvent(command, request, response string) error {
+	if writer == nil {
+		return errors.New("event capture is not enabled")
+	}
+
+	if err := writer.Write(Event{Time: time.Now().UTC(), Command: command, Request: request, Response: response}); err != nil {
+		log.Error().Err(err).Str("command", command).Msg("Failed to capture api event")
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the current segment, so it is compressed before the process ends.
+func Close() {
+	if writer == nil {
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close capture segment")
+	}
+}
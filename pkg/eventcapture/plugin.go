@@ -0,0 +1,67 @@
+package eventcapture
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+)
+
+// Plugin implements pluginruntime.Plugin for the event capture plugin
+type Plugin struct{}
+
+func (p *Plugin) Name() string        { return "eventcapture" }
+func (p *Plugin) DisplayName() string { return "Event Capture" }
+func (p *Plugin) DefaultPort() int    { return 11107 }
+
+func (p *Plugin) RegisterFlags(flags *pflag.FlagSet) {
+	flags.String("capture_directory", "./capture", "Directory the api events are written to")
+	flags.Int("rotate_size_mb", 64, "Start a new capture file once the current one reached this size in MiB. 0 disables size based rotation")
+	flags.Duration("rotate_interval", time.Hour, "Start a new capture file once the current one is open for this time. 0 disables time based rotation")
+	flags.String("compression", string(Gzip), "Compression of the closed capture files: none, gzip or zstd")
+	flags.Int("retention_files", 0, "Number of closed capture files to keep. 0 keeps all of them")
+	flags.Duration("retention_age", 0, "Delete closed capture files older than this. 0 keeps them forever")
+}
+
+func (p *Plugin) ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	errs.Add("capture_directory", configcheck.WritableDirectory(config.GetString("capture_directory")))
+
+	_, err := ParseCompression(config.GetString("compression"))
+	errs.Add("compression", err)
+
+	for _, key := range []string{"rotate_size_mb", "retention_files"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetInt(key))))
+	}
+	for _, key := range []string{"rotate_interval", "retention_age"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetDuration(key))))
+	}
+
+	return errs
+}
+
+func (p *Plugin) Configure(config *viper.Viper) error {
+	compression, err := ParseCompression(config.GetString("compression"))
+	if err != nil {
+		return err
+	}
+
+	return EnableCapture(Options{
+		Directory:   config.GetString("capture_directory"),
+		MaxSize:     int64(config.GetInt("rotate_size_mb")) << 20,
+		MaxAge:      config.GetDuration("rotate_interval"),
+		Compression: compression,
+		MaxSegments: config.GetInt("retention_files"),
+		Retention:   config.GetDuration("retention_age"),
+	})
+}
+
+func (p *Plugin) Shutdown() { Close() }
+
+func (p *Plugin) SubscribedCommands() []string { return SubscribedCommands() }
+
+func (p *Plugin) OnReceiveApiEvent(command, request, response string) error {
+	return OnReceiveApiEvent(command, request, response)
+}
@@ -0,0 +1,126 @@
+package eventcapture_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+)
+
+func TestPlugin(t *testing.T) {
+	events := []eventcapture.Event{
+		{Command: "HubUserLogin", Request: `{"command":"HubUserLogin","wizard_id":1}`, Response: `{"ret_code":0}`},
+		{Command: "GetGuildSiegeBattleLog", Request: `{"log_type":1}`, Response: `{"log_list":[]}`},
+		{Command: "Unknown", Request: "", Response: "not json"},
+	}
+
+	tests := []struct {
+		compression string
+		extension   string
+	}{
+		{"none", ".jsonl"},
+		{"gzip", ".jsonl.gz"},
+		{"zstd", ".jsonl.zst"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			directory := tempDir(t)
+			proxy, plugin := proxyfake.StartTest(t, &eventcapture.Plugin{}, map[string]interface{}{
+				"capture_directory": directory,
+				"compression":       tt.compression,
+			})
+
+			for _, event := range events {
+				if err := proxy.Send(context.Background(), event.Command, event.Request, event.Response); err != nil {
+					t.Fatalf("Send(%s) error = %v", event.Command, err)
+				}
+			}
+
+			// closes the segment, so it is compressed
+			plugin.Stop()
+
+			segments, err := eventcapture.Segments(directory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(segments) != 1 || !strings.HasSuffix(segments[0], tt.extension) {
+				t.Fatalf("segments = %v, want one %s file", segments, tt.extension)
+			}
+
+			var captured []eventcapture.Event
+			if err := eventcapture.ReadFiles(segments, func(event eventcapture.Event) error {
+				captured = append(captured, event)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(captured) != len(events) {
+				t.Fatalf("captured %d events, want %d", len(captured), len(events))
+			}
+			for i, event := range captured {
+				if event.Time.IsZero() {
+					t.Errorf("event %d has no time", i)
+				}
+
+				event.Time = events[i].Time
+				if event != events[i] {
+					t.Errorf("event %d = %+v, want %+v", i, event, events[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriterRotation(t *testing.T) {
+	directory := tempDir(t)
+	writer, err := eventcapture.NewWriter(eventcapture.Options{
+		Directory:   directory,
+		MaxSize:     200,
+		Compression: eventcapture.Gzip,
+		MaxSegments: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every event fills a segment on its own
+	for i := 0; i < 5; i++ {
+		event := eventcapture.Event{Command: "HubUserLogin", Response: strings.Repeat("x", 150)}
+		if err := writer.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := eventcapture.Segments(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("segments = %v, want the newest 2", segments)
+	}
+	for _, segment := range segments {
+		if filepath.Ext(segment) != ".gz" {
+			t.Errorf("segment %s is not compressed", segment)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "eventcapture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(directory) })
+
+	return directory
+}
@@ -0,0 +1,301 @@
+package eventcapture
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+)
+
+// Compression of closed segments
+type Compression string
+
+const (
+	NoCompression Compression = "none"
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+func ParseCompression(compression string) (Compression, error) {
+	switch Compression(compression) {
+	case NoCompression, Gzip, Zstd:
+		return Compression(compression), nil
+	default:
+		return "", fmt.Errorf("unknown compression '%s'. Valid compressions are none, gzip and zstd", compression)
+	}
+}
+
+func (c Compression) extension() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// Options controls the rotation and retention of the segments written by Writer.
+type Options struct {
+	Directory string
+	// MaxSize closes the current segment once it reached this many bytes. 0 disables size based rotation.
+	MaxSize int64
+	// MaxAge closes the current segment with the first event after it was open for this time. 0 disables time based
+	// rotation.
+	MaxAge time.Duration
+	// Compression is applied to closed segments.
+	Compression Compression
+	// MaxSegments is the number of closed segments which are kept. 0 keeps all of them.
+	MaxSegments int
+	// Retention is the time closed segments are kept. 0 keeps them forever.
+	Retention time.Duration
+}
+
+// Writer appends events to JSON Lines files in a directory. The files (segments) are named after the time they
+// were opened. Closed segments are compressed in the background and old ones are deleted according to the options.
+type Writer struct {
+	options Options
+
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	size   int64
+	opened time.Time
+	// pending are closed segments waiting for compression, they are not subject to retention yet
+	pending map[string]bool
+
+	// finishMu serializes compression and retention, so retention never deletes a segment which is compressed
+	finishMu  sync.Mutex
+	finishing sync.WaitGroup
+}
+
+// NewWriter creates the capture directory if needed. Segments left uncompressed by an earlier run are compressed.
+func NewWriter(options Options) (*Writer, error) {
+	if options.Compression == "" {
+		options.Compression = NoCompression
+	}
+
+	if err := os.MkdirAll(options.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+
+	w := &Writer{options: options, pending: make(map[string]bool)}
+
+	segments, err := Segments(options.Directory)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	for _, segment := range segments {
+		if filepath.Ext(segment) == segmentExtension {
+			w.finish(segment)
+		}
+	}
+	w.mu.Unlock()
+
+	return w, nil
+}
+
+// Write appends an event to the current segment, opening or rotating it as needed.
+func (w *Writer) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil && w.needsRotation(int64(len(line))) {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+	}
+
+	if w.file == nil {
+		if err := w.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+func (w *Writer) needsRotation(size int64) bool {
+	if w.options.MaxSize > 0 && w.size > 0 && w.size+size > w.options.MaxSize {
+		return true
+	}
+
+	return w.options.MaxAge > 0 && time.Since(w.opened) >= w.options.MaxAge
+}
+
+func (w *Writer) openSegment() error {
+	for {
+		now := time.Now().UTC()
+		path := filepath.Join(w.options.Directory,
+			segmentPrefix+now.Format("20060102T150405.000000000Z")+segmentExtension)
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open capture segment: %w", err)
+		}
+
+		w.file, w.path, w.size, w.opened = f, path, 0, now
+		log.Debug().Str("segment", path).Msg("Opened capture segment")
+		return nil
+	}
+}
+
+func (w *Writer) closeSegment() error {
+	err := w.file.Close()
+	w.finish(w.path)
+	w.file, w.path = nil, ""
+
+	if err != nil {
+		return fmt.Errorf("failed to close capture segment: %w", err)
+	}
+	return nil
+}
+
+// finish compresses a closed segment and applies the retention limits in the background. It is called with mu held.
+func (w *Writer) finish(path string) {
+	w.pending[path] = true
+	w.finishing.Add(1)
+
+	go func() {
+		defer w.finishing.Done()
+
+		w.finishMu.Lock()
+		defer w.finishMu.Unlock()
+
+		if w.options.Compression != NoCompression {
+			if err := compress(path, w.options.Compression); err != nil {
+				log.Error().Err(err).Str("segment", path).Msg("Failed to compress capture segment")
+			}
+		}
+
+		w.mu.Lock()
+		delete(w.pending, path)
+		w.mu.Unlock()
+
+		w.applyRetention()
+	}()
+}
+
+func compress(path string, compression Compression) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	target := path + compression.extension()
+	tmp := target + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := compressTo(out, in, compression); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func compressTo(out io.Writer, in io.Reader, compression Compression) error {
+	var cw io.WriteCloser
+	switch compression {
+	case Gzip:
+		cw = gzip.NewWriter(out)
+	case Zstd:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	default:
+		return fmt.Errorf("unknown compression '%s'", compression)
+	}
+
+	if _, err := io.Copy(cw, in); err != nil {
+		_ = cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// applyRetention deletes the oldest closed segments beyond MaxSegments and the ones older than Retention. It is called
+// with finishMu held.
+func (w *Writer) applyRetention() {
+	if w.options.MaxSegments <= 0 && w.options.Retention <= 0 {
+		return
+	}
+
+	segments, err := Segments(w.options.Directory)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list capture segments")
+		return
+	}
+
+	w.mu.Lock()
+	closed := segments[:0]
+	for _, segment := range segments {
+		if segment != w.path && !w.pending[segment] {
+			closed = append(closed, segment)
+		}
+	}
+	w.mu.Unlock()
+
+	for i, segment := range closed {
+		expired := w.options.MaxSegments > 0 && len(closed)-i > w.options.MaxSegments
+		if !expired && w.options.Retention > 0 {
+			fi, err := os.Stat(segment)
+			expired = err == nil && time.Since(fi.ModTime()) > w.options.Retention
+		}
+		if !expired {
+			continue
+		}
+
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Str("segment", segment).Msg("Failed to delete capture segment")
+			continue
+		}
+		log.Debug().Str("segment", segment).Msg("Deleted capture segment")
+	}
+}
+
+// Close closes the current segment and waits until all closed segments are compressed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.closeSegment()
+	}
+	w.mu.Unlock()
+
+	w.finishing.Wait()
+	return err
+}
@@ -0,0 +1,43 @@
+package gamemodel
+
+import "encoding/json"
+
+// Request holds the fields of the requests to the game the plugins use. Most fields are only sent with some commands.
+type Request struct {
+	Object
+	Command    string      `json:"command"`
+	WizardId   json.Number `json:"wizard_id"`
+	GuildId    json.Number `json:"guild_id"`
+	LogType    int         `json:"log_type"`
+	BaseNumber int         `json:"base_number"`
+}
+
+func (r *Request) UnmarshalJSON(data []byte) error {
+	type model Request
+	return unmarshalObject(data, &r.Object, (*model)(r))
+}
+
+func (r Request) MarshalJSON() ([]byte, error) {
+	type model Request
+	return marshalObject(&r.Object, (*model)(&r))
+}
+
+// Response holds the fields common to the responses of the game. The wizard is only sent with some commands, either
+// directly or as wizard info.
+type Response struct {
+	Object
+	Command    string      `json:"command"`
+	RetCode    int         `json:"ret_code"`
+	WizardId   json.Number `json:"wizard_id"`
+	WizardInfo WizardInfo  `json:"wizard_info"`
+}
+
+func (r *Response) UnmarshalJSON(data []byte) error {
+	type model Response
+	return unmarshalObject(data, &r.Object, (*model)(r))
+}
+
+func (r Response) MarshalJSON() ([]byte, error) {
+	type model Response
+	return marshalObject(&r.Object, (*model)(&r))
+}
@@ -0,0 +1,205 @@
+package gamemodel_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+	"github.com/swarpf/plugins/pkg/gamemodel"
+)
+
+func TestLogin(t *testing.T) {
+	login := gamemodel.Login{}
+	if err := json.Unmarshal(fixtures.MustLoad(t, "HubUserLogin").Response, &login); err != nil {
+		t.Fatal(err)
+	}
+
+	if login.WizardInfo.WizardId != "10001" || login.WizardInfo.WizardName != "Wizard-a1" {
+		t.Errorf("wizard = %s %s, want 10001 Wizard-a1", login.WizardInfo.WizardId, login.WizardInfo.WizardName)
+	}
+	if len(login.BuildingList) != 3 || login.BuildingList[0].BuildingMasterId != gamemodel.StorageBuildingMasterId {
+		t.Errorf("building list = %+v, want the storage first", login.BuildingList)
+	}
+	if len(login.UnitList) != 4 || len(login.RuneCraftItemList) != 3 {
+		t.Fatalf("%d units and %d craft items, want 4 and 3", len(login.UnitList), len(login.RuneCraftItemList))
+	}
+
+	// the runes of the second unit are sent as object keyed by the slot
+	runes := login.UnitList[1].Runes
+	if len(runes) != 2 || runes[0].SlotNo != 4 || runes[1].SlotNo != 6 {
+		t.Fatalf("runes = %+v, want slot 4 and 6", runes)
+	}
+
+	r := runes[1]
+	if r.RuneId != "9007199254740993" {
+		t.Errorf("rune id = %s, want 9007199254740993", r.RuneId)
+	}
+	if want := (gamemodel.Effect{Type: 4, Value: 63}); r.PriEff != want {
+		t.Errorf("pri_eff = %+v, want %+v", r.PriEff, want)
+	}
+	if want := (gamemodel.SubEffect{Type: 2, Value: 8, Grind: 3}); len(r.SecEff) != 4 || r.SecEff[0] != want {
+		t.Errorf("sec_eff = %+v, want %+v first", r.SecEff, want)
+	}
+
+	artifacts := login.UnitList[0].Artifacts
+	if len(artifacts) != 1 || artifacts[0].Rid != "40000001" || len(artifacts[0].SecEffects) != 2 {
+		t.Errorf("artifacts = %+v, want artifact 40000001 with 2 sub effects", artifacts)
+	}
+}
+
+func TestBattleLogs(t *testing.T) {
+	siegeLog := gamemodel.SiegeBattleLog{}
+	if err := json.Unmarshal(fixtures.MustLoad(t, "GetGuildSiegeBattleLog-attack").Response, &siegeLog); err != nil {
+		t.Fatal(err)
+	}
+	if siegeLog.MatchId() != "7001" || len(siegeLog.LogList[0].BattleLogList) != 2 {
+		t.Errorf("siege log of match %s with %d battles, want match 7001 with 2", siegeLog.MatchId(),
+			len(siegeLog.LogList[0].BattleLogList))
+	}
+	if id := (&gamemodel.SiegeBattleLog{}).MatchId(); id != "" {
+		t.Errorf("MatchId() of an empty log = %s", id)
+	}
+
+	guildWarLog := gamemodel.GuildWarBattleLog{}
+	if err := json.Unmarshal(fixtures.MustLoad(t, "GetGuildWarBattleLogByGuildId").Response, &guildWarLog); err != nil {
+		t.Fatal(err)
+	}
+	if groups := guildWarLog.BattleLogListGroup; len(groups) != 2 || groups[1].BattleLogList[0].BattleLogId != "77102" {
+		t.Errorf("battle log groups = %+v, want 2 groups with battle 77102 last", groups)
+	}
+}
+
+func TestTolerantDecoding(t *testing.T) {
+	const input = `{"rune_id":12345678901234567890,"slot_no":"two","pri_eff":[1,2,3],"set_id":5,` +
+		`"unknown":{"b":1,"a":2.50}}`
+
+	r := gamemodel.Rune{}
+	if err := json.Unmarshal([]byte(input), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.RuneId != "12345678901234567890" || r.SetId != 5 {
+		t.Errorf("rune id %s and set %d, want 12345678901234567890 and 5", r.RuneId, r.SetId)
+	}
+	if r.SlotNo != 0 || r.PriEff != (gamemodel.Effect{}) {
+		t.Errorf("invalid fields were decoded: slot %d, pri_eff %+v", r.SlotNo, r.PriEff)
+	}
+	if got, want := r.InvalidFields(), []string{"pri_eff", "slot_no"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InvalidFields() = %v, want %v", got, want)
+	}
+	if !r.Has("unknown") || r.Has("rank") {
+		t.Errorf("Has() does not match the received fields")
+	}
+
+	// invalid and unknown fields are kept as received, changed fields are written from the model, unset ones not at all
+	r.SetId, r.Class = 6, 5
+	if err := r.Set("note", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"class":5,"note":"changed","pri_eff":[1,2,3],"rune_id":12345678901234567890,"set_id":6,` +
+		`"slot_no":"two","unknown":{"a":2.50,"b":1}}`
+	if string(got) != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+
+	if err := json.Unmarshal([]byte(`[1]`), &r); err == nil {
+		t.Errorf("decoding a list into a rune did not fail")
+	}
+}
+
+// TestRoundTrip makes sure that every fixture is written with all of its fields after decoding
+func TestRoundTrip(t *testing.T) {
+	models := map[string]func() interface{}{
+		"HubUserLogin":                           func() interface{} { return &gamemodel.Login{} },
+		"GuestLogin":                             func() interface{} { return &gamemodel.Login{} },
+		"GetGuildSiegeMatchupInfo":               func() interface{} { return &gamemodel.SiegeMatchupInfo{} },
+		"GetGuildSiegeBattleLog":                 func() interface{} { return &gamemodel.SiegeBattleLog{} },
+		"GetGuildSiegeBaseDefenseUnitList":       func() interface{} { return &gamemodel.SiegeDefenseUnitList{} },
+		"GetGuildSiegeBaseDefenseUnitListPreset": func() interface{} { return &gamemodel.SiegeDefenseUnitList{} },
+		"GetGuildWarBattleLogByWizardId":         func() interface{} { return &gamemodel.GuildWarBattleLog{} },
+		"GetGuildWarBattleLogByGuildId":          func() interface{} { return &gamemodel.GuildWarBattleLog{} },
+	}
+
+	for command, model := range models {
+		list, err := fixtures.List(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range list {
+			t.Run(f.Name, func(t *testing.T) {
+				request := gamemodel.Request{}
+				checkRoundTrip(t, f.Request, &request)
+				if request.Command != f.Command {
+					t.Errorf("request command = %s, want %s", request.Command, f.Command)
+				}
+
+				checkRoundTrip(t, f.Response, model())
+			})
+		}
+	}
+}
+
+func checkRoundTrip(t *testing.T, input json.RawMessage, model interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(input, model); err != nil {
+		t.Fatal(err)
+	}
+	output, err := json.Marshal(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := decode(t, input), decode(t, output); !reflect.DeepEqual(want, got) {
+		t.Errorf("round trip changed the content:\nwant %v\n got %v", want, got)
+	}
+}
+
+// decode decodes JSON without loss of precision. Runes sent as object are converted to a list like RuneList does.
+func decode(t *testing.T, data []byte) interface{} {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+
+	return runesAsList(value)
+}
+
+func runesAsList(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, v := range value {
+			value[key] = runesAsList(v)
+		}
+
+		if bySlot, ok := value["runes"].(map[string]interface{}); ok {
+			slots := make([]string, 0, len(bySlot))
+			for slot := range bySlot {
+				slots = append(slots, slot)
+			}
+			sort.Strings(slots)
+
+			runes := make([]interface{}, 0, len(slots))
+			for _, slot := range slots {
+				runes = append(runes, bySlot[slot])
+			}
+			value["runes"] = runes
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = runesAsList(v)
+		}
+	}
+	return value
+}
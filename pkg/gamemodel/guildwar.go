@@ -0,0 +1,65 @@
+package gamemodel
+
+import "encoding/json"
+
+// GuildWarBattleLog is the response to GetGuildWarBattleLogByWizardId and GetGuildWarBattleLogByGuildId.
+type GuildWarBattleLog struct {
+	Object
+	Command            string                   `json:"command"`
+	RetCode            int                      `json:"ret_code"`
+	LogType            int                      `json:"log_type"`
+	BattleLogListGroup []GuildWarBattleLogGroup `json:"battle_log_list_group"`
+}
+
+func (g *GuildWarBattleLog) UnmarshalJSON(data []byte) error {
+	type model GuildWarBattleLog
+	return unmarshalObject(data, &g.Object, (*model)(g))
+}
+
+func (g GuildWarBattleLog) MarshalJSON() ([]byte, error) {
+	type model GuildWarBattleLog
+	return marshalObject(&g.Object, (*model)(&g))
+}
+
+// GuildWarBattleLogGroup groups the battles of a guild war log, e.g. by opponent.
+type GuildWarBattleLogGroup struct {
+	Object
+	BattleLogList []GuildWarBattle `json:"battle_log_list"`
+}
+
+func (g *GuildWarBattleLogGroup) UnmarshalJSON(data []byte) error {
+	type model GuildWarBattleLogGroup
+	return unmarshalObject(data, &g.Object, (*model)(g))
+}
+
+func (g GuildWarBattleLogGroup) MarshalJSON() ([]byte, error) {
+	type model GuildWarBattleLogGroup
+	return marshalObject(&g.Object, (*model)(&g))
+}
+
+// GuildWarBattle is an attack in a guild war. WinLose is 1 for a win and 2 for a loss of the attacking wizard.
+type GuildWarBattle struct {
+	Object
+	BattleLogId   json.Number `json:"battle_log_id"`
+	WizardId      json.Number `json:"wizard_id"`
+	WizardName    string      `json:"wizard_name"`
+	GuildId       json.Number `json:"guild_id"`
+	GuildName     string      `json:"guild_name"`
+	OppWizardId   json.Number `json:"opp_wizard_id"`
+	OppWizardName string      `json:"opp_wizard_name"`
+	OppGuildId    json.Number `json:"opp_guild_id"`
+	OppGuildName  string      `json:"opp_guild_name"`
+	BattleEnd     int64       `json:"battle_end"`
+	WinLose       int         `json:"win_lose"`
+	RoundId       int         `json:"round_id"`
+}
+
+func (g *GuildWarBattle) UnmarshalJSON(data []byte) error {
+	type model GuildWarBattle
+	return unmarshalObject(data, &g.Object, (*model)(g))
+}
+
+func (g GuildWarBattle) MarshalJSON() ([]byte, error) {
+	type model GuildWarBattle
+	return marshalObject(&g.Object, (*model)(&g))
+}
@@ -0,0 +1,157 @@
+// Package gamemodel contains typed models of the game requests and responses the plugins consume. Ids are decoded
+// as json.Number, so 64-bit ids keep their precision.
+//
+// Decoding is tolerant: fields which are not modelled are kept, and a modelled field which does not have the expected
+// type is kept as it was received and left at its zero value instead of failing the whole object. Marshaling a model
+// writes all fields it was received with, so plugins can change the modelled fields and pass everything else on.
+package gamemodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object keeps the fields of a JSON object as they were received. The models of this package embed it.
+type Object struct {
+	// Fields are all fields of the object as received, including the modelled ones.
+	Fields map[string]json.RawMessage `json:"-"`
+
+	// invalid are the modelled fields which could not be decoded
+	invalid map[string]bool
+}
+
+// Has reports whether the object was received with the field.
+func (o *Object) Has(name string) bool {
+	_, ok := o.Fields[name]
+	return ok
+}
+
+// Set sets a field which is not modelled. Modelled fields are set on the model instead, they take precedence.
+func (o *Object) Set(name string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if o.Fields == nil {
+		o.Fields = make(map[string]json.RawMessage)
+	}
+	o.Fields[name] = raw
+	return nil
+}
+
+// InvalidFields returns the modelled fields which were received with an unexpected type, sorted by name.
+func (o *Object) InvalidFields() []string {
+	fields := make([]string, 0, len(o.invalid))
+	for name := range o.invalid {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// unmarshalObject decodes a JSON object into o and the modelled fields of model. model points to the struct
+// embedding o, converted to a type without UnmarshalJSON method.
+func unmarshalObject(data []byte, o *Object, model interface{}) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	o.Fields, o.invalid = fields, nil
+
+	modelledFields(model, func(name string, field reflect.Value) {
+		raw, ok := fields[name]
+		if !ok {
+			return
+		}
+
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			field.Set(reflect.Zero(field.Type()))
+			if o.invalid == nil {
+				o.invalid = make(map[string]bool)
+			}
+			o.invalid[name] = true
+		}
+	})
+
+	return nil
+}
+
+// marshalObject encodes the fields of o and the modelled fields of model. Modelled fields which were received are
+// encoded from the model unless they were invalid, the ones which were not received only if they are set. All objects
+// are written with sorted keys, like encoding/json does for maps.
+func marshalObject(o *Object, model interface{}) ([]byte, error) {
+	fields := make(map[string]interface{}, len(o.Fields))
+	modelledFields(model, func(name string, field reflect.Value) {
+		if !o.invalid[name] && (o.Has(name) || !field.IsZero()) {
+			fields[name] = field.Interface()
+		}
+	})
+
+	for name, raw := range o.Fields {
+		if _, ok := fields[name]; ok {
+			continue
+		}
+
+		value, err := sortedRaw(raw)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+
+	return json.Marshal(fields)
+}
+
+// sortedRaw returns a raw value which contains objects decoded without loss of precision, so they are encoded with
+// sorted keys
+func sortedRaw(raw json.RawMessage) (interface{}, error) {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return raw, nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// modelledFields calls f for every field of the struct model points to which has a JSON name
+func modelledFields(model interface{}, f func(name string, field reflect.Value)) {
+	v := reflect.ValueOf(model).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if structField.Anonymous || structField.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		f(name, v.Field(i))
+	}
+}
+
+// ParseId returns an id as unsigned integer, or 0 if it is not one.
+func ParseId(id json.Number) uint64 {
+	value, err := strconv.ParseUint(id.String(), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
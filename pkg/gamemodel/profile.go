@@ -0,0 +1,289 @@
+package gamemodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Login is the response to HubUserLogin and GuestLogin, the profile of the wizard.
+type Login struct {
+	Object
+	Command           string      `json:"command"`
+	RetCode           int         `json:"ret_code"`
+	WizardInfo        WizardInfo  `json:"wizard_info"`
+	BuildingList      []Building  `json:"building_list"`
+	UnitList          []Unit      `json:"unit_list"`
+	Runes             RuneList    `json:"runes"`
+	Artifacts         []Artifact  `json:"artifacts"`
+	RuneCraftItemList []CraftItem `json:"rune_craft_item_list"`
+}
+
+func (l *Login) UnmarshalJSON(data []byte) error {
+	type model Login
+	return unmarshalObject(data, &l.Object, (*model)(l))
+}
+
+func (l Login) MarshalJSON() ([]byte, error) {
+	type model Login
+	return marshalObject(&l.Object, (*model)(&l))
+}
+
+// WizardInfo is the account of a wizard.
+type WizardInfo struct {
+	Object
+	WizardId          json.Number `json:"wizard_id"`
+	WizardName        string      `json:"wizard_name"`
+	WizardLevel       int         `json:"wizard_level"`
+	Experience        int64       `json:"experience"`
+	WizardMana        int64       `json:"wizard_mana"`
+	WizardCrystal     int64       `json:"wizard_crystal"`
+	WizardCrystalPaid int64       `json:"wizard_crystal_paid"`
+	WizardLastLogin   string      `json:"wizard_last_login"`
+	WizardLastCountry string      `json:"wizard_last_country"`
+	WizardLastLang    string      `json:"wizard_last_lang"`
+	WizardEnergy      int         `json:"wizard_energy"`
+	EnergyMax         int         `json:"energy_max"`
+	ArenaEnergy       int         `json:"arena_energy"`
+	HonorPoint        int64       `json:"honor_point"`
+	GuildPoint        int64       `json:"guild_point"`
+	RepUnitId         json.Number `json:"rep_unit_id"`
+}
+
+func (w *WizardInfo) UnmarshalJSON(data []byte) error {
+	type model WizardInfo
+	return unmarshalObject(data, &w.Object, (*model)(w))
+}
+
+func (w WizardInfo) MarshalJSON() ([]byte, error) {
+	type model WizardInfo
+	return marshalObject(&w.Object, (*model)(&w))
+}
+
+// StorageBuildingMasterId is the building_master_id of the monster storage.
+const StorageBuildingMasterId = 25
+
+// Building is a building on the island of a wizard.
+type Building struct {
+	Object
+	BuildingId       json.Number `json:"building_id"`
+	WizardId         json.Number `json:"wizard_id"`
+	IslandId         int         `json:"island_id"`
+	BuildingMasterId int         `json:"building_master_id"`
+	PosX             int         `json:"pos_x"`
+	PosY             int         `json:"pos_y"`
+}
+
+func (b *Building) UnmarshalJSON(data []byte) error {
+	type model Building
+	return unmarshalObject(data, &b.Object, (*model)(b))
+}
+
+func (b Building) MarshalJSON() ([]byte, error) {
+	type model Building
+	return marshalObject(&b.Object, (*model)(&b))
+}
+
+// Unit is a monster of a wizard.
+type Unit struct {
+	Object
+	UnitId          json.Number `json:"unit_id"`
+	WizardId        json.Number `json:"wizard_id"`
+	BuildingId      json.Number `json:"building_id"`
+	IslandId        int         `json:"island_id"`
+	PosX            int         `json:"pos_x"`
+	PosY            int         `json:"pos_y"`
+	UnitMasterId    int         `json:"unit_master_id"`
+	UnitLevel       int         `json:"unit_level"`
+	Class           int         `json:"class"`
+	Attribute       int         `json:"attribute"`
+	Con             int         `json:"con"`
+	Atk             int         `json:"atk"`
+	Def             int         `json:"def"`
+	Spd             int         `json:"spd"`
+	Resist          int         `json:"resist"`
+	Accuracy        int         `json:"accuracy"`
+	CriticalRate    int         `json:"critical_rate"`
+	CriticalDamage  int         `json:"critical_damage"`
+	Experience      int64       `json:"experience"`
+	Skills          [][]int     `json:"skills"`
+	Runes           RuneList    `json:"runes"`
+	Artifacts       []Artifact  `json:"artifacts"`
+	CostumeMasterId int         `json:"costume_master_id"`
+	CreateTime      string      `json:"create_time"`
+	Source          int         `json:"source"`
+	Homunculus      int         `json:"homunculus"`
+}
+
+func (u *Unit) UnmarshalJSON(data []byte) error {
+	type model Unit
+	return unmarshalObject(data, &u.Object, (*model)(u))
+}
+
+func (u Unit) MarshalJSON() ([]byte, error) {
+	type model Unit
+	return marshalObject(&u.Object, (*model)(&u))
+}
+
+// Rune is a rune in the inventory or equipped on a unit.
+type Rune struct {
+	Object
+	RuneId       json.Number `json:"rune_id"`
+	WizardId     json.Number `json:"wizard_id"`
+	OccupiedType int         `json:"occupied_type"`
+	OccupiedId   json.Number `json:"occupied_id"`
+	SlotNo       int         `json:"slot_no"`
+	Rank         int         `json:"rank"`
+	Class        int         `json:"class"`
+	SetId        int         `json:"set_id"`
+	UpgradeLimit int         `json:"upgrade_limit"`
+	UpgradeCurr  int         `json:"upgrade_curr"`
+	BaseValue    int64       `json:"base_value"`
+	SellValue    int64       `json:"sell_value"`
+	PriEff       Effect      `json:"pri_eff"`
+	PrefixEff    Effect      `json:"prefix_eff"`
+	SecEff       []SubEffect `json:"sec_eff"`
+	Extra        int         `json:"extra"`
+}
+
+func (r *Rune) UnmarshalJSON(data []byte) error {
+	type model Rune
+	return unmarshalObject(data, &r.Object, (*model)(r))
+}
+
+func (r Rune) MarshalJSON() ([]byte, error) {
+	type model Rune
+	return marshalObject(&r.Object, (*model)(&r))
+}
+
+// RuneList is a list of runes. The runes of a unit are sometimes sent as object keyed by the slot instead of a list,
+// they are decoded in the order of the keys. A RuneList is always marshaled as list.
+type RuneList []Rune
+
+func (l *RuneList) UnmarshalJSON(data []byte) error {
+	if data := bytes.TrimSpace(data); len(data) == 0 || data[0] != '{' {
+		var runes []Rune
+		if err := json.Unmarshal(data, &runes); err != nil {
+			return err
+		}
+		*l = runes
+		return nil
+	}
+
+	bySlot := map[string]Rune{}
+	if err := json.Unmarshal(data, &bySlot); err != nil {
+		return err
+	}
+
+	slots := make([]string, 0, len(bySlot))
+	for slot := range bySlot {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+
+	runes := make(RuneList, 0, len(slots))
+	for _, slot := range slots {
+		runes = append(runes, bySlot[slot])
+	}
+	*l = runes
+	return nil
+}
+
+// Effect is the main or prefix stat of a rune, sent as [type, value].
+type Effect struct {
+	Type  int
+	Value int
+}
+
+func (e *Effect) UnmarshalJSON(data []byte) error {
+	var values []int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) != 2 {
+		return fmt.Errorf("rune effect has %d values instead of 2", len(values))
+	}
+
+	e.Type, e.Value = values[0], values[1]
+	return nil
+}
+
+func (e Effect) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]int{e.Type, e.Value})
+}
+
+// SubEffect is a sub stat of a rune, sent as [type, value, enchanted, grind value].
+type SubEffect struct {
+	Type      int
+	Value     int
+	Enchanted int
+	Grind     int
+}
+
+func (e *SubEffect) UnmarshalJSON(data []byte) error {
+	var values []int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) != 4 {
+		return fmt.Errorf("rune sub effect has %d values instead of 4", len(values))
+	}
+
+	e.Type, e.Value, e.Enchanted, e.Grind = values[0], values[1], values[2], values[3]
+	return nil
+}
+
+func (e SubEffect) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]int{e.Type, e.Value, e.Enchanted, e.Grind})
+}
+
+// Artifact is an artifact in the inventory or equipped on a unit. Its effects are sent as lists of numbers starting
+// with the effect type and value.
+type Artifact struct {
+	Object
+	Rid         json.Number `json:"rid"`
+	WizardId    json.Number `json:"wizard_id"`
+	OccupiedId  json.Number `json:"occupied_id"`
+	Slot        int         `json:"slot"`
+	Type        int         `json:"type"`
+	Attribute   int         `json:"attribute"`
+	UnitStyle   int         `json:"unit_style"`
+	NaturalRank int         `json:"natural_rank"`
+	Rank        int         `json:"rank"`
+	Level       int         `json:"level"`
+	PriEffect   []float64   `json:"pri_effect"`
+	SecEffects  [][]float64 `json:"sec_effects"`
+	Locked      int         `json:"locked"`
+}
+
+func (a *Artifact) UnmarshalJSON(data []byte) error {
+	type model Artifact
+	return unmarshalObject(data, &a.Object, (*model)(a))
+}
+
+func (a Artifact) MarshalJSON() ([]byte, error) {
+	type model Artifact
+	return marshalObject(&a.Object, (*model)(&a))
+}
+
+// CraftItem is a grindstone or enchanted gem of a wizard.
+type CraftItem struct {
+	Object
+	CraftItemId json.Number `json:"craft_item_id"`
+	WizardId    json.Number `json:"wizard_id"`
+	CraftType   int         `json:"craft_type"`
+	CraftTypeId int         `json:"craft_type_id"`
+	SellValue   int64       `json:"sell_value"`
+	Amount      int         `json:"amount"`
+}
+
+func (c *CraftItem) UnmarshalJSON(data []byte) error {
+	type model CraftItem
+	return unmarshalObject(data, &c.Object, (*model)(c))
+}
+
+func (c CraftItem) MarshalJSON() ([]byte, error) {
+	type model CraftItem
+	return marshalObject(&c.Object, (*model)(&c))
+}
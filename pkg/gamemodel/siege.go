@@ -0,0 +1,245 @@
+package gamemodel
+
+import "encoding/json"
+
+// SiegeMatchupInfo is the response to GetGuildSiegeMatchupInfo, the state of the current siege match.
+type SiegeMatchupInfo struct {
+	Object
+	Command        string         `json:"command"`
+	RetCode        int            `json:"ret_code"`
+	MatchInfo      SiegeMatchInfo `json:"match_info"`
+	GuildList      []SiegeGuild   `json:"guild_list"`
+	WizardInfoList []SiegeWizard  `json:"wizard_info_list"`
+	BaseList       []SiegeBase    `json:"base_list"`
+}
+
+func (s *SiegeMatchupInfo) UnmarshalJSON(data []byte) error {
+	type model SiegeMatchupInfo
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeMatchupInfo) MarshalJSON() ([]byte, error) {
+	type model SiegeMatchupInfo
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeMatchInfo identifies a siege match from the view of the guild of the wizard.
+type SiegeMatchInfo struct {
+	Object
+	SiegeId    json.Number `json:"siege_id"`
+	MatchId    json.Number `json:"match_id"`
+	MatchType  int         `json:"match_type"`
+	GuildId    json.Number `json:"guild_id"`
+	MatchRank  int         `json:"match_rank"`
+	MatchScore int64       `json:"match_score"`
+}
+
+func (s *SiegeMatchInfo) UnmarshalJSON(data []byte) error {
+	type model SiegeMatchInfo
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeMatchInfo) MarshalJSON() ([]byte, error) {
+	type model SiegeMatchInfo
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeGuild is a guild taking part in a siege match.
+type SiegeGuild struct {
+	Object
+	MatchId    json.Number `json:"match_id"`
+	GuildId    json.Number `json:"guild_id"`
+	GuildName  string      `json:"guild_name"`
+	PosId      int         `json:"pos_id"`
+	MatchScore int64       `json:"match_score"`
+	MatchRank  int         `json:"match_rank"`
+}
+
+func (s *SiegeGuild) UnmarshalJSON(data []byte) error {
+	type model SiegeGuild
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeGuild) MarshalJSON() ([]byte, error) {
+	type model SiegeGuild
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeWizard is a wizard taking part in a siege match.
+type SiegeWizard struct {
+	Object
+	WizardId      json.Number `json:"wizard_id"`
+	WizardName    string      `json:"wizard_name"`
+	GuildId       json.Number `json:"guild_id"`
+	UsedUnitCount int         `json:"used_unit_count"`
+	WizardLevel   int         `json:"wizard_level"`
+}
+
+func (s *SiegeWizard) UnmarshalJSON(data []byte) error {
+	type model SiegeWizard
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeWizard) MarshalJSON() ([]byte, error) {
+	type model SiegeWizard
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeBase is a base on the siege map. Bases without defending wizard have wizard id 0.
+type SiegeBase struct {
+	Object
+	BaseNumber int         `json:"base_number"`
+	BaseType   int         `json:"base_type"`
+	GuildId    json.Number `json:"guild_id"`
+	PosId      int         `json:"pos_id"`
+	WizardId   json.Number `json:"wizard_id"`
+	Point      int         `json:"point"`
+}
+
+func (s *SiegeBase) UnmarshalJSON(data []byte) error {
+	type model SiegeBase
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeBase) MarshalJSON() ([]byte, error) {
+	type model SiegeBase
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeBattleLog is the response to GetGuildSiegeBattleLog, the attacks (log type 1) or defenses (log type 2) of the
+// guild in the current match.
+type SiegeBattleLog struct {
+	Object
+	Command string     `json:"command"`
+	RetCode int        `json:"ret_code"`
+	LogType int        `json:"log_type"`
+	LogList []SiegeLog `json:"log_list"`
+}
+
+func (s *SiegeBattleLog) UnmarshalJSON(data []byte) error {
+	type model SiegeBattleLog
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeBattleLog) MarshalJSON() ([]byte, error) {
+	type model SiegeBattleLog
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// MatchId returns the id of the match the log belongs to, or an empty number if the log has no guild.
+func (s *SiegeBattleLog) MatchId() json.Number {
+	for _, log := range s.LogList {
+		for _, guild := range log.GuildInfoList {
+			if guild.MatchId != "" {
+				return guild.MatchId
+			}
+		}
+	}
+	return ""
+}
+
+// SiegeLog is the battle log of a siege match.
+type SiegeLog struct {
+	Object
+	GuildInfoList []SiegeGuild  `json:"guild_info_list"`
+	BattleLogList []SiegeBattle `json:"battle_log_list"`
+}
+
+func (s *SiegeLog) UnmarshalJSON(data []byte) error {
+	type model SiegeLog
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeLog) MarshalJSON() ([]byte, error) {
+	type model SiegeLog
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeBattle is an attack on a base. WinLose is 1 for a win and 2 for a loss of the attacking wizard.
+type SiegeBattle struct {
+	Object
+	LogId         json.Number `json:"log_id"`
+	LogType       int         `json:"log_type"`
+	BaseNumber    int         `json:"base_number"`
+	WizardId      json.Number `json:"wizard_id"`
+	WizardName    string      `json:"wizard_name"`
+	GuildId       json.Number `json:"guild_id"`
+	GuildName     string      `json:"guild_name"`
+	OppWizardId   json.Number `json:"opp_wizard_id"`
+	OppWizardName string      `json:"opp_wizard_name"`
+	OppGuildId    json.Number `json:"opp_guild_id"`
+	OppGuildName  string      `json:"opp_guild_name"`
+	WinLose       int         `json:"win_lose"`
+	LogTimestamp  int64       `json:"log_timestamp"`
+}
+
+func (s *SiegeBattle) UnmarshalJSON(data []byte) error {
+	type model SiegeBattle
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeBattle) MarshalJSON() ([]byte, error) {
+	type model SiegeBattle
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeDefenseUnitList is the response to GetGuildSiegeBaseDefenseUnitList and
+// GetGuildSiegeBaseDefenseUnitListPreset, the defenses placed in a base.
+type SiegeDefenseUnitList struct {
+	Object
+	Command         string             `json:"command"`
+	RetCode         int                `json:"ret_code"`
+	DefenseDeckList []SiegeDefenseDeck `json:"defense_deck_list"`
+	DefenseUnitList []SiegeDefenseUnit `json:"defense_unit_list"`
+	WizardInfoList  []SiegeWizard      `json:"wizard_info_list"`
+}
+
+func (s *SiegeDefenseUnitList) UnmarshalJSON(data []byte) error {
+	type model SiegeDefenseUnitList
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeDefenseUnitList) MarshalJSON() ([]byte, error) {
+	type model SiegeDefenseUnitList
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeDefenseDeck is a defense of a wizard in a base.
+type SiegeDefenseDeck struct {
+	Object
+	DeckId     json.Number `json:"deck_id"`
+	WizardId   json.Number `json:"wizard_id"`
+	BaseNumber int         `json:"base_number"`
+	PosId      int         `json:"pos_id"`
+	WinCount   int         `json:"win_count"`
+	LoseCount  int         `json:"lose_count"`
+}
+
+func (s *SiegeDefenseDeck) UnmarshalJSON(data []byte) error {
+	type model SiegeDefenseDeck
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeDefenseDeck) MarshalJSON() ([]byte, error) {
+	type model SiegeDefenseDeck
+	return marshalObject(&s.Object, (*model)(&s))
+}
+
+// SiegeDefenseUnit is a unit of a defense.
+type SiegeDefenseUnit struct {
+	Object
+	DeckId   json.Number `json:"deck_id"`
+	WizardId json.Number `json:"wizard_id"`
+	PosId    int         `json:"pos_id"`
+	UnitInfo Unit        `json:"unit_info"`
+}
+
+func (s *SiegeDefenseUnit) UnmarshalJSON(data []byte) error {
+	type model SiegeDefenseUnit
+	return unmarshalObject(data, &s.Object, (*model)(s))
+}
+
+func (s SiegeDefenseUnit) MarshalJSON() ([]byte, error) {
+	type model SiegeDefenseUnit
+	return marshalObject(&s.Object, (*model)(&s))
+}
@@ -0,0 +1,57 @@
+package pluginruntime
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+)
+
+// healthMethodPrefix is the prefix of the methods of the gRPC health service, which is available without secret
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// secretInterceptor rejects every call which does not carry the shared secret of the plugin in its metadata
+func secretInterceptor(pluginName, secret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+
+		reason := ""
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(proxyapiutil.SecretMetadataKey)
+
+		switch {
+		case len(values) == 0:
+			reason = "missing"
+		case subtle.ConstantTimeCompare([]byte(values[0]), []byte(secret)) != 1:
+			reason = "invalid"
+		default:
+			return handler(ctx, req)
+		}
+
+		peerAddress := "unknown"
+		if p, ok := peer.FromContext(ctx); ok {
+			peerAddress = p.Addr.String()
+		}
+
+		log.Warn().
+			Str("method", info.FullMethod).
+			Str("peer", peerAddress).
+			Str("reason", reason).
+			Msg("Rejected call without valid shared secret")
+		metrics.RejectedCalls.WithLabelValues(pluginName, reason).Inc()
+
+		return nil, status.Error(codes.Unauthenticated, "shared secret is "+reason)
+	}
+}
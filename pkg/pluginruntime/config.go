@@ -0,0 +1,221 @@
+package pluginruntime
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/internal/tlsconfig"
+)
+
+// redacted replaces secrets in the output of --print_config
+const redacted = "REDACTED"
+
+// secretKeys are the configuration keys whose values are redacted by --print_config. Keys of the plugin sections of
+// the plugin host are matched by their last part.
+var secretKeys = map[string]bool{
+	"shared_secret": true,
+	"api_tokens":    true,
+}
+
+// loadConfig merges the flags, the environment variables with the prefix of the plugin and the configuration file
+// given by --config. Keys of the configuration file are checked strictly: unknown keys and values which do not match
+// the type of the corresponding flag are errors.
+func loadConfig(plugin Plugin, flags *pflag.FlagSet) (*viper.Viper, error) {
+	config := viper.New()
+	config.SetEnvPrefix("plugin_" + plugin.Name())
+	config.AutomaticEnv()
+	if err := config.BindPFlags(flags); err != nil {
+		return nil, fmt.Errorf("failed to bind flags: %w", err)
+	}
+
+	configFile := config.GetString("config")
+	if configFile == "" {
+		return config, nil
+	}
+
+	config.SetConfigFile(configFile)
+	if err := config.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s: %w", configFile, err)
+	}
+
+	// read the file on its own, so only the keys in the file are checked and not the ones from flags or environment
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(configFile)
+	if err := fileConfig.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s: %w", configFile, err)
+	}
+
+	if err := checkConfigFile(fileConfig, flags); err != nil {
+		return nil, fmt.Errorf("configuration file %s: %w", configFile, err)
+	}
+
+	return config, nil
+}
+
+// checkConfigFile reports keys of the configuration file which do not belong to a flag and values which can not be
+// converted to the type of their flag.
+func checkConfigFile(fileConfig *viper.Viper, flags *pflag.FlagSet) error {
+	var errs configcheck.Errors
+
+	for _, key := range fileConfig.AllKeys() {
+		if configFlag(flags, key) == nil {
+			errs.Addf(key, "unknown option")
+		}
+	}
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		if fileConfig.IsSet(f.Name) {
+			errs.Add(f.Name, checkType(f.Value.Type(), fileConfig.Get(f.Name)))
+		}
+	})
+
+	return errs.Err()
+}
+
+// configFlag returns the flag a key of the configuration file belongs to. Keys below a map flag like api_tokens
+// belong to the map flag.
+func configFlag(flags *pflag.FlagSet, key string) *pflag.Flag {
+	for name := key; ; {
+		if f := flags.Lookup(name); f != nil {
+			if name == key || f.Value.Type() == "stringToString" {
+				return f
+			}
+			return nil
+		}
+
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return nil
+		}
+		name = name[:i]
+	}
+}
+
+func checkType(flagType string, value interface{}) error {
+	var err error
+	switch flagType {
+	case "bool":
+		_, err = cast.ToBoolE(value)
+	case "int":
+		_, err = cast.ToIntE(value)
+	case "float64":
+		_, err = cast.ToFloat64E(value)
+	case "duration":
+		_, err = cast.ToDurationE(value)
+	case "string":
+		_, err = cast.ToStringE(value)
+	case "stringToString":
+		_, err = cast.ToStringMapStringE(value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v is not a valid %s", value, flagType)
+	}
+	return nil
+}
+
+// validateConfig checks the options of the runtime and, if the plugin implements ConfigValidator, the options of the
+// plugin. All problems are returned at once.
+func validateConfig(plugin Plugin, config *viper.Viper) error {
+	var errs configcheck.Errors
+
+	errs.Add("proxyapi_addr", configcheck.Address(config.GetString("proxyapi_addr")))
+	errs.Add("listen_addr", configcheck.Address(config.GetString("listen_addr")))
+	if address := config.GetString("metrics_addr"); address != "" {
+		errs.Add("metrics_addr", configcheck.Address(address))
+	}
+
+	for _, key := range []string{"registration_timeout", "proxyapi_keepalive"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetDuration(key))))
+	}
+	errs.Add("registration_max_backoff", configcheck.Positive(float64(config.GetDuration("registration_max_backoff"))))
+
+	if directory := config.GetString("quarantine_directory"); directory != "" {
+		errs.Add("quarantine_directory", configcheck.WritableDirectory(directory))
+	}
+
+	for _, key := range []string{"tls_cert", "tls_key", "tls_client_ca", "proxyapi_ca", "proxyapi_cert", "proxyapi_key"} {
+		if file := config.GetString(key); file != "" {
+			errs.Add(key, configcheck.ReadableFile(file))
+		}
+	}
+
+	_, err := tlsconfig.ParseClientAuth(config.GetString("tls_client_auth"))
+	errs.Add("tls_client_auth", err)
+
+	if validator, ok := plugin.(ConfigValidator); ok {
+		errs = append(errs, validator.ValidateConfig(config)...)
+	}
+
+	return errs.Err()
+}
+
+// printConfig writes the effective configuration as YAML, which can be used as configuration file again. Secrets are
+// redacted.
+func printConfig(w io.Writer, plugin Plugin, config *viper.Viper) error {
+	settings := config.AllSettings()
+	delete(settings, "print_config")
+
+	// the options of hosted plugins can also be set by their own environment variables
+	if host, ok := plugin.(*Host); ok {
+		for _, p := range host.plugins {
+			pluginConfig, err := host.pluginConfig(p, config)
+			if err != nil {
+				return err
+			}
+			settings[p.Name()] = pluginConfig.AllSettings()
+		}
+	}
+
+	out, err := yaml.Marshal(redact(settings))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+func redact(settings map[string]interface{}) map[string]interface{} {
+	for key, value := range settings {
+		if section, ok := value.(map[string]interface{}); ok && !secretKeys[key] {
+			settings[key] = redact(section)
+			continue
+		}
+
+		if secretKeys[key] {
+			settings[key] = redactValue(value)
+		}
+	}
+
+	return settings
+}
+
+// redactValue keeps the keys of maps, e.g. the wizard ids of api_tokens, and empty values, so it is still visible
+// which secrets are set
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = redacted
+		}
+		return v
+	case map[string]string:
+		for key := range v {
+			v[key] = redacted
+		}
+		return v
+	}
+
+	if cast.ToString(value) == "" {
+		return value
+	}
+	return redacted
+}
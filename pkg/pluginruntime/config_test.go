@@ -0,0 +1,273 @@
+package pluginruntime
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configPlugin is a plugin with a secret map option and a validated option
+type configPlugin struct {
+	name string
+}
+
+func (p *configPlugin) Name() string                           { return p.name }
+func (p *configPlugin) DisplayName() string                    { return p.name }
+func (p *configPlugin) DefaultPort() int                       { return 0 }
+func (p *configPlugin) Configure(_ *viper.Viper) error         { return nil }
+func (p *configPlugin) SubscribedCommands() []string           { return nil }
+func (p *configPlugin) OnReceiveApiEvent(_, _, _ string) error { return nil }
+
+func (p *configPlugin) RegisterFlags(flags *pflag.FlagSet) {
+	flags.StringToString("api_tokens", nil, "API tokens by wizard id")
+	flags.String("mode", "upload", "upload or capture")
+}
+
+func (p *configPlugin) ValidateConfig(config *viper.Viper) []error {
+	if mode := config.GetString("mode"); mode != "upload" && mode != "capture" {
+		return []error{errors.New("mode: must be upload or capture")}
+	}
+	return nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		host    bool
+		// wantErrs are parts of the error, no error is expected if it is empty
+		wantErrs []string
+	}{
+		{
+			name:    "valid",
+			content: "proxyapi_addr: localhost:11000\nregistration_max_backoff: 1m\nmode: capture\napi_tokens:\n  \"123\": secret\n",
+		},
+		{
+			name:     "unknown option",
+			content:  "proxy_addr: localhost:11000\n",
+			wantErrs: []string{"proxy_addr: unknown option"},
+		},
+		{
+			name:     "wrong type",
+			content:  "registration_max_backoff: soon\ndevelopment: maybe\n",
+			wantErrs: []string{"registration_max_backoff: soon is not a valid duration", "development: maybe is not a valid bool"},
+		},
+		{
+			name:     "keys below an option which is not a map",
+			content:  "mode:\n  upload: true\n",
+			wantErrs: []string{"mode.upload: unknown option"},
+		},
+		{
+			name:    "hosted plugin",
+			content: "test:\n  enabled: true\n  api_tokens:\n    \"123\": secret\n",
+			host:    true,
+		},
+		{
+			name:     "unknown option of a hosted plugin",
+			content:  "test:\n  enabled: true\n  output: here\n",
+			host:     true,
+			wantErrs: []string{"test.output: unknown option"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := testConfig(t, testPlugin(test.host), test.content)
+			checkErrors(t, err, test.wantErrs)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	missing := filepath.Join(tempDirectory(t), "missing.pem")
+
+	tests := []struct {
+		name     string
+		content  string
+		host     bool
+		wantErrs []string
+	}{
+		{
+			name:    "defaults",
+			content: "{}",
+		},
+		{
+			name:     "invalid address",
+			content:  "proxyapi_addr: localhost\nmetrics_addr: localhost:100000\n",
+			wantErrs: []string{`proxyapi_addr: "localhost" is not a valid address`, `metrics_addr: "localhost:100000" has an invalid port`},
+		},
+		{
+			name:     "negative timeout",
+			content:  "registration_timeout: -1s\nproxyapi_keepalive: -1s\n",
+			wantErrs: []string{"registration_timeout: must not be negative", "proxyapi_keepalive: must not be negative"},
+		},
+		{
+			name:     "registration backoff of 0",
+			content:  "registration_max_backoff: 0s\n",
+			wantErrs: []string{"registration_max_backoff: must be greater than 0"},
+		},
+		{
+			name:     "missing TLS file",
+			content:  "tls_cert: " + missing + "\n",
+			wantErrs: []string{"tls_cert: can not read " + missing},
+		},
+		{
+			name:     "unknown client auth",
+			content:  "tls_client_auth: optional\n",
+			wantErrs: []string{"tls_client_auth: unknown client auth 'optional'"},
+		},
+		{
+			name:     "plugin option",
+			content:  "mode: print\nregistration_timeout: -1s\n",
+			wantErrs: []string{"mode: must be upload or capture", "registration_timeout: must not be negative"},
+		},
+		{
+			name:     "hosted plugin option",
+			content:  "test:\n  enabled: true\n  mode: print\n",
+			host:     true,
+			wantErrs: []string{"test.mode: must be upload or capture"},
+		},
+		{
+			name:     "no hosted plugin enabled",
+			content:  "test:\n  mode: print\n",
+			host:     true,
+			wantErrs: []string{"no plugin is enabled"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			plugin := testPlugin(test.host)
+			config, err := testConfig(t, plugin, test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkErrors(t, validateConfig(plugin, config), test.wantErrs)
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		host    bool
+		want    []string
+		// notWant must not be part of the output
+		notWant []string
+	}{
+		{
+			name:    "secrets are redacted",
+			content: "shared_secret: hunter2\napi_tokens:\n  \"123\": token123\n  \"456\": token456\n",
+			want:    []string{"shared_secret: REDACTED", `"123": REDACTED`, `"456": REDACTED`, "mode: upload"},
+			notWant: []string{"hunter2", "token123", "token456", "print_config"},
+		},
+		{
+			name:    "empty secrets are kept",
+			content: "{}",
+			want:    []string{`shared_secret: ""`, "api_tokens: {}"},
+		},
+		{
+			name:    "secrets of hosted plugins are redacted",
+			content: "test:\n  enabled: true\n  api_tokens:\n    \"123\": token123\n",
+			host:    true,
+			want:    []string{"test:", `"123": REDACTED`, "enabled: true"},
+			notWant: []string{"token123"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			plugin := testPlugin(test.host)
+			config, err := testConfig(t, plugin, test.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := printConfig(&out, plugin, config); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range test.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("printed configuration does not contain %q:\n%s", want, out.String())
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(out.String(), notWant) {
+					t.Errorf("printed configuration contains %q:\n%s", notWant, out.String())
+				}
+			}
+		})
+	}
+}
+
+// testPlugin returns the configPlugin named test, hosted by a plugin host if host is set
+func testPlugin(host bool) Plugin {
+	if host {
+		return NewHost(&configPlugin{name: "test"})
+	}
+	return &configPlugin{name: "test"}
+}
+
+// testConfig loads the configuration of the plugin from a YAML file with the content
+func testConfig(t *testing.T, plugin Plugin, content string) (*viper.Viper, error) {
+	t.Helper()
+
+	configFile := filepath.Join(tempDirectory(t), "config.yml")
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	registerFlags(flags, plugin)
+	if err := flags.Parse([]string{"--config", configFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	return loadConfig(plugin, flags)
+}
+
+func tempDirectory(t *testing.T) string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "pluginruntime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	return directory
+}
+
+func checkErrors(t *testing.T, err error, wantErrs []string) {
+	t.Helper()
+
+	if len(wantErrs) == 0 {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Fatalf("no error, want %q", wantErrs)
+	}
+	for _, want := range wantErrs {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
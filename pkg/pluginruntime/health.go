@@ -0,0 +1,80 @@
+package pluginruntime
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+)
+
+// readinessInterval is the interval in which the readiness of the plugin is checked for the gRPC health service
+const readinessInterval = 5 * time.Second
+
+// readiness decides whether the plugin is ready to receive api events
+type readiness struct {
+	plugin       Plugin
+	registration *proxyapiutil.Registration
+	health       *health.Server
+	// ready is the readiness reported by the health service, it is only used by Watch
+	ready bool
+}
+
+func newReadiness(plugin Plugin, registration *proxyapiutil.Registration) *readiness {
+	r := &readiness{
+		plugin:       plugin,
+		registration: registration,
+		health:       health.NewServer(),
+	}
+	r.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return r
+}
+
+// Check returns why the plugin is not ready or nil if it is.
+func (r *readiness) Check() error {
+	if !r.registration.Registered() {
+		return errors.New("not registered at proxy api")
+	}
+
+	if checker, ok := r.plugin.(ReadinessChecker); ok {
+		if err := checker.CheckReady(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Watch updates the status of the gRPC health service whenever the readiness changed. It never returns.
+func (r *readiness) Watch() {
+	for {
+		r.update()
+		time.Sleep(readinessInterval)
+	}
+}
+
+// update sets the status of the gRPC health service if the readiness changed since the last update
+func (r *readiness) update() {
+	err := r.Check()
+	if (err == nil) == r.ready {
+		return
+	}
+	r.ready = err == nil
+
+	if r.ready {
+		log.Info().Msgf("%s plugin is ready", r.plugin.DisplayName())
+		r.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	} else {
+		log.Warn().Err(err).Msgf("%s plugin is not ready", r.plugin.DisplayName())
+		r.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// Shutdown reports the plugin as not serving from now on, regardless of its readiness.
+func (r *readiness) Shutdown() {
+	r.health.Shutdown()
+}
@@ -0,0 +1,74 @@
+package pluginruntime
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthStatus(t *testing.T) {
+	plugin := &readyPlugin{name: "healthstatus"}
+	registration := newTestRegistration(t, plugin)
+	readiness := newReadiness(plugin, registration)
+	client := serveHealth(t, readiness)
+
+	checkHealth(t, client, healthpb.HealthCheckResponse_NOT_SERVING)
+	readiness.update()
+	checkHealth(t, client, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	runTestRegistration(t, registration)
+	readiness.update()
+	checkHealth(t, client, healthpb.HealthCheckResponse_SERVING)
+
+	plugin.setNotReady(errors.New("output directory is not writable"))
+	readiness.update()
+	checkHealth(t, client, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	plugin.setNotReady(nil)
+	readiness.update()
+	checkHealth(t, client, healthpb.HealthCheckResponse_SERVING)
+
+	// once shut down, the plugin stays not serving although it is still registered
+	readiness.Shutdown()
+	checkHealth(t, client, healthpb.HealthCheckResponse_NOT_SERVING)
+	readiness.update()
+	checkHealth(t, client, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// serveHealth serves the health service of the readiness like Run and returns a client for it
+func serveHealth(t *testing.T, readiness *readiness) healthpb.HealthClient {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, readiness.health)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func checkHealth(t *testing.T, client healthpb.HealthClient, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus() != want {
+		t.Errorf("health status = %s, want %s", resp.GetStatus(), want)
+	}
+}
@@ -0,0 +1,310 @@
+package pluginruntime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+// Host runs several plugins in one process. It implements Plugin itself, so it can be passed to Run like any
+// other plugin: the runtime registers one proxy api consumer with the union of the subscribed commands of all
+// enabled plugins and the host fans out every api event to the plugins which subscribed to it.
+//
+// The flags of every plugin are prefixed with the plugin name (e.g. --profileexport.output_directory) and every
+// plugin can be configured in its own section of the configuration file:
+//
+//	profileexport:
+//	  enabled: true
+//	  output_directory: ./export
+type Host struct {
+	plugins []Plugin
+
+	flags   *pflag.FlagSet
+	enabled []Plugin
+
+	mu       sync.RWMutex
+	commands map[string][]string
+}
+
+// NewHost creates a host for the given plugins. Which of them are actually run is decided by their enabled setting.
+func NewHost(plugins ...Plugin) *Host {
+	return &Host{
+		plugins:  plugins,
+		commands: make(map[string][]string),
+	}
+}
+
+func (h *Host) Name() string        { return "pluginhost" }
+func (h *Host) DisplayName() string { return "Plugin Host" }
+func (h *Host) DefaultPort() int    { return 11106 }
+
+// DefaultLogLevel returns the most verbose default log level of all hosted plugins
+func (h *Host) DefaultLogLevel() zerolog.Level {
+	level := zerolog.InfoLevel
+	for _, p := range h.plugins {
+		if lp, ok := p.(LogLevelProvider); ok && lp.DefaultLogLevel() < level {
+			level = lp.DefaultLogLevel()
+		}
+	}
+	return level
+}
+
+func (h *Host) RegisterFlags(flags *pflag.FlagSet) {
+	h.flags = flags
+
+	for _, p := range h.plugins {
+		pluginFlags := pflag.NewFlagSet(p.Name(), pflag.ContinueOnError)
+		pluginFlags.Bool("enabled", false, fmt.Sprintf("Enable the %s plugin", p.DisplayName()))
+		p.RegisterFlags(pluginFlags)
+
+		pluginFlags.VisitAll(func(f *pflag.Flag) {
+			flags.AddFlag(&pflag.Flag{
+				Name:     hostFlagName(p, f.Name),
+				Usage:    fmt.Sprintf("%s: %s", p.DisplayName(), f.Usage),
+				Value:    f.Value,
+				DefValue: f.DefValue,
+			})
+		})
+	}
+}
+
+func (h *Host) Configure(config *viper.Viper) error {
+	for _, p := range h.plugins {
+		pluginConfig, err := h.pluginConfig(p, config)
+		if err != nil {
+			return err
+		}
+
+		if !pluginConfig.GetBool("enabled") {
+			log.Debug().Str("hostedPlugin", p.Name()).Msgf("%s plugin is disabled", p.DisplayName())
+			continue
+		}
+
+		if err := p.Configure(pluginConfig); err != nil {
+			return fmt.Errorf("failed to configure %s plugin: %w", p.DisplayName(), err)
+		}
+
+		h.enabled = append(h.enabled, p)
+		h.commands[p.Name()] = p.SubscribedCommands()
+
+		log.Info().
+			Str("hostedPlugin", p.Name()).
+			Strs("commands", h.commands[p.Name()]).
+			Msgf("%s plugin is enabled", p.DisplayName())
+	}
+
+	if len(h.enabled) == 0 {
+		return errors.New("no plugin is enabled")
+	}
+
+	return nil
+}
+
+// ValidateConfig checks the options of the enabled plugins. Errors are prefixed with the name of the plugin.
+func (h *Host) ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	enabled := 0
+
+	for _, p := range h.plugins {
+		pluginConfig, err := h.pluginConfig(p, config)
+		if err != nil {
+			errs.Add(p.Name(), err)
+			continue
+		}
+
+		if !pluginConfig.GetBool("enabled") {
+			continue
+		}
+		enabled++
+
+		if validator, ok := p.(ConfigValidator); ok {
+			errs.Append(p.Name(), validator.ValidateConfig(pluginConfig))
+		}
+	}
+
+	if enabled == 0 {
+		errs = append(errs, errors.New("no plugin is enabled"))
+	}
+
+	return errs
+}
+
+// pluginConfig builds the configuration of a single plugin from its prefixed flags, its environment variables
+// (e.g. PLUGIN_PLUGINHOST_PROFILEEXPORT_OUTPUT_DIRECTORY) and its section in the configuration file.
+func (h *Host) pluginConfig(p Plugin, config *viper.Viper) (*viper.Viper, error) {
+	pluginConfig := viper.New()
+	pluginConfig.SetEnvPrefix(fmt.Sprintf("plugin_%s_%s", h.Name(), p.Name()))
+	pluginConfig.AutomaticEnv()
+
+	var err error
+	h.flags.VisitAll(func(f *pflag.Flag) {
+		key := strings.TrimPrefix(f.Name, p.Name()+".")
+		if key == f.Name || err != nil {
+			return
+		}
+		err = pluginConfig.BindPFlag(key, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pluginConfig.MergeConfigMap(config.GetStringMap(p.Name())); err != nil {
+		return nil, err
+	}
+
+	return pluginConfig, nil
+}
+
+// Shutdown shuts down all enabled plugins which need to finish work before the process ends
+func (h *Host) Shutdown() {
+	for _, p := range h.enabled {
+		if shutdowner, ok := p.(Shutdowner); ok {
+			shutdowner.Shutdown()
+		}
+	}
+}
+
+// WatchSubscribedCommands forwards changes of the subscribed commands of the hosted plugins as a change of the
+// commands of the host
+func (h *Host) WatchSubscribedCommands(f func(commands []string)) {
+	for _, p := range h.enabled {
+		watcher, ok := p.(CommandWatcher)
+		if !ok {
+			continue
+		}
+
+		name := p.Name()
+		watcher.WatchSubscribedCommands(func(commands []string) {
+			h.mu.Lock()
+			h.commands[name] = commands
+			h.mu.Unlock()
+
+			f(h.SubscribedCommands())
+		})
+	}
+}
+
+func (h *Host) SubscribedCommands() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	commands := make([]string, 0)
+
+	for _, p := range h.enabled {
+		for _, command := range h.commands[p.Name()] {
+			if command == "*" {
+				return []string{"*"}
+			}
+
+			if !contains(commands, command) {
+				commands = append(commands, command)
+			}
+		}
+	}
+
+	return commands
+}
+
+// CheckReady reports the first enabled plugin which is not ready.
+func (h *Host) CheckReady() error {
+	for _, p := range h.enabled {
+		if checker, ok := p.(ReadinessChecker); ok {
+			if err := checker.CheckReady(); err != nil {
+				return fmt.Errorf("%s: %w", p.Name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// OnReceiveApiEvent passes the event to every enabled plugin which subscribed to the command. A failing plugin
+// does not keep the event from being delivered to the remaining plugins.
+func (h *Host) OnReceiveApiEvent(command, request, response string) error {
+	var failed hostError
+
+	for _, p := range h.enabled {
+		if !h.isSubscribed(p, command) {
+			continue
+		}
+
+		start := time.Now()
+		err := h.deliver(p, command, request, response)
+		metrics.ObserveEvent(p.Name(), command, eventOutcome(p, command, err), time.Since(start))
+
+		if err != nil {
+			log.Error().Err(err).
+				Str("hostedPlugin", p.Name()).
+				Str("command", command).
+				Msgf("%s plugin failed to handle api event", p.DisplayName())
+
+			failed = append(failed, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// hostError collects the errors of all plugins which failed to handle an api event. It unwraps to the first error,
+// so its status code is reported to the proxy.
+type hostError []error
+
+func (e hostError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e hostError) Unwrap() error { return e[0] }
+
+func (h *Host) deliver(p Plugin, command, request, response string) (err error) {
+	// a panic of one plugin must not keep the event from the other plugins
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverEvent(p.Name(), command, request, response, r)
+		}
+	}()
+
+	return p.OnReceiveApiEvent(command, request, response)
+}
+
+func (h *Host) isSubscribed(p Plugin, command string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, c := range h.commands[p.Name()] {
+		if c == "*" || c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func hostFlagName(p Plugin, name string) string {
+	return p.Name() + "." + name
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package pluginruntime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+// startMonitoringServer serves the Prometheus metrics on /metrics, the liveness on /healthz and the readiness on
+// /readyz
+func startMonitoringServer(address string, ready func() error) *http.Server {
+	server := &http.Server{Addr: address, Handler: monitoringHandler(ready)}
+
+	go func() {
+		log.Info().Str("metricsAddr", address).Msgf("Serving metrics and health checks on %s", address)
+
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Str("metricsAddr", address).Msg("Failed to serve metrics")
+		}
+	}()
+
+	return server
+}
+
+func monitoringHandler(ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, nil)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, ready())
+	})
+
+	return mux
+}
+
+func stopMonitoringServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to stop metrics server")
+	}
+}
+
+func writeStatus(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, err.Error())
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "ok")
+}
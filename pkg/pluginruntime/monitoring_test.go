@@ -0,0 +1,175 @@
+package pluginruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// readyPlugin is a plugin whose readiness is set by the test
+type readyPlugin struct {
+	name string
+
+	mu       sync.Mutex
+	notReady error
+}
+
+func (p *readyPlugin) Name() string                           { return p.name }
+func (p *readyPlugin) DisplayName() string                    { return p.name }
+func (p *readyPlugin) DefaultPort() int                       { return 0 }
+func (p *readyPlugin) RegisterFlags(_ *pflag.FlagSet)         {}
+func (p *readyPlugin) Configure(_ *viper.Viper) error         { return nil }
+func (p *readyPlugin) SubscribedCommands() []string           { return []string{"HubUserLogin"} }
+func (p *readyPlugin) OnReceiveApiEvent(_, _, _ string) error { return nil }
+
+func (p *readyPlugin) CheckReady() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.notReady
+}
+
+func (p *readyPlugin) setNotReady(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.notReady = err
+}
+
+func TestMonitoringReadiness(t *testing.T) {
+	plugin := &readyPlugin{name: "monitoringreadiness"}
+	registration := newTestRegistration(t, plugin)
+	readiness := newReadiness(plugin, registration)
+
+	server := httptest.NewServer(monitoringHandler(readiness.Check))
+	defer server.Close()
+
+	checkEndpoint(t, server, "/healthz", http.StatusOK, "ok")
+	checkEndpoint(t, server, "/readyz", http.StatusServiceUnavailable, "not registered at proxy api")
+
+	runTestRegistration(t, registration)
+	checkEndpoint(t, server, "/readyz", http.StatusOK, "ok")
+
+	plugin.setNotReady(errors.New("output directory is not writable"))
+	checkEndpoint(t, server, "/readyz", http.StatusServiceUnavailable, "output directory is not writable")
+	// the plugin is still alive
+	checkEndpoint(t, server, "/healthz", http.StatusOK, "ok")
+
+	plugin.setNotReady(nil)
+	checkEndpoint(t, server, "/readyz", http.StatusOK, "ok")
+}
+
+func TestMonitoringMetrics(t *testing.T) {
+	plugin := &readyPlugin{name: "monitoringmetrics"}
+	server := httptest.NewServer(monitoringHandler(func() error { return nil }))
+	defer server.Close()
+
+	received := `swarpf_events_received_total{command="HubUserLogin",plugin="monitoringmetrics"}`
+	handled := `swarpf_events_processed_total{command="HubUserLogin",outcome="handled",plugin="monitoringmetrics"}`
+
+	consumer := &ProxyApiConsumer{Plugin: plugin}
+	for i := 1; i <= 2; i++ {
+		if _, err := consumer.OnReceiveApiEvent(context.Background(), &pb.ApiEvent{Command: "HubUserLogin"}); err != nil {
+			t.Fatal(err)
+		}
+
+		body := get(t, server, "/metrics", http.StatusOK)
+		for _, metric := range []string{received, handled} {
+			if want := fmt.Sprintf("%s %d", metric, i); !strings.Contains(body, want+"\n") {
+				t.Errorf("metrics after %d events do not contain %s", i, want)
+			}
+		}
+	}
+}
+
+func checkEndpoint(t *testing.T, server *httptest.Server, path string, wantCode int, wantBody string) {
+	t.Helper()
+
+	if body := get(t, server, path, wantCode); strings.TrimSpace(body) != wantBody {
+		t.Errorf("GET %s = %q, want %q", path, body, wantBody)
+	}
+}
+
+func get(t *testing.T, server *httptest.Server, path string, wantCode int) string {
+	t.Helper()
+
+	resp, err := server.Client().Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != wantCode {
+		t.Errorf("GET %s returned %d, want %d: %s", path, resp.StatusCode, wantCode, body)
+	}
+	return string(body)
+}
+
+// testProxy accepts every registration
+type testProxy struct {
+	pb.UnimplementedProxyApiServer
+}
+
+func (p *testProxy) Register(_ context.Context, _ *pb.ProxyApiOptions) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+func (p *testProxy) Disconnect(_ context.Context, _ *pb.ProxyApiOptions) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+// newTestRegistration starts a proxy and returns a registration of the plugin at it, which is not run yet
+func newTestRegistration(t *testing.T, plugin Plugin) *proxyapiutil.Registration {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterProxyApiServer(server, &testProxy{})
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	options := proxyapiutil.DefaultRegistrationOptions()
+	options.InitialBackoff = 10 * time.Millisecond
+	registration := proxyapiutil.NewRegistration(lis.Addr().String(), "127.0.0.1:0", plugin.SubscribedCommands(),
+		options)
+	t.Cleanup(registration.Close)
+
+	return registration
+}
+
+// runTestRegistration runs the registration and waits until it is registered
+func runTestRegistration(t *testing.T, registration *proxyapiutil.Registration) {
+	t.Helper()
+
+	go func() { _ = registration.Run() }()
+
+	for deadline := time.Now().Add(5 * time.Second); !registration.Registered(); {
+		if time.Now().After(deadline) {
+			t.Fatal("plugin did not register at the proxy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,70 @@
+package pluginruntime
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+// grpcStatuser is implemented by errors which know the gRPC status code they should be reported with
+type grpcStatuser interface {
+	GRPCStatus() *status.Status
+}
+
+// statusFromError converts the error returned by a plugin into the error returned to the proxy. The status code and
+// the details are taken from the first error in the chain implementing GRPCStatus, all other errors are reported as
+// Internal.
+func statusFromError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var s grpcStatuser
+	if errors.As(err, &s) {
+		p := s.GRPCStatus().Proto()
+		p.Message = err.Error()
+		return status.ErrorProto(p)
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// reportOutcome logs the result of handling a single api event
+func reportOutcome(command string, err error, duration time.Duration) {
+	if err == nil {
+		log.Debug().
+			Str("command", command).
+			Str("outcome", "ok").
+			Dur("duration", duration).
+			Msg("Handled api event")
+		return
+	}
+
+	log.Error().Err(err).
+		Str("command", command).
+		Str("outcome", "failed").
+		Str("code", status.Code(statusFromError(err)).String()).
+		Dur("duration", duration).
+		Msg("Failed to handle api event")
+}
+
+// eventOutcome classifies the result of an api event for the metrics. Events of commands the plugin did not
+// subscribe to are ignored.
+func eventOutcome(p Plugin, command string, err error) string {
+	if err != nil {
+		return metrics.OutcomeFailed
+	}
+
+	for _, c := range p.SubscribedCommands() {
+		if c == "*" || c == command {
+			return metrics.OutcomeHandled
+		}
+	}
+
+	return metrics.OutcomeIgnored
+}
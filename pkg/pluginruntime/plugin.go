@@ -0,0 +1,88 @@
+package pluginruntime
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// Plugin is implemented by every swarpf plugin that should be run by the plugin runtime.
+type Plugin interface {
+	// Name returns the short identifier of the plugin. It is used as part of the environment variable prefix.
+	Name() string
+	// DisplayName returns the human readable name of the plugin used in log messages.
+	DisplayName() string
+	// DefaultPort returns the port the plugin listens on if no listen address is configured.
+	DefaultPort() int
+
+	// RegisterFlags adds the plugin specific command line flags.
+	RegisterFlags(flags *pflag.FlagSet)
+	// Configure is called after the configuration is loaded and before the plugin is registered at the proxy.
+	Configure(config *viper.Viper) error
+
+	// SubscribedCommands returns the list of commands the plugin wants to receive from the proxy.
+	SubscribedCommands() []string
+	// OnReceiveApiEvent is called for every api event the proxy sends to the plugin. An error implementing
+	// GRPCStatus, also when it is wrapped, decides the status code reported to the proxy. All other errors are
+	// reported as Internal.
+	OnReceiveApiEvent(command, request, response string) error
+}
+
+// LogLevelProvider can be implemented by plugins which need a default log level other than info.
+type LogLevelProvider interface {
+	DefaultLogLevel() zerolog.Level
+}
+
+// Shutdowner can be implemented by plugins which need to finish work before the process ends. Shutdown is called
+// after the plugin was disconnected from the proxy.
+type Shutdowner interface {
+	Shutdown()
+}
+
+// CommandWatcher can be implemented by plugins whose subscribed commands change at runtime. The plugin calls f with
+// the new list of commands whenever it changed and the runtime registers the plugin again at the proxy.
+type CommandWatcher interface {
+	WatchSubscribedCommands(f func(commands []string))
+}
+
+// ReadinessChecker can be implemented by plugins with prerequisites besides the registration at the proxy, e.g. a
+// writable output directory. The plugin is reported as ready once CheckReady returns nil.
+type ReadinessChecker interface {
+	CheckReady() error
+}
+
+// ConfigValidator can be implemented by plugins to check their options before Configure is called, e.g. that
+// directories are writable. Each error should name the key it belongs to. The runtime reports all errors at once and
+// does not start the plugin if there are any.
+type ConfigValidator interface {
+	ValidateConfig(config *viper.Viper) []error
+}
+
+// proxy API consumer
+type ProxyApiConsumer struct {
+	pb.UnimplementedProxyApiConsumerServer
+
+	Plugin Plugin
+}
+
+func (s *ProxyApiConsumer) OnReceiveApiEvent(_ context.Context, ev *pb.ApiEvent) (*empty.Empty, error) {
+	start := time.Now()
+	err := s.Plugin.OnReceiveApiEvent(ev.GetCommand(), ev.GetRequest(), ev.GetResponse())
+	duration := time.Since(start)
+
+	reportOutcome(ev.GetCommand(), err, duration)
+	// the plugin host records the events of every hosted plugin itself, counting them for the host as well would
+	// count every event twice
+	if _, ok := s.Plugin.(*Host); !ok {
+		metrics.ObserveEvent(s.Plugin.Name(), ev.GetCommand(), eventOutcome(s.Plugin, ev.GetCommand(), err), duration)
+	}
+
+	return &empty.Empty{}, statusFromError(err)
+}
@@ -0,0 +1,201 @@
+package pluginruntime
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/thecodeteam/goodbye"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+const defaultProxyAddress = "127.0.0.1:11100"
+
+// Run loads the configuration of the plugin, connects it to the proxy and serves api events until the process
+// is terminated.
+func Run(plugin Plugin) {
+	// load configuration from command line or environment
+	registerFlags(pflag.CommandLine, plugin)
+	pflag.Parse()
+
+	config, err := loadConfig(plugin, pflag.CommandLine)
+	if err != nil {
+		exitWithConfigError(err)
+	}
+
+	if config.GetBool("print_config") {
+		if err := printConfig(os.Stdout, plugin, config); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print configuration")
+		}
+		return
+	}
+
+	if err := validateConfig(plugin, config); err != nil {
+		exitWithConfigError(err)
+	}
+
+	proxyAddress := config.GetString("proxyapi_addr")
+	listenAddress := config.GetString("listen_addr")
+
+	// setup logging
+	setupLogging(plugin, config.GetBool("development"))
+
+	// setup exit routine
+	ctx := context.Background()
+	defer goodbye.Exit(ctx, 0)
+	goodbye.Notify(ctx)
+
+	if err := SetQuarantineDirectory(config.GetString("quarantine_directory")); err != nil {
+		log.Fatal().Err(err).Msg("Failed to create quarantine directory")
+	}
+
+	// configure the plugin itself. Important: This can fail and abort the plugin!
+	if err := plugin.Configure(config); err != nil {
+		log.Fatal().Err(err).Msgf("Failed to configure %s plugin", plugin.DisplayName())
+	}
+
+	registrationOptions := proxyapiutil.DefaultRegistrationOptions()
+	registrationOptions.Deadline = config.GetDuration("registration_timeout")
+	registrationOptions.MaxBackoff = config.GetDuration("registration_max_backoff")
+	registrationOptions.KeepaliveInterval = config.GetDuration("proxyapi_keepalive")
+	registrationOptions.SharedSecret = config.GetString("shared_secret")
+	registrationOptions.TransportCredentials, err = proxyCredentials(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load TLS configuration for the proxy api")
+	}
+
+	registration := proxyapiutil.NewRegistration(proxyAddress, listenAddress, plugin.SubscribedCommands(),
+		registrationOptions)
+	if watcher, ok := plugin.(CommandWatcher); ok {
+		watcher.WatchSubscribedCommands(registration.UpdateSubscribedCommands)
+	}
+
+	readiness := newReadiness(plugin, registration)
+
+	var monitoringServer *http.Server
+	if metricsAddress := config.GetString("metrics_addr"); metricsAddress != "" {
+		monitoringServer = startMonitoringServer(metricsAddress, readiness.Check)
+	}
+
+	goodbye.RegisterWithPriority(func(ctx context.Context, sig os.Signal) {
+		readiness.Shutdown()
+		registration.Close()
+
+		if shutdowner, ok := plugin.(Shutdowner); ok {
+			shutdowner.Shutdown()
+		}
+
+		if monitoringServer != nil {
+			stopMonitoringServer(monitoringServer)
+		}
+
+		log.Info().Msgf("%s plugin ended", plugin.DisplayName())
+	}, -1)
+
+	// Main Program
+	log.Info().
+		Str("proxyAddr", proxyAddress).
+		Msgf("Connecting %s plugin to proxy %s", plugin.DisplayName(), proxyAddress)
+
+	// initialize proxy consumer
+	lis, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create listener")
+	}
+
+	log.Info().
+		Str("listenAddr", listenAddress).
+		Msgf("Listening for new proxy api connections on %s", listenAddress)
+
+	var serverOptions []grpc.ServerOption
+	if creds, err := serverCredentials(config); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load TLS configuration")
+	} else if creds != nil {
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+		log.Info().Msg("TLS enabled for api events")
+	}
+
+	secret := config.GetString("shared_secret")
+	if secret != "" {
+		log.Info().Msg("Shared secret required for api events")
+	}
+
+	s := NewServer(plugin, secret, serverOptions...)
+	healthpb.RegisterHealthServer(s, readiness.health)
+	go readiness.Watch()
+
+	go func() {
+		if err := registration.Run(); err != nil {
+			log.Fatal().Err(err).
+				Str("proxyAddress", proxyAddress).
+				Str("listenAddress", listenAddress).
+				Msg("Failed to register myself at the proxy host")
+		}
+	}()
+
+	if err := s.Serve(lis); err != nil {
+		log.Info().Str("reason", err.Error()).Msg("Server stopped listening")
+	}
+}
+
+// registerFlags adds the options of the runtime and of the plugin.
+func registerFlags(flags *pflag.FlagSet, plugin Plugin) {
+	flags.String("proxyapi_addr", defaultProxyAddress, "Address of the proxy host")
+	flags.String("listen_addr", fmt.Sprintf("0.0.0.0:%d", plugin.DefaultPort()), "Listen address for the plugin")
+	flags.Bool("development", false, "Enable development logging")
+	flags.String("config", "", "Path to a YAML, TOML or JSON configuration file")
+	flags.Duration("registration_timeout", 0, "Give up if the plugin could not register at the proxy within this time. 0 retries forever")
+	flags.Duration("registration_max_backoff", 30*time.Second, "Maximum time between two registration attempts")
+	flags.Duration("proxyapi_keepalive", 0, "Interval of keepalive pings to the proxy host. 0 disables keepalive pings")
+	flags.String("metrics_addr", "", "Listen address for the HTTP endpoints /metrics, /healthz and /readyz. Empty disables them")
+	flags.Bool("print_config", false, "Print the effective configuration with redacted secrets and exit")
+	flags.String("shared_secret", "", "Secret the proxy has to send with every api event. It is passed to the proxy on registration")
+	flags.String("quarantine_directory", "", "Directory api events which made the plugin panic are written to. Empty disables the quarantine")
+	registerTlsFlags(flags)
+	plugin.RegisterFlags(flags)
+}
+
+// exitWithConfigError reports configuration errors as plain text like invalid flags, since logging is not set up yet
+// and the errors span several lines
+func exitWithConfigError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
+
+// NewServer creates the gRPC server which hands the api events sent by the proxy to the plugin. Panics of the plugin
+// are recovered and reported as Internal. If sharedSecret is not empty, every event has to carry it.
+func NewServer(plugin Plugin, sharedSecret string, options ...grpc.ServerOption) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{recoveryInterceptor(plugin)}
+	if sharedSecret != "" {
+		interceptors = append(interceptors, secretInterceptor(plugin.Name(), sharedSecret))
+	}
+	options = append(options, grpc.ChainUnaryInterceptor(interceptors...))
+
+	s := grpc.NewServer(options...)
+	pb.RegisterProxyApiConsumerServer(s, &ProxyApiConsumer{Plugin: plugin})
+	return s
+}
+
+func setupLogging(plugin Plugin, development bool) {
+	level := zerolog.InfoLevel
+	if p, ok := plugin.(LogLevelProvider); ok {
+		level = p.DefaultLogLevel()
+	}
+
+	zerolog.SetGlobalLevel(level)
+	if development {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+	}
+	log.Logger = log.With().Timestamp().Str("log_type", "plugin").Str("plugin", plugin.DisplayName()).Logger()
+}
@@ -0,0 +1,403 @@
+package pluginruntime_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// recorder is a plugin recording the commands it received
+type recorder struct {
+	name     string
+	commands []string
+	err      error
+	// panics makes the plugin panic with the command
+	panics bool
+
+	mu       sync.Mutex
+	received []string
+	watcher  func(commands []string)
+}
+
+func (r *recorder) Name() string                             { return r.name }
+func (r *recorder) DisplayName() string                      { return r.name }
+func (r *recorder) DefaultPort() int                         { return 0 }
+func (r *recorder) RegisterFlags(_ *pflag.FlagSet)           {}
+func (r *recorder) Configure(_ *viper.Viper) error           { return nil }
+func (r *recorder) SubscribedCommands() []string             { return r.commands }
+func (r *recorder) WatchSubscribedCommands(f func([]string)) { r.watcher = f }
+
+func (r *recorder) OnReceiveApiEvent(command, _, _ string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.received = append(r.received, command)
+	if r.panics {
+		panic("unexpected " + command)
+	}
+	return r.err
+}
+
+func (r *recorder) Received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.received...)
+}
+
+func TestHost(t *testing.T) {
+	login := &recorder{name: "login", commands: []string{"HubUserLogin"}}
+	siege := &recorder{name: "siege", commands: []string{"GetGuildSiegeMatchupInfo", "HubUserLogin"},
+		err: status.Error(codes.FailedPrecondition, "siege failed")}
+	disabled := &recorder{name: "disabled", commands: []string{"*"}}
+
+	proxy, _ := proxyfake.StartTest(t, pluginruntime.NewHost(login, siege, disabled), map[string]interface{}{
+		"login.enabled": true,
+		"siege.enabled": true,
+	})
+
+	tests := []struct {
+		command string
+		code    codes.Code
+		login   int
+		siege   int
+	}{
+		{"HubUserLogin", codes.FailedPrecondition, 1, 1},
+		{"GetGuildSiegeMatchupInfo", codes.FailedPrecondition, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := proxy.Send(context.Background(), tt.command, "{}", "{}")
+			if status.Code(err) != tt.code {
+				t.Fatalf("Send() error = %v, want code %s", err, tt.code)
+			}
+
+			if got := len(login.Received()); got != tt.login {
+				t.Errorf("login plugin received %d events, want %d", got, tt.login)
+			}
+			if got := len(siege.Received()); got != tt.siege {
+				t.Errorf("siege plugin received %d events, want %d", got, tt.siege)
+			}
+		})
+	}
+
+	if got := len(disabled.Received()); got != 0 {
+		t.Errorf("disabled plugin received %d events", got)
+	}
+
+	if err := proxy.Send(context.Background(), "SummonUnit", "{}", "{}"); !errors.Is(err, proxyfake.ErrNoConsumer) {
+		t.Errorf("Send(SummonUnit) error = %v, want %v", err, proxyfake.ErrNoConsumer)
+	}
+}
+
+func TestHostMetrics(t *testing.T) {
+	login := &recorder{name: "metricslogin", commands: []string{"HubUserLogin"}}
+	siege := &recorder{name: "metricssiege", commands: []string{"GetGuildSiegeMatchupInfo", "HubUserLogin"}}
+	host := pluginruntime.NewHost(login, siege)
+
+	proxy, _ := proxyfake.StartTest(t, host, map[string]interface{}{
+		"metricslogin.enabled": true,
+		"metricssiege.enabled": true,
+	})
+
+	received := func(plugin string) float64 {
+		return testutil.ToFloat64(metrics.EventsReceived.WithLabelValues(plugin, "HubUserLogin"))
+	}
+	before := map[string]float64{}
+	for _, plugin := range []string{host.Name(), login.name, siege.name} {
+		before[plugin] = received(plugin)
+	}
+
+	if err := proxy.Send(context.Background(), "HubUserLogin", "{}", "{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// every hosted plugin counts the event once, the host itself does not count it
+	for plugin, want := range map[string]float64{host.Name(): 0, login.name: 1, siege.name: 1} {
+		if got := received(plugin) - before[plugin]; got != want {
+			t.Errorf("%s counted %v events, want %v", plugin, got, want)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	directory, err := ioutil.TempDir("", "quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(directory) })
+
+	if err := pluginruntime.SetQuarantineDirectory(directory); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pluginruntime.SetQuarantineDirectory("") })
+
+	faulty := &recorder{name: "faulty", commands: []string{"*"}, panics: true}
+	login := &recorder{name: "login", commands: []string{"HubUserLogin"}}
+
+	tests := []struct {
+		name   string
+		plugin pluginruntime.Plugin
+	}{
+		{"plugin", faulty},
+		{"hosted plugin", pluginruntime.NewHost(faulty, login)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, _ := proxyfake.StartTest(t, tt.plugin, map[string]interface{}{
+				"faulty.enabled": true,
+				"login.enabled":  true,
+			})
+
+			// the plugin keeps running after a panic
+			for i := 0; i < 2; i++ {
+				err := proxy.Send(context.Background(), "HubUserLogin", `{"wizard_id": 123}`, "{}")
+				if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "HubUserLogin") {
+					t.Fatalf("Send() error = %v, want code Internal naming the command", err)
+				}
+				checkPanicStatus(t, status.Convert(err), directory)
+			}
+		})
+	}
+
+	// the hosted login plugin received the events although the other plugin panicked
+	if got := len(login.Received()); got != 2 {
+		t.Errorf("login plugin received %d events, want 2", got)
+	}
+
+	// every event is quarantined and can be replayed
+	files, err := filepath.Glob(filepath.Join(directory, "panic-*-HubUserLogin.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Fatalf("quarantined %d events, want 4", len(files))
+	}
+
+	r, err := eventcapture.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	event, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Command != "HubUserLogin" || event.Request != `{"wizard_id": 123}` {
+		t.Errorf("quarantined event = %+v", event)
+	}
+}
+
+// checkPanicStatus makes sure that the proxy receives the command, the payload and the quarantine file of a panic
+func checkPanicStatus(t *testing.T, s *status.Status, quarantineDirectory string) {
+	t.Helper()
+
+	if message := s.Message(); !strings.Contains(message, `request: {"wizard_id": 123}`) ||
+		!strings.Contains(message, "quarantined in "+quarantineDirectory) {
+		t.Errorf("status message = %q, want the request and the quarantine file", message)
+	}
+
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.ResourceInfo); ok {
+			if info.ResourceName != "HubUserLogin" || info.Owner != "faulty" ||
+				!strings.HasPrefix(info.Description, quarantineDirectory) {
+				t.Errorf("ResourceInfo = %+v, want command, plugin and quarantine file", info)
+			}
+			return
+		}
+	}
+	t.Errorf("status details = %v, want a ResourceInfo", s.Details())
+}
+
+func TestSharedSecret(t *testing.T) {
+	plugin := &recorder{name: "secret", commands: []string{"*"}}
+
+	proxy, err := proxyfake.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxy.Close)
+
+	started, err := proxy.StartPlugin(plugin, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(started.Stop)
+
+	registration, _ := proxy.Consumer(started.Address)
+	if registration.Secret != "s3cret" {
+		t.Fatalf("registered with secret %q, want s3cret", registration.Secret)
+	}
+
+	conn, err := grpc.Dial(started.Address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := pb.NewProxyApiConsumerClient(conn)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   codes.Code
+	}{
+		{"valid secret", "s3cret", codes.OK},
+		{"invalid secret", "guess", codes.Unauthenticated},
+		{"missing secret", "", codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.secret != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, proxyapiutil.SecretMetadataKey, tt.secret)
+			}
+
+			_, err := client.OnReceiveApiEvent(ctx, &pb.ApiEvent{Command: "HubUserLogin"})
+			if status.Code(err) != tt.code {
+				t.Errorf("OnReceiveApiEvent() error = %v, want code %s", err, tt.code)
+			}
+		})
+	}
+
+	if got := len(plugin.Received()); got != 1 {
+		t.Errorf("plugin received %d events, want 1", got)
+	}
+}
+
+func TestRegisterAgainOnCommandsChange(t *testing.T) {
+	plugin := &recorder{name: "watcher", commands: []string{"HubUserLogin"}}
+	proxy, started := proxyfake.StartTest(t, plugin, nil)
+
+	plugin.watcher([]string{"HubUserLogin", "SummonUnit"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for {
+		if registration, ok := proxy.Consumer(started.Address); ok && len(registration.Commands) == 2 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("plugin did not register again, registrations: %+v", proxy.Registrations())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if disconnects := proxy.Disconnects(); len(disconnects) != 1 || len(disconnects[0].Commands) != 1 {
+		t.Errorf("disconnects = %+v, want one with the old commands", disconnects)
+	}
+}
+
+func TestRegisterAgainAfterProxyRestart(t *testing.T) {
+	plugin := &recorder{name: "restart", commands: []string{"HubUserLogin"}}
+	proxy, started := proxyfake.StartTest(t, plugin, nil)
+
+	proxy.Close()
+	restarted, err := proxyfake.StartAt(proxy.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(restarted.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := restarted.WaitForConsumer(ctx, started.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Send(ctx, "HubUserLogin", "{}", "{}"); err != nil {
+		t.Fatalf("Send() after the restart error = %v", err)
+	}
+	if got := len(plugin.Received()); got != 1 {
+		t.Errorf("plugin received %d events, want 1", got)
+	}
+}
+
+func TestRegistrationDeadline(t *testing.T) {
+	// nothing listens on the address of a closed proxy
+	proxy, err := proxyfake.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Close()
+
+	options := proxyapiutil.DefaultRegistrationOptions()
+	options.InitialBackoff = 10 * time.Millisecond
+	options.AttemptTimeout = 50 * time.Millisecond
+	options.Deadline = 200 * time.Millisecond
+	registration := proxyapiutil.NewRegistration(proxy.Address(), "127.0.0.1:1", []string{"HubUserLogin"}, options)
+
+	errs := make(chan error, 1)
+	go func() { errs <- registration.Run() }()
+	t.Cleanup(registration.Close)
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "deadline") {
+			t.Errorf("Run() error = %v, want the registration deadline to be exceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the registration deadline")
+	}
+
+	if registration.Registered() {
+		t.Errorf("Registered() = true without proxy")
+	}
+}
+
+func TestCloseBeforeRun(t *testing.T) {
+	proxy, err := proxyfake.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxy.Close)
+
+	// a plugin shut down while it starts must not register anymore
+	registration := proxyapiutil.NewRegistration(proxy.Address(), "127.0.0.1:1", []string{"HubUserLogin"},
+		proxyapiutil.DefaultRegistrationOptions())
+	registration.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- registration.Run() }()
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Run() after Close() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() after Close() did not return")
+	}
+
+	if _, ok := proxy.Consumer("127.0.0.1:1"); ok {
+		t.Error("plugin registered after Close()")
+	}
+}
@@ -0,0 +1,232 @@
+package pluginruntime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// maxSnapshotLength limits the request and the response kept in a PanicError
+const maxSnapshotLength = 512
+
+// panicResourceType is the resource type of the ResourceInfo detail of a PanicError
+const panicResourceType = "swarpf.ApiEvent"
+
+// PanicError is returned instead of a panic of a plugin while it handled an api event. It is reported to the proxy
+// as Internal with the snapshots and the quarantine file in the message and a ResourceInfo detail naming the command
+// (resource name), the plugin (owner) and the quarantine file (description).
+type PanicError struct {
+	Plugin  string
+	Command string
+	// Value is the value passed to panic
+	Value interface{}
+	Stack string
+	// Request and Response are the start of the payload of the event, at most maxSnapshotLength bytes each
+	Request  string
+	Response string
+	// Quarantine is the file the event was written to, empty if it was not written
+	Quarantine string
+}
+
+func (e *PanicError) Error() string {
+	message := fmt.Sprintf("panic while handling %s: %v (request: %s, response: %s", e.Command, e.Value, e.Request,
+		e.Response)
+	if e.Quarantine != "" {
+		message += ", quarantined in " + e.Quarantine
+	}
+	return message + ")"
+}
+
+func (e *PanicError) GRPCStatus() *status.Status {
+	s := status.New(codes.Internal, e.Error())
+	detailed, err := s.WithDetails(&errdetails.ResourceInfo{
+		ResourceType: panicResourceType,
+		ResourceName: e.Command,
+		Owner:        e.Plugin,
+		Description:  e.Quarantine,
+	})
+	if err != nil {
+		return s
+	}
+	return detailed
+}
+
+var (
+	quarantineMu        sync.Mutex
+	quarantineDirectory string
+)
+
+// SetQuarantineDirectory makes the runtime write every api event which made a plugin panic to a file in the
+// directory. The files can be replayed to the plugin to reproduce the panic. Empty disables the quarantine.
+func SetQuarantineDirectory(directory string) error {
+	if directory != "" {
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return fmt.Errorf("failed to create quarantine directory: %w", err)
+		}
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	quarantineDirectory = directory
+	return nil
+}
+
+// quarantinedEvent is written to the quarantine directory. It has the fields of an event of the event capture plugin
+// and the details of the panic, so the file can be replayed with the replay tool.
+type quarantinedEvent struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Request  string    `json:"request"`
+	Response string    `json:"response"`
+	Plugin   string    `json:"plugin"`
+	Panic    string    `json:"panic"`
+	Stack    string    `json:"stack"`
+}
+
+// recoverEvent converts the value of a recovered panic into a PanicError. The panic is logged, counted and the event
+// is quarantined.
+func recoverEvent(plugin, command, request, response string, value interface{}) *PanicError {
+	e := &PanicError{
+		Plugin:   plugin,
+		Command:  command,
+		Value:    value,
+		Stack:    string(debug.Stack()),
+		Request:  snapshot(request),
+		Response: snapshot(response),
+	}
+
+	path, err := quarantine(quarantinedEvent{
+		Time:     time.Now().UTC(),
+		Command:  command,
+		Request:  request,
+		Response: response,
+		Plugin:   plugin,
+		Panic:    fmt.Sprint(value),
+		Stack:    e.Stack,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("command", command).Msg("Failed to quarantine api event")
+	}
+	e.Quarantine = path
+
+	log.Error().
+		Str("plugin", plugin).
+		Str("command", command).
+		Str("panic", fmt.Sprint(value)).
+		Str("request", e.Request).
+		Str("response", e.Response).
+		Str("quarantine", path).
+		Str("stack", e.Stack).
+		Msg("Recovered from panic while handling api event")
+	metrics.Panics.WithLabelValues(plugin, command).Inc()
+
+	return e
+}
+
+// quarantine writes the event to a new file in the quarantine directory and returns its path. Nothing is written if
+// the quarantine is not enabled.
+func quarantine(event quarantinedEvent) (string, error) {
+	quarantineMu.Lock()
+	directory := quarantineDirectory
+	quarantineMu.Unlock()
+
+	if directory == "" {
+		return "", nil
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		name := fmt.Sprintf("panic-%s-%s.jsonl",
+			time.Now().UTC().Format("20060102T150405.000000000Z"), fileNamePart(event.Command))
+		path := filepath.Join(directory, name)
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = f.Write(append(line, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return path, err
+	}
+}
+
+// fileNamePart replaces all characters of a command which are not safe in file names
+func fileNamePart(command string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, command)
+}
+
+// snapshot returns the start of a payload, cut at a character boundary
+func snapshot(payload string) string {
+	if len(payload) <= maxSnapshotLength {
+		return payload
+	}
+
+	cut := maxSnapshotLength
+	for cut > 0 && !utf8.RuneStart(payload[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d bytes)", payload[:cut], len(payload))
+}
+
+// recoveryInterceptor turns a panic while handling a call into an Internal error, so a single unexpected api event
+// does not end the plugin process.
+func recoveryInterceptor(plugin Plugin) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp interface{}, err error) {
+		start := time.Now()
+
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+
+			command := info.FullMethod
+			event, isEvent := req.(*pb.ApiEvent)
+			if isEvent {
+				command = event.GetCommand()
+			}
+
+			panicErr := recoverEvent(plugin.Name(), command, event.GetRequest(), event.GetResponse(), value)
+			if isEvent {
+				metrics.ObserveEvent(plugin.Name(), command, metrics.OutcomeFailed, time.Since(start))
+			}
+
+			resp, err = nil, statusFromError(panicErr)
+		}()
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,64 @@
+package pluginruntime
+
+import (
+	"errors"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/swarpf/plugins/internal/tlsconfig"
+)
+
+func registerTlsFlags(flags *pflag.FlagSet) {
+	flags.String("tls_cert", "", "Certificate file of the plugin server. Enables TLS for the api events sent by the proxy")
+	flags.String("tls_key", "", "Key file of the plugin server certificate")
+	flags.String("tls_client_ca", "", "CA file to verify the certificate of the proxy. Enables mutual TLS for the api events")
+	flags.String("tls_client_auth", string(tlsconfig.RequireClientCert), "Whether the proxy has to present a certificate signed by tls_client_ca: require, verify_if_given or none")
+	flags.Bool("proxyapi_tls", false, "Connect to the proxy api with TLS")
+	flags.String("proxyapi_ca", "", "CA file to verify the certificate of the proxy api. Empty uses the system roots")
+	flags.String("proxyapi_cert", "", "Client certificate file presented to the proxy api for mutual TLS")
+	flags.String("proxyapi_key", "", "Key file of the client certificate presented to the proxy api")
+	flags.String("proxyapi_server_name", "", "Name to verify the certificate of the proxy api against instead of its host name")
+}
+
+// serverCredentials returns the credentials of the plugin server or nil if TLS is disabled
+func serverCredentials(config *viper.Viper) (credentials.TransportCredentials, error) {
+	clientAuth, err := tlsconfig.ParseClientAuth(config.GetString("tls_client_auth"))
+	if err != nil {
+		return nil, err
+	}
+
+	options := tlsconfig.Options{
+		CertFile:   config.GetString("tls_cert"),
+		KeyFile:    config.GetString("tls_key"),
+		CAFile:     config.GetString("tls_client_ca"),
+		ClientAuth: clientAuth,
+	}
+
+	if options.CertFile == "" && options.KeyFile == "" {
+		if options.CAFile != "" {
+			return nil, errors.New("tls_client_ca requires tls_cert and tls_key")
+		}
+		return nil, nil
+	}
+
+	return tlsconfig.NewServerCredentials(options)
+}
+
+// proxyCredentials returns the credentials used to connect to the proxy api or nil if TLS is disabled. Setting any
+// of the TLS files enables TLS.
+func proxyCredentials(config *viper.Viper) (credentials.TransportCredentials, error) {
+	options := tlsconfig.Options{
+		CertFile:   config.GetString("proxyapi_cert"),
+		KeyFile:    config.GetString("proxyapi_key"),
+		CAFile:     config.GetString("proxyapi_ca"),
+		ServerName: config.GetString("proxyapi_server_name"),
+	}
+
+	if !config.GetBool("proxyapi_tls") && options.CertFile == "" && options.KeyFile == "" && options.CAFile == "" {
+		return nil, nil
+	}
+
+	return tlsconfig.NewClientCredentials(options)
+}
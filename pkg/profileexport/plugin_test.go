@@ -0,0 +1,200 @@
+package profileexport_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+	"github.com/swarpf/plugins/pkg/profileexport"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+)
+
+const loginResponse = `{
+	"ret_code": 0,
+	"wizard_info": {"wizard_id": 123, "wizard_name": "Tester"},
+	"building_list": [
+		{"building_id": 10, "building_master_id": 1},
+		{"building_id": 11, "building_master_id": 25}
+	],
+	"unit_list": [
+		{"unit_id": 1, "building_id": 10, "unit_level": 40, "class": 6, "attribute": 1,
+		 "runes": [{"rune_id": 3, "slot_no": 2}, {"rune_id": 4, "slot_no": 1}]},
+		{"unit_id": 2, "building_id": 11, "unit_level": 1, "class": 1, "attribute": 2, "runes": {}},
+		{"unit_id": 3, "building_id": 10, "unit_level": 35, "class": 5, "attribute": 3, "runes": []}
+	],
+	"runes": {"5": {"rune_id": 5, "slot_no": 6}, "6": {"rune_id": 6, "slot_no": 3}},
+	"rune_craft_item_list": [
+		{"craft_item_id": 1, "craft_type": 2},
+		{"craft_item_id": 2, "craft_type": 1}
+	]
+}`
+
+type profile struct {
+	UnitList []struct {
+		UnitId uint64 `json:"unit_id"`
+		Runes  []struct {
+			SlotNo int `json:"slot_no"`
+		} `json:"runes"`
+	} `json:"unit_list"`
+	Runes []struct {
+		SlotNo int `json:"slot_no"`
+	} `json:"runes"`
+	RuneCraftItemList []struct {
+		CraftItemId int `json:"craft_item_id"`
+	} `json:"rune_craft_item_list"`
+}
+
+func TestPlugin(t *testing.T) {
+	directory := tempDir(t)
+	proxy, plugin := proxyfake.StartTest(t, &profileexport.Plugin{}, map[string]interface{}{
+		"output_directory": directory,
+	})
+
+	registration, _ := proxy.Consumer(plugin.Address)
+	if len(registration.Commands) != 2 {
+		t.Fatalf("registered commands = %v, want HubUserLogin and GuestLogin", registration.Commands)
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		response string
+		code     codes.Code
+		file     string
+	}{
+		{"hub login", "HubUserLogin", loginResponse, codes.OK, "Tester-123.json"},
+		{"guest login", "GuestLogin", loginResponse, codes.OK, "Tester-123.json"},
+		{"invalid json", "HubUserLogin", "{", codes.Internal, ""},
+		{"missing building list", "HubUserLogin", `{"wizard_info": {"wizard_id": 1, "wizard_name": "X"}}`, codes.Internal, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := proxy.Send(context.Background(), tt.command, `{"command":"`+tt.command+`"}`, tt.response)
+			if status.Code(err) != tt.code {
+				t.Fatalf("Send() error = %v, want code %s", err, tt.code)
+			}
+			if tt.file == "" {
+				return
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(directory, tt.file))
+			if err != nil {
+				t.Fatalf("profile was not exported: %v", err)
+			}
+
+			exported := profile{}
+			if err := json.Unmarshal(content, &exported); err != nil {
+				t.Fatal(err)
+			}
+
+			// units in the storage come last, the others are sorted by class descending
+			var unitIds []uint64
+			for _, unit := range exported.UnitList {
+				unitIds = append(unitIds, unit.UnitId)
+			}
+			if !equalUints(unitIds, []uint64{1, 3, 2}) {
+				t.Errorf("unit order = %v, want [1 3 2]", unitIds)
+			}
+
+			if runes := exported.UnitList[0].Runes; len(runes) != 2 || runes[0].SlotNo != 1 || runes[1].SlotNo != 2 {
+				t.Errorf("unit runes are not sorted by slot: %+v", runes)
+			}
+			if runes := exported.Runes; len(runes) != 2 || runes[0].SlotNo != 3 || runes[1].SlotNo != 6 {
+				t.Errorf("inventory runes are not sorted by slot: %+v", runes)
+			}
+			if items := exported.RuneCraftItemList; len(items) != 2 || items[0].CraftItemId != 2 {
+				t.Errorf("craft items are not sorted by type: %+v", items)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	file := filepath.Join(tempDir(t), "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := proxyfake.Config(&profileexport.Plugin{}, map[string]interface{}{"output_directory": file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := (&profileexport.Plugin{}).ValidateConfig(config); len(errs) != 1 {
+		t.Fatalf("ValidateConfig() = %v, want an error for output_directory", errs)
+	}
+}
+
+func equalUints(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestGolden exports the logins of the fixture corpus and compares the profiles with testdata/golden.
+func TestGolden(t *testing.T) {
+	directory := tempDir(t)
+	proxy, _ := proxyfake.StartTest(t, &profileexport.Plugin{}, map[string]interface{}{
+		"output_directory": directory,
+	})
+
+	for _, command := range profileexport.SubscribedCommands() {
+		list, err := fixtures.List(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 {
+			t.Errorf("the corpus has no fixture for %s", command)
+		}
+
+		for _, f := range list {
+			t.Run(f.Name, func(t *testing.T) {
+				err := proxy.Send(context.Background(), f.Command, f.RequestString(), f.ResponseString())
+				if err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+
+				files, err := ioutil.ReadDir(directory)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(files) != 1 {
+					t.Fatalf("exported %d files, want 1", len(files))
+				}
+
+				path := filepath.Join(directory, files[0].Name())
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = os.Remove(path)
+
+				fixtures.CheckGolden(t, filepath.Join("testdata", "golden", files[0].Name()), content)
+			})
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "profileexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(directory) })
+
+	return directory
+}
@@ -9,6 +9,9 @@ import (
 	"sort"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/pkg/gamemodel"
 )
 
 var outputDirectory string
@@ -62,42 +65,52 @@ func SetOutputDirectory(directory string) {
 	outputDirectory = directory
 }
 
+// CheckOutputDirectory makes sure that files can be written to the output directory.
+func CheckOutputDirectory() error {
+	f, err := ioutil.TempFile(GetOutputDirectory(), ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("output directory is not writable: %w", err)
+	}
+
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
 func OnReceiveApiEvent(command, _, response string) error {
 	if !isSubscribedCommand(command) {
 		return nil
 	}
 
-	responseContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(response), &responseContent); err != nil {
+	login := gamemodel.Login{}
+	if err := json.Unmarshal([]byte(response), &login); err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie profile export response")
 		return errors.New("error while deserializing profile export response")
 	}
 
-	wizardInfo := responseContent["wizard_info"].(map[string]interface{})
-	wizardId := int64(wizardInfo["wizard_id"].(float64))
-	wizardName := wizardInfo["wizard_name"].(string)
+	wizardId := login.WizardInfo.WizardId
+	wizardName := login.WizardInfo.WizardName
 
 	log.Info().
 		Str("command", command).
-		Int64("wizardId", wizardId).
+		Str("wizardId", wizardId.String()).
 		Str("wizardName", wizardName).
 		Msg("Received command used in profile export")
 
 	// check data integrity
-	dataIsOk := checkData(responseContent)
+	dataIsOk := checkData(&login)
 	if !dataIsOk {
 		log.Error().Msg("Some data in the API response is missing.")
 		return errors.New("received incomplete data from API")
 	}
 
 	// sort data
-	sortedData := sortData(responseContent)
+	sortData(&login)
 
 	// serialize sorted data back to json
-	jsonBytes, err := json.Marshal(sortedData)
+	jsonBytes, err := json.Marshal(login)
 	if err != nil {
 		log.Error().Err(err).
-			Int64("wizardId", wizardId).
+			Str("wizardId", wizardId.String()).
 			Msg("Something went wrong while re-serializing the API response.")
 		return errors.New("serialization failed - sorted data is corrupt")
 	}
@@ -105,112 +118,76 @@ func OnReceiveApiEvent(command, _, response string) error {
 	// write sorted data to profile file
 	filePath := fmt.Sprintf("%v/%v-%v.json", GetOutputDirectory(), wizardName, wizardId)
 	err = ioutil.WriteFile(filePath, jsonBytes, 0664)
+	metrics.FileWritten("profileexport", err)
 	if err != nil {
 		log.Error().Err(err).
-			Int64("wizardId", wizardId).
+			Str("wizardId", wizardId.String()).
 			Str("filePath", filePath).
 			Msg("Could not write profile JSON to file")
 		return fmt.Errorf("failed to write profile to file, error: %v", err.Error())
 	}
 
 	log.Info().
-		Int64("wizardId", wizardId).
+		Str("wizardId", wizardId.String()).
 		Str("filePath", filePath).
 		Msgf("Profile successfully exported to %s", filePath)
 
 	return nil
 }
 
-func checkData(data map[string]interface{}) bool {
-	_, hasBuildingList := data["building_list"]
-
-	return hasBuildingList
+func checkData(login *gamemodel.Login) bool {
+	return login.Has("building_list") && login.WizardInfo.WizardId != ""
 }
 
-func sortData(data map[string]interface{}) map[string]interface{} {
+func sortData(login *gamemodel.Login) {
 	// find storage building
-	var storageId uint64 = 999
-	buildingList := data["building_list"].([]interface{})
-
-	for _, entry := range buildingList {
-		building := entry.(map[string]interface{})
-
-		buildingMasterId := uint64(building["building_master_id"].(float64))
-		buildingId := uint64(building["building_id"].(float64))
-
-		if buildingMasterId == 25 {
-			storageId = buildingId
+	storageId := json.Number("999")
+	for _, building := range login.BuildingList {
+		if building.BuildingMasterId == gamemodel.StorageBuildingMasterId {
+			storageId = building.BuildingId
 		}
 	}
 
-	// sort unit list
-	unitListRef := data["unit_list"].([]interface{})
-	sort.Slice(unitListRef, func(i, j int) bool {
-		a := newJsonUnit(unitListRef[i].(map[string]interface{}))
-		b := newJsonUnit(unitListRef[j].(map[string]interface{}))
-
-		if a.BuildingId == storageId || b.BuildingId == storageId {
-			aIsStorage := a.BuildingId == storageId
-			bIsStorage := b.BuildingId == storageId
-			if aIsStorage && !bIsStorage {
-				return true
-			} else if !aIsStorage && bIsStorage {
-				return false
-			}
+	// sort unit list, units in the storage last after reversing
+	units := login.UnitList
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+
+		aIsStorage := a.BuildingId == storageId
+		bIsStorage := b.BuildingId == storageId
+		if aIsStorage != bIsStorage {
+			return aIsStorage
 		}
 
-		if abs(int64(b.Class-a.Class)) != 0 {
+		if a.Class != b.Class {
 			return a.Class < b.Class
 		}
 
-		if abs(int64(b.UnitLevel-a.UnitLevel)) != 0 {
+		if a.UnitLevel != b.UnitLevel {
 			return a.UnitLevel < b.UnitLevel
 		}
 
-		if abs(int64(a.Attribute-b.Attribute)) != 0 {
+		if a.Attribute != b.Attribute {
 			return a.Attribute > b.Attribute
 		}
 
-		if abs(int64(a.UnitId-b.UnitId)) != 0 {
-			return a.UnitId > b.UnitId
-		}
-
-		return false
+		return gamemodel.ParseId(a.UnitId) > gamemodel.ParseId(b.UnitId)
 	})
 
 	// reverse sorting
-	for i := len(unitListRef)/2 - 1; i >= 0; i-- {
-		opp := len(unitListRef) - 1 - i
-		unitListRef[i], unitListRef[opp] = unitListRef[opp], unitListRef[i]
+	for i := len(units)/2 - 1; i >= 0; i-- {
+		opp := len(units) - 1 - i
+		units[i], units[opp] = units[opp], units[i]
 	}
 
 	// sort runes on monsters by slot
-	for _, entry := range unitListRef {
-		unit := entry.(map[string]interface{})
-
-		sortedUnitRunes := sortRunes(unit["runes"])
-		unit["runes"] = sortedUnitRunes
+	for _, unit := range units {
+		sort.Sort(RunesBySlot(unit.Runes))
 	}
 
 	// sort runes in inventory by slot
-	sortedRunes := sortRunes(data["runes"])
-	data["runes"] = sortedRunes
+	sort.Sort(RunesBySlot(login.Runes))
 
 	// sort craft items
-	craftItems := data["rune_craft_item_list"].([]interface{})
-	sort.Sort(CraftItemsByTypeAndId(craftItems))
-
-	return data
-}
-
-func sortRunes(runeElement interface{}) interface{} {
-	runes, ok := runeElement.([]interface{})
-	if !ok {
-		runes = make([]interface{}, 0)
-		for _, v := range runeElement.(map[string]interface{}) {
-			runes = append(runes, v)
-		}
-	}
-	sort.Sort(RunesBySlot(runes))
-	return runes
+	sort.Sort(CraftItemsByTypeAndId(login.RuneCraftItemList))
 }
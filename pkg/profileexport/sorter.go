@@ -1,51 +1,29 @@
 package profileexport
 
-func abs(n int64) int64 {
-	y := n >> 63
-	return (n ^ y) - y
-}
+import "github.com/swarpf/plugins/pkg/gamemodel"
 
-// Sort units according to the rank etc.
-type CraftItemsByTypeAndId []interface{}
+// Sort craft items by type, newest first
+type CraftItemsByTypeAndId []gamemodel.CraftItem
 
 func (r CraftItemsByTypeAndId) Len() int { return len(r) }
 
 func (r CraftItemsByTypeAndId) Less(i, j int) bool {
-	a := r[i].(map[string]interface{})
-	b := r[j].(map[string]interface{})
-
-	typeA := uint(a["craft_type"].(float64))
-	typeB := uint(b["craft_type"].(float64))
-
-	itemIdA := uint(a["craft_item_id"].(float64))
-	itemIdB := uint(b["craft_item_id"].(float64))
-
-	if abs(int64(typeB-typeA)) != 0 {
-		return typeA < typeB
-	}
+	a, b := r[i], r[j]
 
-	if abs(int64(itemIdB-itemIdA)) != 0 {
-		return itemIdA > itemIdB
+	if a.CraftType != b.CraftType {
+		return a.CraftType < b.CraftType
 	}
 
-	return false
+	return gamemodel.ParseId(a.CraftItemId) > gamemodel.ParseId(b.CraftItemId)
 }
 
 func (r CraftItemsByTypeAndId) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
 
 // Sort interface for runes
-type RunesBySlot []interface{}
+type RunesBySlot []gamemodel.Rune
 
 func (r RunesBySlot) Len() int { return len(r) }
 
-func (r RunesBySlot) Less(i, j int) bool {
-	runeA := r[i].(map[string]interface{})
-	runeB := r[j].(map[string]interface{})
-
-	slotA := uint(runeA["slot_no"].(float64))
-	slotB := uint(runeB["slot_no"].(float64))
-
-	return slotA < slotB
-}
+func (r RunesBySlot) Less(i, j int) bool { return r[i].SlotNo < r[j].SlotNo }
 
 func (r RunesBySlot) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
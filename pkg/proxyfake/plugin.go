@@ -0,0 +1,105 @@
+package proxyfake
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+)
+
+// registrationTimeout is the time StartPlugin waits for the plugin to register
+const registrationTimeout = 10 * time.Second
+
+// Plugin is a plugin served and registered at a fake proxy like the plugin runtime does it.
+type Plugin struct {
+	Plugin  pluginruntime.Plugin
+	Address string
+
+	server       *grpc.Server
+	registration *proxyapiutil.Registration
+	stopOnce     sync.Once
+}
+
+// StartPlugin serves the plugin on a random port of the loopback interface and registers it at the proxy. The plugin
+// has to be configured already. It returns once the proxy received the registration. A non-empty shared secret is
+// required with every api event, like with the --shared_secret flag.
+func (p *Proxy) StartPlugin(plugin pluginruntime.Plugin, sharedSecret string) (*Plugin, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := pluginruntime.NewServer(plugin, sharedSecret)
+	go func() { _ = server.Serve(lis) }()
+
+	options := proxyapiutil.DefaultRegistrationOptions()
+	options.InitialBackoff = 10 * time.Millisecond
+	options.MaxBackoff = 100 * time.Millisecond
+	options.SharedSecret = sharedSecret
+
+	address := lis.Addr().String()
+	registration := proxyapiutil.NewRegistration(p.Address(), address, plugin.SubscribedCommands(), options)
+	if watcher, ok := plugin.(pluginruntime.CommandWatcher); ok {
+		watcher.WatchSubscribedCommands(registration.UpdateSubscribedCommands)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- registration.Run() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), registrationTimeout)
+	defer cancel()
+
+	if _, err := p.WaitForConsumer(ctx, address); err != nil {
+		registration.Close()
+		server.Stop()
+
+		select {
+		case runErr := <-errs:
+			if runErr != nil {
+				return nil, runErr
+			}
+		default:
+		}
+		return nil, err
+	}
+
+	return &Plugin{Plugin: plugin, Address: address, server: server, registration: registration}, nil
+}
+
+// Stop disconnects the plugin from the proxy, stops serving it and shuts it down if it implements
+// pluginruntime.Shutdowner, in the order of the plugin runtime. Only the first call has an effect.
+func (p *Plugin) Stop() {
+	p.stopOnce.Do(func() {
+		p.registration.Close()
+		p.server.Stop()
+
+		if shutdowner, ok := p.Plugin.(pluginruntime.Shutdowner); ok {
+			shutdowner.Shutdown()
+		}
+	})
+}
+
+// Config returns the configuration of a plugin as the runtime builds it: the defaults of the plugin flags, overridden
+// by settings. Keys are the flag names, e.g. "output_directory".
+func Config(plugin pluginruntime.Plugin, settings map[string]interface{}) (*viper.Viper, error) {
+	flags := pflag.NewFlagSet(plugin.Name(), pflag.ContinueOnError)
+	plugin.RegisterFlags(flags)
+
+	config := viper.New()
+	if err := config.BindPFlags(flags); err != nil {
+		return nil, err
+	}
+
+	for key, value := range settings {
+		config.Set(key, value)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,264 @@
+// Package proxyfake implements a stand-in for the swarpf proxy in the same process. It serves the proxy api plugins
+// register at, records the registrations and pushes api events to the registered plugins like the proxy does, so
+// plugins can be tested without a running proxy or network access.
+package proxyfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// ErrNoConsumer is returned by Send if no registered plugin subscribed to the command.
+var ErrNoConsumer = errors.New("no plugin subscribed to the command")
+
+// Registration is a call to Register or Disconnect received by the proxy.
+type Registration struct {
+	Address  string
+	Commands []string
+	// Secret is the shared secret the plugin sent with the call. It is sent back with every api event.
+	Secret string
+}
+
+// Proxy is a fake proxy listening on a random port of the loopback interface.
+type Proxy struct {
+	server   *grpc.Server
+	listener net.Listener
+
+	mu            sync.Mutex
+	consumers     map[string]Registration
+	registrations []Registration
+	disconnects   []Registration
+	conns         map[string]*grpc.ClientConn
+	changed       chan struct{}
+}
+
+// Start starts a proxy. It has to be stopped with Close.
+func Start() (*Proxy, error) {
+	return StartAt("127.0.0.1:0")
+}
+
+// StartAt starts a proxy listening on address, e.g. on the address of a closed proxy to simulate a restart. It has to
+// be stopped with Close.
+func StartAt(address string) (*Proxy, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		server:    grpc.NewServer(),
+		listener:  lis,
+		consumers: make(map[string]Registration),
+		conns:     make(map[string]*grpc.ClientConn),
+		changed:   make(chan struct{}),
+	}
+	pb.RegisterProxyApiServer(p.server, &proxyApiServer{proxy: p})
+
+	go func() { _ = p.server.Serve(lis) }()
+
+	return p, nil
+}
+
+// Address is the address plugins have to register at.
+func (p *Proxy) Address() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops the proxy and closes the connections to the plugins.
+func (p *Proxy) Close() {
+	p.server.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = make(map[string]*grpc.ClientConn)
+}
+
+// Registrations returns all calls to Register received so far, including repeated ones.
+func (p *Proxy) Registrations() []Registration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Registration(nil), p.registrations...)
+}
+
+// Disconnects returns all calls to Disconnect received so far.
+func (p *Proxy) Disconnects() []Registration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Registration(nil), p.disconnects...)
+}
+
+// Consumer returns the current registration of the plugin listening on address.
+func (p *Proxy) Consumer(address string) (Registration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.consumers[address]
+	return r, ok
+}
+
+// WaitForConsumer blocks until a plugin listening on address is registered or ctx is done.
+func (p *Proxy) WaitForConsumer(ctx context.Context, address string) (Registration, error) {
+	for {
+		p.mu.Lock()
+		r, ok := p.consumers[address]
+		changed := p.changed
+		p.mu.Unlock()
+
+		if ok {
+			return r, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Registration{}, fmt.Errorf("plugin %s did not register: %w", address, ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// Send pushes an api event to every registered plugin which subscribed to the command and returns the first error.
+func (p *Proxy) Send(ctx context.Context, command, request, response string) error {
+	p.mu.Lock()
+	var consumers []Registration
+	for _, r := range p.consumers {
+		if subscribed(r.Commands, command) {
+			consumers = append(consumers, r)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(consumers) == 0 {
+		return ErrNoConsumer
+	}
+
+	var firstErr error
+	for _, r := range consumers {
+		if err := p.send(ctx, r, command, request, response); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SendTo pushes an api event to the plugin listening on address, whether it subscribed to the command or not.
+func (p *Proxy) SendTo(ctx context.Context, address, command, request, response string) error {
+	r, ok := p.Consumer(address)
+	if !ok {
+		return fmt.Errorf("no plugin registered for %s", address)
+	}
+
+	return p.send(ctx, r, command, request, response)
+}
+
+func (p *Proxy) send(ctx context.Context, r Registration, command, request, response string) error {
+	conn, err := p.conn(r.Address)
+	if err != nil {
+		return err
+	}
+
+	if r.Secret != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, proxyapiutil.SecretMetadataKey, r.Secret)
+	}
+
+	_, err = pb.NewProxyApiConsumerClient(conn).OnReceiveApiEvent(ctx, &pb.ApiEvent{
+		Command:  command,
+		Request:  request,
+		Response: response,
+	})
+	return err
+}
+
+func (p *Proxy) conn(address string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[address]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	p.conns[address] = conn
+	return conn, nil
+}
+
+// notify wakes up everyone waiting for a change of the consumers. It is called with mu held.
+func (p *Proxy) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func subscribed(commands []string, command string) bool {
+	for _, c := range commands {
+		if c == "*" || c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyApiServer implements the proxy api of the fake proxy
+type proxyApiServer struct {
+	pb.UnimplementedProxyApiServer
+	proxy *Proxy
+}
+
+func (s *proxyApiServer) Register(ctx context.Context, options *pb.ProxyApiOptions) (*empty.Empty, error) {
+	r := newRegistration(ctx, options)
+
+	s.proxy.mu.Lock()
+	defer s.proxy.mu.Unlock()
+
+	s.proxy.registrations = append(s.proxy.registrations, r)
+	s.proxy.consumers[r.Address] = r
+	s.proxy.notify()
+
+	return &empty.Empty{}, nil
+}
+
+func (s *proxyApiServer) Disconnect(ctx context.Context, options *pb.ProxyApiOptions) (*empty.Empty, error) {
+	r := newRegistration(ctx, options)
+
+	s.proxy.mu.Lock()
+	defer s.proxy.mu.Unlock()
+
+	s.proxy.disconnects = append(s.proxy.disconnects, r)
+	delete(s.proxy.consumers, r.Address)
+	s.proxy.notify()
+
+	return &empty.Empty{}, nil
+}
+
+func newRegistration(ctx context.Context, options *pb.ProxyApiOptions) Registration {
+	r := Registration{
+		Address:  options.GetAddress(),
+		Commands: append([]string(nil), options.GetCommands()...),
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(proxyapiutil.SecretMetadataKey); len(values) > 0 {
+			r.Secret = values[0]
+		}
+	}
+
+	return r
+}
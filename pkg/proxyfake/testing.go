@@ -0,0 +1,43 @@
+package proxyfake
+
+import (
+	"testing"
+
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+)
+
+// StartTest validates and configures the plugin with settings like the plugin runtime, starts a proxy and registers
+// the plugin at it. The plugin and the proxy are stopped when the test ends.
+func StartTest(t testing.TB, plugin pluginruntime.Plugin, settings map[string]interface{}) (*Proxy, *Plugin) {
+	t.Helper()
+
+	config, err := Config(plugin, settings)
+	if err != nil {
+		t.Fatalf("failed to create configuration: %v", err)
+	}
+
+	if validator, ok := plugin.(pluginruntime.ConfigValidator); ok {
+		if errs := validator.ValidateConfig(config); len(errs) > 0 {
+			t.Fatal(configcheck.Errors(errs))
+		}
+	}
+
+	if err := plugin.Configure(config); err != nil {
+		t.Fatalf("failed to configure %s plugin: %v", plugin.DisplayName(), err)
+	}
+
+	proxy, err := Start()
+	if err != nil {
+		t.Fatalf("failed to start proxy: %v", err)
+	}
+	t.Cleanup(proxy.Close)
+
+	started, err := proxy.StartPlugin(plugin, "")
+	if err != nil {
+		t.Fatalf("failed to start %s plugin: %v", plugin.DisplayName(), err)
+	}
+	t.Cleanup(started.Stop)
+
+	return proxy, started
+}
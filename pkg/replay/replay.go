@@ -0,0 +1,160 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/gamemodel"
+)
+
+// Options controls which events are replayed and how fast.
+type Options struct {
+	// Speed scales the time between two events as they were recorded: 1 replays in real time, 2 twice as fast.
+	// 0 replays the events as fast as the target accepts them.
+	Speed float64
+	// MaxPause caps the time between two events, so long idle periods of a capture are skipped. 0 disables the cap.
+	MaxPause time.Duration
+	// Commands limits the replay to these commands. Empty replays all commands.
+	Commands []string
+	// ExcludeCommands are never replayed.
+	ExcludeCommands []string
+	// WizardIds limits the replay to events whose request has one of these wizard_id values. Events without
+	// wizard_id are skipped if it is set.
+	WizardIds []string
+	// StopOnError ends the replay at the first event the target failed to handle.
+	StopOnError bool
+}
+
+// Result counts the replayed events.
+type Result struct {
+	Delivered int
+	Skipped   int
+	Failed    int
+}
+
+// Files expands the directories in paths to the capture segments they contain, oldest first.
+func Files(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		segments, err := eventcapture.Segments(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, segments...)
+	}
+
+	return files, nil
+}
+
+// Replay delivers the events of the capture files to target in the order they were recorded. It returns early if
+// ctx is cancelled or, with StopOnError, the target failed to handle an event.
+func Replay(ctx context.Context, files []string, target Target, options Options) (Result, error) {
+	result := Result{}
+	var previous time.Time
+
+	err := eventcapture.ReadFiles(files, func(event eventcapture.Event) error {
+		if !options.accepts(event) {
+			result.Skipped++
+			return nil
+		}
+
+		if err := options.wait(ctx, previous, event.Time); err != nil {
+			return err
+		}
+		previous = event.Time
+
+		if err := target(ctx, event); err != nil {
+			result.Failed++
+			log.Error().Err(err).
+				Str("command", event.Command).
+				Time("recorded", event.Time).
+				Msg("Target failed to handle replayed event")
+
+			if options.StopOnError {
+				return err
+			}
+			return nil
+		}
+
+		result.Delivered++
+		log.Debug().Str("command", event.Command).Time("recorded", event.Time).Msg("Replayed event")
+		return nil
+	})
+
+	return result, err
+}
+
+func (o Options) accepts(event eventcapture.Event) bool {
+	if len(o.Commands) > 0 && !contains(o.Commands, event.Command) {
+		return false
+	}
+	if contains(o.ExcludeCommands, event.Command) {
+		return false
+	}
+
+	if len(o.WizardIds) > 0 {
+		wizardId, ok := requestWizardId(event.Request)
+		return ok && contains(o.WizardIds, wizardId)
+	}
+
+	return true
+}
+
+// wait sleeps for the time between the previous and the current event, scaled by the speed
+func (o Options) wait(ctx context.Context, previous, current time.Time) error {
+	if o.Speed <= 0 || previous.IsZero() {
+		return ctx.Err()
+	}
+
+	pause := time.Duration(float64(current.Sub(previous)) / o.Speed)
+	if o.MaxPause > 0 && pause > o.MaxPause {
+		pause = o.MaxPause
+	}
+	if pause <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(pause)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// requestWizardId returns the wizard_id of a request without losing precision of large ids
+func requestWizardId(request string) (string, bool) {
+	content := gamemodel.Request{}
+	if err := json.Unmarshal([]byte(request), &content); err != nil || content.WizardId == "" {
+		return "", false
+	}
+
+	return content.WizardId.String(), true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/swarpf/plugins/pkg/eventcapture"
+)
+
+func TestReplayFilters(t *testing.T) {
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := writeCapture(t, []eventcapture.Event{
+		{Time: start, Command: "HubUserLogin", Request: `{"command":"HubUserLogin","wizard_id":1}`},
+		{Time: start, Command: "GetGuildSiegeMatchupInfo", Request: `{"command":"GetGuildSiegeMatchupInfo","wizard_id":2}`},
+		// 2^53 + 1 can not be represented as float64, it would be read as 2^53
+		{Time: start, Command: "HubUserLogin", Request: `{"command":"HubUserLogin","wizard_id":9007199254740993}`},
+		{Time: start, Command: "HubUserLogin", Request: `{"command":"HubUserLogin","wizard_id":9007199254740992}`},
+		{Time: start, Command: "GetNoticeChat", Request: `{"command":"GetNoticeChat"}`},
+		{Time: start, Command: "BattleDungeonResult", Request: `not json`},
+	})
+
+	tests := []struct {
+		name    string
+		options Options
+		// want are the wizard ids or, for events without wizard id, the commands of the delivered events
+		want []string
+	}{
+		{
+			name:    "all",
+			options: Options{},
+			want:    []string{"1", "2", "9007199254740993", "9007199254740992", "GetNoticeChat", "BattleDungeonResult"},
+		},
+		{
+			name:    "commands",
+			options: Options{Commands: []string{"HubUserLogin", "GetNoticeChat"}},
+			want:    []string{"1", "9007199254740993", "9007199254740992", "GetNoticeChat"},
+		},
+		{
+			name:    "excluded commands",
+			options: Options{ExcludeCommands: []string{"HubUserLogin", "BattleDungeonResult"}},
+			want:    []string{"2", "GetNoticeChat"},
+		},
+		{
+			name:    "commands and excluded commands",
+			options: Options{Commands: []string{"HubUserLogin", "GetNoticeChat"}, ExcludeCommands: []string{"HubUserLogin"}},
+			want:    []string{"GetNoticeChat"},
+		},
+		{
+			name:    "wizard ids",
+			options: Options{WizardIds: []string{"2", "9007199254740993"}},
+			want:    []string{"2", "9007199254740993"},
+		},
+		{
+			name:    "wizard ids and commands",
+			options: Options{WizardIds: []string{"1", "2"}, Commands: []string{"HubUserLogin"}},
+			want:    []string{"1"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			var delivered []string
+			target := func(_ context.Context, event eventcapture.Event) error {
+				if wizardId, ok := requestWizardId(event.Request); ok {
+					delivered = append(delivered, wizardId)
+				} else {
+					delivered = append(delivered, event.Command)
+				}
+				return nil
+			}
+
+			result, err := Replay(context.Background(), files, target, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(delivered, test.want) {
+				t.Errorf("delivered %v, want %v", delivered, test.want)
+			}
+			want := Result{Delivered: len(test.want), Skipped: 6 - len(test.want)}
+			if result != want {
+				t.Errorf("Replay() = %+v, want %+v", result, want)
+			}
+		})
+	}
+}
+
+func TestReplayStopOnError(t *testing.T) {
+	files := writeCapture(t, []eventcapture.Event{
+		{Command: "HubUserLogin"},
+		{Command: "GetGuildSiegeMatchupInfo"},
+		{Command: "HubUserLogin"},
+	})
+	targetErr := errors.New("target failed")
+
+	tests := []struct {
+		stopOnError bool
+		want        Result
+		wantErr     error
+	}{
+		{false, Result{Delivered: 2, Failed: 1}, nil},
+		{true, Result{Delivered: 1, Failed: 1}, targetErr},
+	}
+
+	for _, test := range tests {
+		var delivered int
+		target := func(_ context.Context, event eventcapture.Event) error {
+			delivered++
+			if event.Command == "GetGuildSiegeMatchupInfo" {
+				return targetErr
+			}
+			return nil
+		}
+
+		result, err := Replay(context.Background(), files, target, Options{StopOnError: test.stopOnError})
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("StopOnError %v: Replay() error = %v, want %v", test.stopOnError, err, test.wantErr)
+		}
+		if result != test.want {
+			t.Errorf("StopOnError %v: Replay() = %+v, want %+v", test.stopOnError, result, test.want)
+		}
+		if delivered != test.want.Delivered+test.want.Failed {
+			t.Errorf("StopOnError %v: target called %d times, want %d", test.stopOnError, delivered,
+				test.want.Delivered+test.want.Failed)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		options  Options
+		previous time.Time
+		current  time.Time
+		// want is the expected pause, the tolerance is half of it plus scheduling delays
+		want time.Duration
+	}{
+		{"as fast as possible", Options{}, start, start.Add(time.Hour), 0},
+		{"first event", Options{Speed: 1}, time.Time{}, start, 0},
+		{"real time", Options{Speed: 1}, start, start.Add(100 * time.Millisecond), 100 * time.Millisecond},
+		{"faster", Options{Speed: 4}, start, start.Add(400 * time.Millisecond), 100 * time.Millisecond},
+		{"slower", Options{Speed: 0.5}, start, start.Add(50 * time.Millisecond), 100 * time.Millisecond},
+		{"capped", Options{Speed: 1, MaxPause: 100 * time.Millisecond}, start, start.Add(time.Hour), 100 * time.Millisecond},
+		{"below the cap", Options{Speed: 1, MaxPause: time.Hour}, start, start.Add(100 * time.Millisecond), 100 * time.Millisecond},
+		{"out of order", Options{Speed: 1}, start, start.Add(-time.Hour), 0},
+	}
+
+	for _, test := range tests {
+		began := time.Now()
+		if err := test.options.wait(context.Background(), test.previous, test.current); err != nil {
+			t.Errorf("%s: wait() error = %v", test.name, err)
+			continue
+		}
+
+		if elapsed := time.Since(began); elapsed < test.want || elapsed > test.want+test.want/2+50*time.Millisecond {
+			t.Errorf("%s: waited %v, want %v", test.name, elapsed, test.want)
+		}
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	began := time.Now()
+	err := Options{Speed: 1}.wait(ctx, start, start.Add(time.Hour))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("wait() returned after %v, want right after the cancellation", elapsed)
+	}
+}
+
+func TestPluginTarget(t *testing.T) {
+	events := []eventcapture.Event{
+		{Command: "HubUserLogin"},
+		{Command: "GetGuildSiegeMatchupInfo"},
+		{Command: "GetNoticeChat"},
+	}
+
+	tests := []struct {
+		commands []string
+		want     []string
+	}{
+		{[]string{"HubUserLogin", "GetNoticeChat"}, []string{"HubUserLogin", "GetNoticeChat"}},
+		{[]string{"*"}, []string{"HubUserLogin", "GetGuildSiegeMatchupInfo", "GetNoticeChat"}},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		plugin := &recorder{commands: test.commands}
+		target := PluginTarget(plugin)
+
+		for _, event := range events {
+			if err := target(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !reflect.DeepEqual(plugin.received, test.want) {
+			t.Errorf("subscribed to %v: plugin received %v, want %v", test.commands, plugin.received, test.want)
+		}
+	}
+}
+
+// recorder is a plugin recording the commands it received
+type recorder struct {
+	commands []string
+
+	mu       sync.Mutex
+	received []string
+}
+
+func (r *recorder) Name() string                   { return "recorder" }
+func (r *recorder) DisplayName() string            { return "Recorder" }
+func (r *recorder) DefaultPort() int               { return 0 }
+func (r *recorder) RegisterFlags(_ *pflag.FlagSet) {}
+func (r *recorder) Configure(_ *viper.Viper) error { return nil }
+func (r *recorder) SubscribedCommands() []string   { return r.commands }
+
+func (r *recorder) OnReceiveApiEvent(command, _, _ string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.received = append(r.received, command)
+	return nil
+}
+
+// writeCapture writes the events to a capture file and returns it
+func writeCapture(t *testing.T, events []eventcapture.Event) []string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	var content []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(append(content, line...), '\n')
+	}
+
+	path := filepath.Join(directory, "events.jsonl")
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return []string{path}
+}
@@ -0,0 +1,56 @@
+package replay
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/swarpf/plugins/internal/proxyapiutil"
+	"github.com/swarpf/plugins/pkg/eventcapture"
+	"github.com/swarpf/plugins/pkg/pluginruntime"
+	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+)
+
+// Target handles a replayed event.
+type Target func(ctx context.Context, event eventcapture.Event) error
+
+// GrpcTarget sends the events to the proxy api consumer of a running plugin, like the proxy does. A non-empty shared
+// secret is sent with every event.
+func GrpcTarget(conn *grpc.ClientConn, sharedSecret string) Target {
+	client := pb.NewProxyApiConsumerClient(conn)
+
+	return func(ctx context.Context, event eventcapture.Event) error {
+		if sharedSecret != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, proxyapiutil.SecretMetadataKey, sharedSecret)
+		}
+
+		_, err := client.OnReceiveApiEvent(ctx, &pb.ApiEvent{
+			Command:  event.Command,
+			Request:  event.Request,
+			Response: event.Response,
+		}, grpc.WaitForReady(true))
+		return err
+	}
+}
+
+// PluginTarget hands the events to a configured plugin in the same process. Like the proxy, it only delivers the
+// commands the plugin subscribed to.
+func PluginTarget(plugin pluginruntime.Plugin) Target {
+	return func(_ context.Context, event eventcapture.Event) error {
+		if !subscribed(plugin, event.Command) {
+			return nil
+		}
+
+		return plugin.OnReceiveApiEvent(event.Command, event.Request, event.Response)
+	}
+}
+
+func subscribed(plugin pluginruntime.Plugin, command string) bool {
+	for _, c := range plugin.SubscribedCommands() {
+		if c == "*" || c == command {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,39 @@
 package siegeexport
 
 import (
-	"context"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
-	"github.com/golang/protobuf/ptypes/empty"
-
-	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+	"github.com/swarpf/plugins/internal/configcheck"
 )
 
-type ProxyApiConsumer struct {
-	pb.UnimplementedProxyApiConsumerServer
+// Plugin implements pluginruntime.Plugin for the siege export plugin
+type Plugin struct{}
+
+func (p *Plugin) Name() string        { return "siegeexport" }
+func (p *Plugin) DisplayName() string { return "Siege Exporter" }
+func (p *Plugin) DefaultPort() int    { return 11105 }
+
+func (p *Plugin) RegisterFlags(flags *pflag.FlagSet) {
+	flags.String("output_directory", "./export", "Output directory for the profile files")
+}
+
+func (p *Plugin) Configure(config *viper.Viper) error {
+	// Setting profile export directory. Important: This can fail and abort the plugin!
+	SetOutputDirectory(config.GetString("output_directory"))
+	return nil
 }
 
-func (s *ProxyApiConsumer) OnReceiveApiEvent(_ context.Context, ev *pb.ApiEvent) (*empty.Empty, error) {
-	return &empty.Empty{}, OnReceiveApiEvent(ev.GetCommand(), ev.GetRequest(), ev.GetResponse())
+func (p *Plugin) ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	errs.Add("output_directory", configcheck.WritableDirectory(config.GetString("output_directory")))
+	return errs
+}
+
+func (p *Plugin) CheckReady() error { return CheckOutputDirectory() }
+
+func (p *Plugin) SubscribedCommands() []string { return SubscribedCommands() }
+
+func (p *Plugin) OnReceiveApiEvent(command, request, response string) error {
+	return OnReceiveApiEvent(command, request, response)
 }
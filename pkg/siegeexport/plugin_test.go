@@ -0,0 +1,180 @@
+package siegeexport_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+	"github.com/swarpf/plugins/pkg/siegeexport"
+)
+
+func TestPlugin(t *testing.T) {
+	directory := tempDir(t)
+	proxy, _ := proxyfake.StartTest(t, &siegeexport.Plugin{}, map[string]interface{}{
+		"output_directory": directory,
+	})
+
+	const battleLog = `{"ret_code": 0, "log_list": [{"guild_info_list": [{"match_id": 55}]}]}`
+
+	// the events build up one export, so the order of the cases matters
+	tests := []struct {
+		name     string
+		command  string
+		request  string
+		response string
+		code     codes.Code
+		file     string
+		keys     []string
+	}{
+		{
+			name:     "matchup info",
+			command:  "GetGuildSiegeMatchupInfo",
+			request:  `{"wizard_id": 123}`,
+			response: `{"ret_code": 0, "match_info": {"match_id": 55}}`,
+			file:     "SiegeMatch-55.json",
+			keys:     []string{"wizard_id", "matchup_info"},
+		},
+		{
+			name:     "attack log",
+			command:  "GetGuildSiegeBattleLog",
+			request:  `{"wizard_id": 123, "log_type": 1}`,
+			response: battleLog,
+			file:     "SiegeMatch-55.json",
+			keys:     []string{"wizard_id", "matchup_info", "attack_log"},
+		},
+		{
+			name:     "defense log",
+			command:  "GetGuildSiegeBattleLog",
+			request:  `{"wizard_id": 123, "log_type": 2}`,
+			response: battleLog,
+			file:     "SiegeMatch-55.json",
+			keys:     []string{"wizard_id", "matchup_info", "attack_log", "defense_log"},
+		},
+		{
+			name:     "headquarter defense list",
+			command:  "GetGuildSiegeBaseDefenseUnitListPreset",
+			request:  `{"wizard_id": 123, "base_number": 14}`,
+			response: `{"ret_code": 0, "defense_unit_list": []}`,
+			file:     "SiegeDefenseList.json",
+			keys:     []string{"wizard_id", "matchup_info", "attack_log", "defense_log", "defense_list"},
+		},
+		{
+			name:     "other defense list",
+			command:  "GetGuildSiegeBaseDefenseUnitList",
+			request:  `{"wizard_id": 123, "base_number": 5}`,
+			response: `{"ret_code": 0, "defense_unit_list": []}`,
+		},
+		{
+			name:     "invalid request",
+			command:  "GetGuildSiegeMatchupInfo",
+			request:  "{",
+			response: `{}`,
+			code:     codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := proxy.Send(context.Background(), tt.command, tt.request, tt.response)
+			if status.Code(err) != tt.code {
+				t.Fatalf("Send() error = %v, want code %s", err, tt.code)
+			}
+			if tt.file == "" {
+				return
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(directory, tt.file))
+			if err != nil {
+				t.Fatalf("siege match was not exported: %v", err)
+			}
+
+			exported := map[string]interface{}{}
+			if err := json.Unmarshal(content, &exported); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range tt.keys {
+				if _, ok := exported[key]; !ok {
+					t.Errorf("%s is missing %s", tt.file, key)
+				}
+			}
+			if len(exported) != len(tt.keys) {
+				t.Errorf("%s has keys %v, want %v", tt.file, keys(exported), tt.keys)
+			}
+		})
+	}
+
+	// the defense list of a base other than a headquarter is not exported
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("exported %d files, want SiegeMatch-55.json and SiegeDefenseList.json", len(files))
+	}
+}
+
+// TestGolden exports a siege match from the fixture corpus and compares the files with testdata/golden.
+func TestGolden(t *testing.T) {
+	directory := tempDir(t)
+	proxy, _ := proxyfake.StartTest(t, &siegeexport.Plugin{}, map[string]interface{}{
+		"output_directory": directory,
+	})
+
+	// the plugin keeps the parts of the export from earlier events, so the siege tab is opened twice: the files
+	// written the second time only contain parts of this test
+	for _, name := range []string{
+		"GetGuildSiegeMatchupInfo",
+		"GetGuildSiegeBattleLog-attack",
+		"GetGuildSiegeBattleLog-defense",
+		"GetGuildSiegeBaseDefenseUnitListPreset",
+		"GetGuildSiegeBaseDefenseUnitList",
+		"GetGuildSiegeMatchupInfo",
+	} {
+		f := fixtures.MustLoad(t, name)
+		if err := proxy.Send(context.Background(), f.Command, f.RequestString(), f.ResponseString()); err != nil {
+			t.Fatalf("Send(%s) error = %v", name, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("exported %d files, want a siege match and a defense list", len(files))
+	}
+
+	for _, fi := range files {
+		content, err := ioutil.ReadFile(filepath.Join(directory, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures.CheckGolden(t, filepath.Join("testdata", "golden", fi.Name()), content)
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "siegeexport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(directory) })
+
+	return directory
+}
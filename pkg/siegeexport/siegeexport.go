@@ -8,6 +8,9 @@ import (
 	"os"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/pkg/gamemodel"
 )
 
 var outputDirectory string
@@ -63,28 +66,33 @@ func SetOutputDirectory(directory string) {
 	outputDirectory = directory
 }
 
+// CheckOutputDirectory makes sure that files can be written to the output directory.
+func CheckOutputDirectory() error {
+	f, err := ioutil.TempFile(GetOutputDirectory(), ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("output directory is not writable: %w", err)
+	}
+
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
 func OnReceiveApiEvent(command, request, response string) error {
 	if !isSubscribedCommand(command) {
 		return nil
 	}
 
-	requestContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(request), &requestContent); err != nil {
+	gameRequest := gamemodel.Request{}
+	if err := json.Unmarshal([]byte(request), &gameRequest); err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie siege export request")
 		return errors.New("error while deserializing siege export request")
 	}
 
-	responseContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(response), &responseContent); err != nil {
-		log.Error().Err(err).Msg("Failed to deserializie siege export response")
-		return errors.New("error while deserializing siege export response")
-	}
-
-	wizardId := int64(requestContent["wizard_id"].(float64))
+	wizardId := gameRequest.WizardId
 
 	localLogger := log.With().
 		Str("command", command).
-		Int64("wizardId", wizardId).
+		Str("wizardId", wizardId.String()).
 		Logger()
 
 	localLogger.Info().Msg("Received command used in siege export")
@@ -95,33 +103,49 @@ func OnReceiveApiEvent(command, request, response string) error {
 
 	switch command {
 	case "GetGuildSiegeMatchupInfo":
-		retCode := int64(responseContent["ret_code"].(float64))
-		if retCode == 0 {
+		matchupInfo := gamemodel.SiegeMatchupInfo{}
+		if err := unmarshalResponse(response, &matchupInfo); err != nil {
+			return err
+		}
+
+		if matchupInfo.RetCode == 0 {
+			matchId := matchupInfo.MatchInfo.MatchId
+			if matchId == "" {
+				localLogger.Error().Msg("Siege matchup info has no match id")
+				return errors.New("siege matchup info without match id")
+			}
+
 			exportData["wizard_id"] = wizardId
-			exportData["matchup_info"] = responseContent
-			matchId := int64(responseContent["match_info"].(map[string]interface{})["match_id"].(float64))
-			if err := writeSiegeMatchToFile(&matchId, exportData); err != nil {
+			exportData["matchup_info"] = matchupInfo
+			if err := writeSiegeMatchToFile(matchId, exportData); err != nil {
 				return err
 			}
 		}
 	case "GetGuildSiegeBattleLog":
-		logType := int64(requestContent["log_type"].(float64))
+		battleLog := gamemodel.SiegeBattleLog{}
+		if err := unmarshalResponse(response, &battleLog); err != nil {
+			return err
+		}
 
-		logList := responseContent["log_list"].([]interface{})[0].(map[string]interface{})
-		guildInfoList := logList["guild_info_list"].([]interface{})[0].(map[string]interface{})
-		matchId := int64(guildInfoList["match_id"].(float64))
+		logType := gameRequest.LogType
+		matchId := battleLog.MatchId()
 
-		localLogger := localLogger.With().Int64("logType", logType).Interface("matchId", matchId).Logger()
+		localLogger := localLogger.With().Int("logType", logType).Str("matchId", matchId.String()).Logger()
+
+		if matchId == "" {
+			localLogger.Error().Msg("Siege battle log has no match id")
+			return errors.New("siege battle log without match id")
+		}
 
 		if logType == 1 {
-			exportData["attack_log"] = responseContent
+			exportData["attack_log"] = battleLog
 			localLogger.Info().Msg("Writing attack log to file")
 		} else {
-			exportData["defense_log"] = responseContent
+			exportData["defense_log"] = battleLog
 			localLogger.Info().Msg("Writing defense log to file")
 		}
 
-		if err := writeSiegeMatchToFile(&matchId, exportData); err != nil {
+		if err := writeSiegeMatchToFile(matchId, exportData); err != nil {
 			return err
 		}
 	case "GetGuildSiegeBaseDefenseUnitList", "GetGuildSiegeBaseDefenseUnitListPreset":
@@ -131,13 +155,20 @@ func OnReceiveApiEvent(command, request, response string) error {
 			yellowHqId = 27
 		)
 
-		baseNumber := int64(requestContent["base_number"].(float64))
+		defenseList := gamemodel.SiegeDefenseUnitList{}
+		if err := unmarshalResponse(response, &defenseList); err != nil {
+			return err
+		}
+
+		baseNumber := gameRequest.BaseNumber
 		if baseNumber == redHqId || baseNumber == blueHqId || baseNumber == yellowHqId {
-			exportData["defense_list"] = responseContent
-			exportData["defense_list"].(map[string]interface{})["hq_base_number"] = requestContent["base_number"]
+			if err := defenseList.Set("hq_base_number", baseNumber); err != nil {
+				return err
+			}
+			exportData["defense_list"] = defenseList
 
 			localLogger.Info().Msg("Writing defense list to file")
-			if err := writeSiegeMatchToFile(nil, exportData); err != nil {
+			if err := writeSiegeMatchToFile("", exportData); err != nil {
 				return err
 			}
 		}
@@ -148,20 +179,29 @@ func OnReceiveApiEvent(command, request, response string) error {
 	return nil
 }
 
-func writeSiegeMatchToFile(matchId *int64, data map[string]interface{}) error {
+func unmarshalResponse(response string, v interface{}) error {
+	if err := json.Unmarshal([]byte(response), v); err != nil {
+		log.Error().Err(err).Msg("Failed to deserializie siege export response")
+		return errors.New("error while deserializing siege export response")
+	}
+	return nil
+}
+
+// writeSiegeMatchToFile writes the data of a match, or the defense list if matchId is empty
+func writeSiegeMatchToFile(matchId json.Number, data map[string]interface{}) error {
 	// serialize sorted data back to json
 	jsonBytes, err := json.Marshal(data)
 	if err != nil {
 		log.Error().Err(err).
-			Interface("matchId", matchId).
+			Str("matchId", matchId.String()).
 			Msg("Something went wrong while re-serializing the API response.")
 		return errors.New("serialization failed - sorted data is corrupt")
 	}
 
 	// generate file name to write to
 	var fileName string
-	if matchId != nil {
-		fileName = fmt.Sprintf("SiegeMatch-%d.json", *matchId)
+	if matchId != "" {
+		fileName = fmt.Sprintf("SiegeMatch-%s.json", matchId)
 	} else {
 		fileName = "SiegeDefenseList.json"
 	}
@@ -169,16 +209,17 @@ func writeSiegeMatchToFile(matchId *int64, data map[string]interface{}) error {
 	// write match data to profile file
 	filePath := fmt.Sprintf("%s/%s", GetOutputDirectory(), fileName)
 	err = ioutil.WriteFile(filePath, jsonBytes, 0664)
+	metrics.FileWritten("siegeexport", err)
 	if err != nil {
 		log.Error().Err(err).
-			Interface("matchId", matchId).
+			Str("matchId", matchId.String()).
 			Str("filePath", filePath).
 			Msg("Could not write profile JSON to file")
 		return fmt.Errorf("failed to write profile to file, error: %v", err.Error())
 	}
 
 	log.Info().
-		Interface("matchId", matchId).
+		Str("matchId", matchId.String()).
 		Str("filePath", filePath).
 		Msgf("Siege data successfully written to %s", filePath)
 
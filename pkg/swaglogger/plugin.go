@@ -1,18 +1,81 @@
 package swaglogger
 
 import (
-	"context"
+	"errors"
+	"time"
 
-	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
-	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/internal/httpclient"
+	"github.com/swarpf/plugins/internal/workqueue"
 )
 
-// proxy API consumer
-type ProxyApiConsumer struct {
-	pb.UnimplementedProxyApiConsumerServer
+// Plugin implements pluginruntime.Plugin for the SWAG logger plugin
+type Plugin struct {
+	drainTimeout time.Duration
 }
 
-func (s *ProxyApiConsumer) OnReceiveApiEvent(_ context.Context, ev *pb.ApiEvent) (*empty.Empty, error) {
-	return &empty.Empty{}, OnReceiveApiEvent(ev.GetCommand(), ev.GetRequest(), ev.GetResponse())
+func (p *Plugin) Name() string        { return "swaglogger" }
+func (p *Plugin) DisplayName() string { return "SwagLogger" }
+func (p *Plugin) DefaultPort() int    { return 11104 }
+
+func (p *Plugin) RegisterFlags(flags *pflag.FlagSet) {
+	flags.String("swag_url", DefaultUploadUrl, "URL the guild war logs are uploaded to")
+	flags.Int("upload_workers", 1, "Number of workers uploading to SWAG. 0 uploads synchronously")
+	flags.Int("upload_queue_size", 20, "Maximum number of api events waiting for upload")
+	flags.String("upload_queue_policy", string(workqueue.Block), "What to do if the upload queue is full: block or drop-oldest")
+	flags.Duration("upload_drain_timeout", 30*time.Second, "Time to wait for queued uploads on shutdown")
+	httpclient.RegisterFlags(flags, "SWAG", DefaultHttpOptions())
+}
+
+func (p *Plugin) Configure(config *viper.Viper) error {
+	SetUploadUrl(config.GetString("swag_url"))
+	ConfigureHttpClient(httpclient.OptionsFromConfig(config))
+
+	if workers := config.GetInt("upload_workers"); workers > 0 {
+		policy, err := workqueue.ParsePolicy(config.GetString("upload_queue_policy"))
+		if err != nil {
+			return err
+		}
+
+		if policy == workqueue.Spill {
+			return errors.New("upload queue policy spill is not supported by the SWAG logger")
+		}
+
+		EnableUploadQueue(workqueue.Options{
+			Workers: workers,
+			Size:    config.GetInt("upload_queue_size"),
+			Policy:  policy,
+		})
+		p.drainTimeout = config.GetDuration("upload_drain_timeout")
+	}
+
+	return nil
+}
+
+func (p *Plugin) ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	errs.Add("swag_url", configcheck.HttpUrl(config.GetString("swag_url")))
+	for _, key := range []string{"upload_workers", "upload_queue_size"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetInt(key))))
+	}
+	errs.Add("upload_drain_timeout", configcheck.NotNegative(float64(config.GetDuration("upload_drain_timeout"))))
+
+	policy, err := workqueue.ParsePolicy(config.GetString("upload_queue_policy"))
+	errs.Add("upload_queue_policy", err)
+	if policy == workqueue.Spill {
+		errs.Addf("upload_queue_policy", "spill is not supported by the SWAG logger")
+	}
+
+	return append(errs, httpclient.ValidateConfig(config)...)
+}
+
+func (p *Plugin) Shutdown() { Shutdown(p.drainTimeout) }
+
+func (p *Plugin) SubscribedCommands() []string { return SubscribedCommands() }
+
+func (p *Plugin) OnReceiveApiEvent(command, request, response string) error {
+	return OnReceiveApiEvent(command, request, response)
 }
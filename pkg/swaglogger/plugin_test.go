@@ -0,0 +1,135 @@
+package swaglogger_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+	"github.com/swarpf/plugins/pkg/swaglogger"
+)
+
+// swagServer records the bodies of the uploads
+type swagServer struct {
+	mu      sync.Mutex
+	uploads []string
+}
+
+func (s *swagServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.uploads = append(s.uploads, string(body))
+	s.mu.Unlock()
+}
+
+func (s *swagServer) Uploads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.uploads...)
+}
+
+func TestPlugin(t *testing.T) {
+	swag := &swagServer{}
+	server := httptest.NewServer(swag)
+	t.Cleanup(server.Close)
+
+	proxy, _ := proxyfake.StartTest(t, &swaglogger.Plugin{}, map[string]interface{}{
+		"swag_url":       server.URL + "/data/upload/",
+		"upload_workers": 0,
+	})
+
+	const battleLog = `{"ret_code":0,"log_type":1,"battle_log_list":[]}`
+
+	tests := []struct {
+		name     string
+		command  string
+		request  string
+		response string
+		code     codes.Code
+		uploaded bool
+	}{
+		{"by wizard id", "GetGuildWarBattleLogByWizardId", `{"wizard_id": 123}`, battleLog, codes.OK, true},
+		{"by guild id", "GetGuildWarBattleLogByGuildId", `{"wizard_id": 123}`, battleLog, codes.OK, true},
+		{"invalid request", "GetGuildWarBattleLogByWizardId", "{", battleLog, codes.Internal, false},
+		{"invalid response", "GetGuildWarBattleLogByGuildId", `{"wizard_id": 123}`, "{", codes.Internal, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(swag.Uploads())
+
+			err := proxy.Send(context.Background(), tt.command, tt.request, tt.response)
+			if status.Code(err) != tt.code {
+				t.Fatalf("Send() error = %v, want code %s", err, tt.code)
+			}
+
+			uploads := swag.Uploads()
+			if !tt.uploaded {
+				if len(uploads) != before {
+					t.Fatalf("event was uploaded to SWAG")
+				}
+				return
+			}
+
+			if len(uploads) != before+1 {
+				t.Fatalf("got %d uploads, want %d", len(uploads), before+1)
+			}
+			if uploads[before] != tt.response {
+				t.Errorf("uploaded %s, want the response %s", uploads[before], tt.response)
+			}
+		})
+	}
+
+	if err := proxy.Send(context.Background(), "HubUserLogin", "{}", "{}"); err != proxyfake.ErrNoConsumer {
+		t.Errorf("Send(HubUserLogin) error = %v, want %v", err, proxyfake.ErrNoConsumer)
+	}
+}
+
+// TestGolden uploads the guild war logs of the fixture corpus and compares the uploads with testdata/golden.
+func TestGolden(t *testing.T) {
+	swag := &swagServer{}
+	server := httptest.NewServer(swag)
+	t.Cleanup(server.Close)
+
+	proxy, _ := proxyfake.StartTest(t, &swaglogger.Plugin{}, map[string]interface{}{
+		"swag_url":       server.URL + "/data/upload/",
+		"upload_workers": 0,
+	})
+
+	for _, command := range swaglogger.SubscribedCommands() {
+		list, err := fixtures.List(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 {
+			t.Errorf("the corpus has no fixture for %s", command)
+		}
+
+		for _, f := range list {
+			t.Run(f.Name, func(t *testing.T) {
+				before := len(swag.Uploads())
+
+				err := proxy.Send(context.Background(), f.Command, f.RequestString(), f.ResponseString())
+				if err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+
+				uploads := swag.Uploads()
+				if len(uploads) != before+1 {
+					t.Fatalf("got %d uploads, want %d", len(uploads), before+1)
+				}
+				fixtures.CheckGolden(t, filepath.Join("testdata", "golden", f.Name+".json"), []byte(uploads[before]))
+			})
+		}
+	}
+}
@@ -4,11 +4,55 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/httpclient"
+	"github.com/swarpf/plugins/internal/workqueue"
+	"github.com/swarpf/plugins/pkg/gamemodel"
 )
 
+const DefaultUploadUrl = "https://gw.swop.one/data/upload/"
+
+var uploadUrl = DefaultUploadUrl
+
+// SetUploadUrl changes the URL the guild war logs are posted to, e.g. to a staging server or a fake.
+func SetUploadUrl(url string) {
+	uploadUrl = url
+}
+
+// httpClient is shared by all uploads to SWAG
+var httpClient = httpclient.New("swag", DefaultHttpOptions())
+
+// DefaultHttpOptions limits the uploads to SWAG more than the generic defaults, since guild war logs are rare.
+func DefaultHttpOptions() httpclient.Options {
+	options := httpclient.DefaultOptions()
+	options.RateLimit = 1
+	options.Burst = 5
+	return options
+}
+
+// ConfigureHttpClient replaces the client used for all uploads to SWAG.
+func ConfigureHttpClient(options httpclient.Options) {
+	httpClient = httpclient.New("swag", options)
+}
+
+// uploadQueue is nil as long as uploads are done synchronously
+var uploadQueue *workqueue.Queue
+
+// EnableUploadQueue makes OnReceiveApiEvent hand the uploads to a queue instead of uploading them right away.
+func EnableUploadQueue(options workqueue.Options) {
+	uploadQueue = workqueue.New("swag", options)
+}
+
+// Shutdown waits up to timeout for the queued uploads to finish.
+func Shutdown(timeout time.Duration) {
+	if uploadQueue != nil {
+		uploadQueue.Close(timeout)
+	}
+}
+
 func SubscribedCommands() []string {
 	return []string{"GetGuildWarBattleLogByWizardId", "GetGuildWarBattleLogByGuildId"}
 }
@@ -27,52 +71,65 @@ func OnReceiveApiEvent(command, request, response string) error {
 		return nil
 	}
 
-	requestContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(request), &requestContent); err != nil {
+	gameRequest := gamemodel.Request{}
+	if err := json.Unmarshal([]byte(request), &gameRequest); err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie SWAG request")
 		return errors.New("error while deserializing SWAG request")
 	}
 
-	responseContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(response), &responseContent); err != nil {
+	// the response is uploaded as received, it is only decoded to make sure it is a battle log
+	battleLog := gamemodel.GuildWarBattleLog{}
+	if err := json.Unmarshal([]byte(response), &battleLog); err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie SWAG response")
 		return errors.New("error while deserializing SWAG response")
 	}
 
-	wizardId := requestContent["wizard_id"].(float64)
+	wizardId := gameRequest.WizardId
 
+	if uploadQueue == nil {
+		uploadToSwag(command, wizardId, response)
+		return nil
+	}
+
+	uploadQueue.Enqueue(workqueue.Job{
+		Key:  wizardId.String(),
+		Name: command,
+		Run:  func() { uploadToSwag(command, wizardId, response) },
+	})
+
+	return nil
+}
+
+func uploadToSwag(command string, wizardId json.Number, response string) {
 	log.Info().
 		Str("command", command).
-		Float64("wizard_id", wizardId).
+		Str("wizard_id", wizardId.String()).
 		Msg("Uploading guild war data to SWAG...")
 
-	client := resty.New()
-	resp, err := client.R().
+	resp, err := httpClient.R().
 		SetHeader("Content-Type", "application/json").
 		SetBody(response).
-		Post("https://gw.swop.one/data/upload/")
+		Post(uploadUrl)
 
 	if err != nil {
 		log.Error().Err(err).
 			Str("command", command).
-			Float64("wizardId", wizardId).
+			Str("wizardId", wizardId.String()).
 			Msg("SWAG upload failed")
-		return nil
+		return
 	}
 
 	if resp.StatusCode() != http.StatusOK {
 		log.Error().
 			Str("command", command).
-			Float64("wizardId", wizardId).
+			Str("wizardId", wizardId.String()).
 			Int("StatusCode", resp.StatusCode()).
 			Msgf("SWAG upload failed. Status %d", resp.StatusCode())
-		return nil
+		return
 	}
 
 	log.Info().
 		Str("command", command).
-		Float64("wizardId", wizardId).
+		Str("wizardId", wizardId.String()).
 		Msg("SWAG upload successful.")
-
-	return nil
 }
@@ -0,0 +1,99 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const redactedToken = "REDACTED"
+
+// captureDirectory receives the uploads instead of SWARFARM. Empty disables the dry-run mode.
+var (
+	captureMu        sync.RWMutex
+	captureDirectory string
+)
+
+var captureSequence uint64
+
+// CapturedUpload is the content of a file written in dry-run mode. It describes the request which would have been
+// sent to SWARFARM.
+type CapturedUpload struct {
+	Method   string            `json:"method"`
+	Endpoint string            `json:"endpoint"`
+	LogType  string            `json:"log_type"`
+	WizardId int64             `json:"wizard_id"`
+	Command  string            `json:"command"`
+	Headers  map[string]string `json:"headers"`
+	Captured time.Time         `json:"captured"`
+	Payload  json.RawMessage   `json:"payload"`
+}
+
+// EnableCapture turns on the dry-run mode. Instead of being sent to SWARFARM, every upload is written to a JSON file
+// in the directory.
+func EnableCapture(directory string) error {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return fmt.Errorf("failed to create dry-run directory: %w", err)
+	}
+
+	captureMu.Lock()
+	captureDirectory = directory
+	captureMu.Unlock()
+
+	log.Warn().Str("dryRunDirectory", directory).Msg("Dry-run mode enabled. Nothing is uploaded to SWARFARM")
+
+	return nil
+}
+
+func activeCaptureDirectory() string {
+	captureMu.RLock()
+	defer captureMu.RUnlock()
+
+	return captureDirectory
+}
+
+func captureUpload(directory, logType string, wizardId int64, command, apiToken string, jsonBytes []byte) error {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if apiToken != "" {
+		headers["Authorization"] = "Token " + redactedToken
+	}
+
+	upload := CapturedUpload{
+		Method:   "POST",
+		Endpoint: apiUrl + logTypeEndpoints[logType],
+		LogType:  logType,
+		WizardId: wizardId,
+		Command:  command,
+		Headers:  headers,
+		Captured: time.Now(),
+		Payload:  jsonBytes,
+	}
+
+	content, err := json.MarshalIndent(upload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize captured upload: %w", err)
+	}
+
+	fileName := fmt.Sprintf("%s-%06d-%d-%s-%s.json", upload.Captured.UTC().Format("20060102T150405"),
+		atomic.AddUint64(&captureSequence, 1), wizardId, logType, command)
+	path := filepath.Join(directory, fileName)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write captured upload: %w", err)
+	}
+
+	log.Info().
+		Str("swarfarmLogType", logType).
+		Str("command", command).
+		Int64("wizardId", wizardId).
+		Str("file", path).
+		Msgf("SWARFARM %s upload captured", logTypeDescriptions[logType])
+
+	return nil
+}
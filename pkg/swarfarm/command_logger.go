@@ -3,35 +3,40 @@ package swarfarm
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
-	"strings"
+	"sync"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
 )
 
 func UploadSwarfarmCommand(wizardId int64, command string, request, response map[string]interface{}) error {
-	if !DataLogEnabled {
+	if !dataLogEnabledFor(wizardId) {
 		return nil
 	}
 
-	apiToken, _ := FindToken(strconv.FormatInt(wizardId, 10))
-
-	inputMap := make(map[string]map[string]interface{})
-	inputMap["request"] = request
-	inputMap["response"] = response
-
 	log.Debug().
-		Str("swarfarmLogType", "data_log").
+		Str("swarfarmLogType", dataLogType).
 		Str("command", command).
 		Int64("wizardId", wizardId).
 		Msg("Uploading command data to SWARFARM")
 
+	jsonBytes, err := makeDataLogPayload(wizardId, command, request, response)
+	if err != nil {
+		return err
+	}
+
+	return uploadOrSpool(dataLogType, wizardId, command, jsonBytes)
+}
+
+func makeDataLogPayload(wizardId int64, command string, request, response map[string]interface{}) ([]byte, error) {
+	inputMap := make(map[string]map[string]interface{})
+	inputMap["request"] = request
+	inputMap["response"] = response
+
 	acceptedCommands := FetchAcceptedLoggerCommands()
 	cmdGroup := acceptedCommands[command]
+	checkDrift(dataLogType, command, cmdGroup, inputMap)
 	payload := makeUploadPayload(cmdGroup, inputMap)
 
 	// handle response fields
@@ -41,121 +46,51 @@ func UploadSwarfarmCommand(wizardId int64, command string, request, response map
 	jsonBytes, err := json.Marshal(swarfarmCommandContent)
 	if err != nil {
 		log.Error().Err(err).
-			Str("swarfarmLogType", "data_log").
+			Str("swarfarmLogType", dataLogType).
 			Str("command", command).
 			Int64("wizardId", wizardId).
 			Msg("Error on command serialization")
-		return errors.New("error while serializing a command")
-	}
-
-	rclient := makeAuthorizedClient(apiToken)
-	resp, err := rclient.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(jsonBytes).
-		Post(apiUrl + "/data_logs/")
-
-	if err != nil {
-		log.Error().Err(err).
-			Str("swarfarmLogType", "data_log").
-			Str("command", command).
-			Int64("wizardId", wizardId).
-			Msg("SWARFARM data log upload failed")
-		return errors.New("SWARFARM data log upload failed")
-	}
-
-	if resp.StatusCode() != http.StatusOK {
-		if resp.StatusCode() == http.StatusInternalServerError {
-			log.Error().
-				Str("swarfarmLogType", "data_log").
-				Str("command", command).
-				Int64("wizardId", wizardId).
-				Int("statusCode", resp.StatusCode()).
-				Str("syncJsonBytes", string(jsonBytes)).
-				Msg("A SWARFARM internal server error occured")
-			return errors.New("a SWARFARM internal server error occured")
-		}
-
-		response := map[string]interface{}{}
-		if err := json.Unmarshal(resp.Body(), &response); err != nil {
-			log.Error().Err(err).
-				Str("swarfarmLogType", "data_log").
-				Msg("Failed to deserializie SWARFARM response")
-			return errors.New("error while deserializing SWARFARM response")
-		}
-
-		detail, ok := response["detail"].(string)
-		if !ok {
-			detail = "no detail"
-		}
-
-		errlog := log.Error().
-			Str("swarfarmLogType", "data_log").
-			Str("command", command).
-			Int64("wizardId", wizardId).
-			Int("statusCode", resp.StatusCode()).
-			Str("detail", detail)
-
-		message := ""
-		if resp.StatusCode() == http.StatusUnauthorized {
-			message = fmt.Sprintf("SWARFARM data log upload failed - authentication error. detail: %s", detail)
-		} else {
-			message = fmt.Sprintf("SWARFARM data log upload failed - invalid status code. detail: %s", detail)
-			errlog.Str("request_json_bytes", string(jsonBytes))
-		}
-
-		errlog.Msg(message)
-		return errors.New(message)
+		return nil, errors.New("error while serializing a command")
 	}
 
-	log.Info().
-		Str("swarfarmLogType", "data_log").
-		Str("command", command).
-		Int64("wizardId", wizardId).
-		Msg("SWARFARM data log upload successful")
-
-	return nil
+	return jsonBytes, nil
 }
 
-var acceptedCommandCache map[string]map[string][]string
-
 func FetchAcceptedLoggerCommands() map[string]map[string][]string {
 	if !DataLogEnabled {
 		return make(map[string]map[string][]string)
 	}
 
-	if acceptedCommandCache != nil {
-		log.Debug().Msg("Using cached version of accepted log types")
-		return acceptedCommandCache
-	}
-
-	acceptedCommandCache = make(map[string]map[string][]string)
-	acceptedCommandCache = buildCacheFromUrl("data log commands", fmt.Sprintf("%s%s", apiUrl, "/data_logs"))
-
-	return acceptedCommandCache
+	return dataLogSchema.Get()
 }
 
 func UploadSwarfarmLiveSyncCommand(wizardId int64, command string, request, response map[string]interface{}) error {
-	if !LiveSyncEnabled {
+	if !liveSyncEnabledFor(wizardId) {
 		return nil
 	}
 
-	apiToken, _ := FindToken(strconv.FormatInt(wizardId, 10))
-	if apiToken == "" {
-		return nil
+	log.Debug().
+		Str("swarfarmLogType", liveSyncLogType).
+		Str("command", command).
+		Int64("wizardId", wizardId).
+		Msg("Uploading live sync data to SWARFARM")
+
+	jsonBytes, err := makeLiveSyncPayload(wizardId, command, request, response)
+	if err != nil {
+		return err
 	}
 
+	return uploadOrSpool(liveSyncLogType, wizardId, command, jsonBytes)
+}
+
+func makeLiveSyncPayload(wizardId int64, command string, request, response map[string]interface{}) ([]byte, error) {
 	inputMap := make(map[string]map[string]interface{})
 	inputMap["request"] = request
 	inputMap["response"] = response
 
-	log.Debug().
-		Str("swarfarmLogType", "profile_sync").
-		Str("command", command).
-		Int64("wizardId", wizardId).
-		Msg("Uploading live sync data to SWARFARM")
-
 	syncCommands := FetchSyncCommands()
 	cmdGroup := syncCommands[command]
+	checkDrift(liveSyncLogType, command, cmdGroup, inputMap)
 	payload := makeUploadPayload(cmdGroup, inputMap)
 
 	// NOTE: this is a workaround for HubUserLogin. it is needed because the server schema does not list the fields
@@ -173,162 +108,192 @@ func UploadSwarfarmLiveSyncCommand(wizardId int64, command string, request, resp
 	jsonBytes, err := json.Marshal(swarfarmSyncContent)
 	if err != nil {
 		log.Error().Err(err).
-			Str("swarfarmLogType", "profile_sync").
+			Str("swarfarmLogType", liveSyncLogType).
 			Str("command", command).
 			Int64("wizardId", wizardId).
 			Msg("Error on command serialization")
-		return errors.New("error while serializing a command")
+		return nil, errors.New("error while serializing a command")
+	}
+
+	return jsonBytes, nil
+}
+
+func FetchSyncCommands() map[string]map[string][]string {
+	if !LiveSyncEnabled {
+		return make(map[string]map[string][]string)
+	}
+
+	return liveSyncSchema.Get()
+}
+
+// uploadLocks serializes the uploads of every wizard and log type, so an upload can not overtake an earlier one which
+// failed and is about to be written to the spool. There is one lock per wizard and log type seen.
+var (
+	uploadLocksMu sync.Mutex
+	uploadLocks   = make(map[spoolKey]*sync.Mutex)
+)
+
+func lockUploads(logType string, wizardId int64) func() {
+	key := spoolKey{wizardId: wizardId, logType: logType}
+
+	uploadLocksMu.Lock()
+	lock, ok := uploadLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		uploadLocks[key] = lock
+	}
+	uploadLocksMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// uploadOrSpool uploads the payload to SWARFARM. If the spool is enabled, payloads which could not be uploaded
+// because of a temporary error are written to the spool and uploaded later. As long as the spool contains payloads
+// of a wizard and log type, new payloads of that wizard and log type are appended to the spool to keep their order.
+// Live sync payloads of a quarantined wizard are not sent at all, its data logs are sent without token.
+func uploadOrSpool(logType string, wizardId int64, command string, jsonBytes []byte) error {
+	defer lockUploads(logType, wizardId)()
+
+	spool := activeSpool()
+	if logType == liveSyncLogType && isQuarantined(wizardId) {
+		if spool != nil {
+			return spool.Add(logType, wizardId, command, jsonBytes)
+		}
+
+		log.Warn().Err(errQuarantined).
+			Str("command", command).
+			Int64("wizardId", wizardId).
+			Msg("Skipping SWARFARM live sync upload")
+		return errQuarantined
+	}
+
+	if spool != nil && spool.HasPending(logType, wizardId) {
+		return spool.Add(logType, wizardId, command, jsonBytes)
+	}
+
+	statusCode, err := uploadPayload(logType, wizardId, command, jsonBytes)
+	// keep the upload which got the wizard quarantined as well, a data log is repeated without token
+	quarantined := statusCode == http.StatusUnauthorized && isQuarantined(wizardId)
+	if err != nil && spool != nil && (isRetryable(statusCode) || quarantined) {
+		return spool.Add(logType, wizardId, command, jsonBytes)
+	}
+
+	return err
+}
+
+// uploadPayload sends an already serialized payload to the SWARFARM endpoint of the log type. It returns the
+// HTTP status code of the response or 0 if no response was received.
+func uploadPayload(logType string, wizardId int64, command string, jsonBytes []byte) (int, error) {
+	apiToken, _ := FindToken(strconv.FormatInt(wizardId, 10))
+	description := logTypeDescriptions[logType]
+	uploadErr := &UploadError{LogType: logType, WizardId: wizardId, Command: command}
+
+	// data logs do not need a token, so they are still uploaded anonymously while the token is rejected
+	if logType == dataLogType && isQuarantined(wizardId) {
+		apiToken = ""
+	}
+
+	if directory := activeCaptureDirectory(); directory != "" {
+		if err := captureUpload(directory, logType, wizardId, command, apiToken, jsonBytes); err != nil {
+			log.Error().Err(err).
+				Str("swarfarmLogType", logType).
+				Str("command", command).
+				Int64("wizardId", wizardId).
+				Msgf("SWARFARM %s capture failed", description)
+			return 0, err
+		}
+
+		return http.StatusOK, nil
 	}
 
-	rclient := makeAuthorizedClient(apiToken)
-	resp, err := rclient.R().
+	resp, err := makeAuthorizedRequest(apiToken).
 		SetHeader("Content-Type", "application/json").
 		SetBody(jsonBytes).
-		Post(apiUrl + "/profiles/sync/")
+		Post(apiUrl + logTypeEndpoints[logType])
 
 	if err != nil {
 		log.Error().Err(err).
-			Str("swarfarmLogType", "profile_sync").
+			Str("swarfarmLogType", logType).
 			Str("command", command).
 			Int64("wizardId", wizardId).
-			Msg("SWARFARM live sync upload failed")
-		return errors.New("SWARFARM live sync upload failed")
+			Msgf("SWARFARM %s upload failed", description)
+		uploadErr.Detail = err.Error()
+		return 0, uploadErr
 	}
 
 	if resp.StatusCode() != http.StatusOK {
+		uploadErr.StatusCode = resp.StatusCode()
+
 		if resp.StatusCode() == http.StatusInternalServerError {
 			log.Error().
-				Str("swarfarmLogType", "profile_sync").
+				Str("swarfarmLogType", logType).
 				Str("command", command).
 				Int64("wizardId", wizardId).
 				Int("statusCode", resp.StatusCode()).
 				Str("jsonBytes", string(jsonBytes)).
 				Msg("A SWARFARM internal server error occured")
-			return errors.New("a SWARFARM internal server error occured")
+			uploadErr.Detail = "internal server error"
+			return resp.StatusCode(), uploadErr
 		}
 
 		response := map[string]interface{}{}
 		if err := json.Unmarshal(resp.Body(), &response); err != nil {
-			log.Error().Err(err).Str("body", string(resp.Body())).
+			log.Error().Err(err).
+				Str("swarfarmLogType", logType).
+				Str("body", string(resp.Body())).
 				Msg("Failed to deserializie SWARFARM response")
-			return errors.New("error while deserializing SWARFARM response")
+			uploadErr.Detail = "error while deserializing SWARFARM response"
+			return resp.StatusCode(), uploadErr
 		}
 
 		detail, ok := response["detail"].(string)
 		if !ok {
 			detail = "no detail"
 		}
+		uploadErr.Detail = detail
 
 		errlog := log.Error().
-			Str("swarfarmLogType", "profile_sync").
+			Str("swarfarmLogType", logType).
 			Str("command", command).
 			Int64("wizardId", wizardId).
 			Int("statusCode", resp.StatusCode()).
 			Str("detail", detail)
 
-		message := ""
 		if resp.StatusCode() == http.StatusUnauthorized {
-			message = fmt.Sprintf("SWARFARM live sync upload failed - authentication error. detail: %s", detail)
+			if apiToken != "" {
+				quarantine(wizardId, apiToken, detail)
+			}
 		} else {
-			message = fmt.Sprintf("SWARFARM live sync upload failed - invalid status code. detail: %s", detail)
 			errlog.Str("request_json_bytes", string(jsonBytes))
 		}
 
-		errlog.Msg(message)
-		return errors.New(message)
+		errlog.Msg(uploadErr.Error())
+		return resp.StatusCode(), uploadErr
 	}
 
 	log.Info().
-		Str("swarfarmLogType", "profile_sync").
+		Str("swarfarmLogType", logType).
 		Str("command", command).
 		Int64("wizardId", wizardId).
-		Msg("SWARFARM live sync upload successful")
-
-	return nil
-}
-
-var syncCommandCache map[string]map[string][]string
-
-func FetchSyncCommands() map[string]map[string][]string {
-	if !LiveSyncEnabled {
-		return make(map[string]map[string][]string)
-	}
-
-	if syncCommandCache != nil {
-		log.Debug().Msg("Using cached version of live sync commands")
-		return syncCommandCache
-	}
-
-	syncCommandCache = make(map[string]map[string][]string)
-	syncCommandCache = buildCacheFromUrl("live sync commands", fmt.Sprintf("%s%s", apiUrl, "/profiles/accepted-commands/"))
+		Msgf("SWARFARM %s upload successful", description)
 
-	return syncCommandCache
+	return resp.StatusCode(), nil
 }
 
-func buildCacheFromUrl(cacheTag, url string) map[string]map[string][]string {
-	log.Debug().Msgf("Fetching %s from SWARFARM...", cacheTag)
-
-	commandCache := make(map[string]map[string][]string)
-
-	rclient := resty.New()
-	resp, err := rclient.R().Get(url)
-	if err != nil {
-		log.Error().Err(err).Msgf("Unable to retrieve %s. SWARFARM logging is disabled.", cacheTag)
-		return commandCache
-	}
-
-	if resp.StatusCode() == http.StatusNotFound {
-		log.Error().
-			Str("url", url).
-			Msgf("Unable to retrieve %s. Endpoint not found. SWARFARM logging is disabled.", cacheTag)
-		return commandCache
+// isRetryable reports whether an upload which ended with the status code may succeed when it is repeated. A status
+// code of 0 means that no response was received at all.
+func isRetryable(statusCode int) bool {
+	switch {
+	case statusCode == 0:
+		return true
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= 500:
+		return true
+	default:
+		return false
 	}
-
-	if resp.StatusCode() != http.StatusOK {
-		log.Error().
-			Int("status_code", resp.StatusCode()).
-			Str("status", resp.Status()).
-			Msgf("Unable to retrieve %s. Invalid status code. SWARFARM logging is disabled.", cacheTag)
-		return commandCache
-	}
-
-	content := map[string]interface{}{}
-	err = json.Unmarshal(resp.Body(), &content)
-	if err != nil {
-		log.Error().Err(err).Msgf("Error while deserializing SWARFARM %s.", cacheTag)
-		return commandCache
-	}
-
-	for k, v := range content {
-		// skip non-commands
-		if strings.HasPrefix(k, "__") {
-			continue
-		}
-
-		contentCmd := v.(map[string]interface{})
-
-		cmd := make(map[string][]string, 1)
-		for cmdDirection, validValues := range contentCmd {
-			for _, validValue := range validValues.([]interface{}) {
-				cmd[cmdDirection] = append(cmd[cmdDirection], validValue.(string))
-			}
-		}
-
-		commandCache[k] = cmd
-	}
-
-	keys := make([]string, 0, len(commandCache))
-	for k := range commandCache {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	log.Info().
-		Str("cache_tag", cacheTag).
-		Strs("commands", keys).
-		Msgf("Successfully retrieved %s from SWARFARM.", cacheTag)
-
-	return commandCache
 }
 
 func makeUploadPayload(cmdGroup map[string][]string, inputMap map[string]map[string]interface{}) map[string]map[string]interface{} {
@@ -2,21 +2,55 @@ package swarfarm
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/go-resty/resty/v2"
+
+	"github.com/swarpf/plugins/internal/httpclient"
 )
 
+const DefaultBaseUrl = "https://swarfarm.com"
+
+var apiUrl = makeApiUrl(DefaultBaseUrl)
+
+// SetBaseUrl changes the SWARFARM instance all requests are sent to, e.g. to a staging server or a fake.
+func SetBaseUrl(baseUrl string) {
+	apiUrl = makeApiUrl(baseUrl)
+}
+
+func makeApiUrl(baseUrl string) string {
+	return strings.TrimSuffix(baseUrl, "/") + "/api/v2"
+}
+
 const (
-	baseUrl = "https://swarfarm.com"
-	apiUrl  = baseUrl + "/api/v2"
+	dataLogType     = "data_log"
+	liveSyncLogType = "profile_sync"
 )
 
-func makeAuthorizedClient(apiToken string) *resty.Client {
-	client := resty.New()
+var logTypeEndpoints = map[string]string{
+	dataLogType:     "/data_logs/",
+	liveSyncLogType: "/profiles/sync/",
+}
+
+var logTypeDescriptions = map[string]string{
+	dataLogType:     "data log",
+	liveSyncLogType: "live sync",
+}
+
+// httpClient is shared by all requests to SWARFARM
+var httpClient = httpclient.New("swarfarm", httpclient.DefaultOptions())
+
+// ConfigureHttpClient replaces the client used for all requests to SWARFARM.
+func ConfigureHttpClient(options httpclient.Options) {
+	httpClient = httpclient.New("swarfarm", options)
+}
+
+func makeAuthorizedRequest(apiToken string) *resty.Request {
+	request := httpClient.R()
 
 	if apiToken != "" {
-		client.SetHeader("Authorization", fmt.Sprintf("Token %s", apiToken))
+		request.SetHeader("Authorization", fmt.Sprintf("Token %s", apiToken))
 	}
 
-	return client
+	return request
 }
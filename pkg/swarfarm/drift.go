@@ -0,0 +1,217 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DriftMissing is a field the schema expects which the game did not send. Fields the game sent which the schema does
+// not know are not drift, SWARFARM only keeps the fields it defines, so they are only logged at debug level.
+const DriftMissing = "missing"
+
+// DriftEntry counts how often a field of a command differed from the SWARFARM schema.
+type DriftEntry struct {
+	LogType   string    `json:"log_type"`
+	Command   string    `json:"command"`
+	Direction string    `json:"direction"`
+	Field     string    `json:"field"`
+	Kind      string    `json:"kind"`
+	Count     uint64    `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type driftKey struct {
+	logType, command, direction, field, kind string
+}
+
+var (
+	driftMu      sync.Mutex
+	driftEntries = make(map[driftKey]*DriftEntry)
+	driftChanged bool
+	// unexpectedFields are the fields not in the schema which were logged already
+	unexpectedFields = make(map[driftKey]bool)
+)
+
+// checkDrift compares the request and response of a command with the schema of the log type and records every
+// missing field. Fields which are missing for the first time are logged as a warning, fields the schema does not know
+// are logged once at debug level.
+func checkDrift(logType, command string, cmdGroup map[string][]string, inputMap map[string]map[string]interface{}) {
+	if cmdGroup == nil {
+		return
+	}
+
+	now := time.Now()
+	var newMissing, newUnexpected []string
+
+	driftMu.Lock()
+	defer driftMu.Unlock()
+
+	record := func(direction, field string) {
+		key := driftKey{logType, command, direction, field, DriftMissing}
+		entry, ok := driftEntries[key]
+		if !ok {
+			entry = &DriftEntry{
+				LogType:   logType,
+				Command:   command,
+				Direction: direction,
+				Field:     field,
+				Kind:      DriftMissing,
+				FirstSeen: now,
+			}
+			driftEntries[key] = entry
+			newMissing = append(newMissing, direction+"."+field)
+		}
+
+		entry.Count++
+		entry.LastSeen = now
+		driftChanged = true
+	}
+
+	for _, direction := range []string{"request", "response"} {
+		fields := cmdGroup[direction]
+		input := inputMap[direction]
+
+		for _, field := range fields {
+			if _, ok := input[field]; !ok {
+				record(direction, field)
+			}
+		}
+
+		for field := range input {
+			key := driftKey{logType, command, direction, field, ""}
+			if !contains(fields, field) && !unexpectedFields[key] {
+				unexpectedFields[key] = true
+				newUnexpected = append(newUnexpected, direction+"."+field)
+			}
+		}
+	}
+
+	if len(newUnexpected) > 0 {
+		sort.Strings(newUnexpected)
+		log.Debug().
+			Str("swarfarmLogType", logType).
+			Str("command", command).
+			Strs("fields", newUnexpected).
+			Msg("Command has fields the SWARFARM schema does not know")
+	}
+
+	if len(newMissing) == 0 {
+		return
+	}
+
+	sort.Strings(newMissing)
+	log.Warn().
+		Str("swarfarmLogType", logType).
+		Str("command", command).
+		Strs("missing", newMissing).
+		Msg("Command is missing fields of the SWARFARM schema")
+}
+
+// DriftReport returns all fields which differed from the SWARFARM schema so far, sorted by command.
+func DriftReport() []DriftEntry {
+	driftMu.Lock()
+	defer driftMu.Unlock()
+
+	report := make([]DriftEntry, 0, len(driftEntries))
+	for _, entry := range driftEntries {
+		report = append(report, *entry)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.LogType != b.LogType {
+			return a.LogType < b.LogType
+		}
+		if a.Command != b.Command {
+			return a.Command < b.Command
+		}
+		if a.Direction != b.Direction {
+			return a.Direction < b.Direction
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Field < b.Field
+	})
+
+	return report
+}
+
+// stopDriftReport ends the periodic drift report and waits for it. It is nil as long as no report is written.
+var stopDriftReport func()
+
+// EnableDriftReport writes the drift report as JSON to the file in the given interval whenever it changed. Without a
+// positive interval the report is not written periodically.
+func EnableDriftReport(path string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	stop, done := make(chan struct{}), make(chan struct{})
+	stopDriftReport = func() {
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := WriteDriftReport(path); err != nil {
+				log.Error().Err(err).Str("driftReportFile", path).Msg("Failed to write drift report")
+			}
+		}
+	}()
+}
+
+// WriteDriftReport writes the drift report as JSON to the file if it changed since it was written last.
+func WriteDriftReport(path string) error {
+	driftMu.Lock()
+	changed := driftChanged
+	driftChanged = false
+	driftMu.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	err := writeDriftFile(path, DriftReport())
+	if err != nil {
+		// try again next time
+		driftMu.Lock()
+		driftChanged = true
+		driftMu.Unlock()
+	}
+
+	return err
+}
+
+func writeDriftFile(path string, report []DriftEntry) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize drift report: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
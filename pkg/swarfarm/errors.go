@@ -0,0 +1,76 @@
+package swarfarm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventError is the type of the errors returned by OnReceiveApiEvent. Use errors.Is to check for them.
+type eventError struct {
+	message string
+	code    codes.Code
+}
+
+func (e *eventError) Error() string { return e.message }
+
+// GRPCStatus makes the proxy receive a status code matching the error
+func (e *eventError) GRPCStatus() *status.Status { return status.New(e.code, e.message) }
+
+// eventCode returns the gRPC status code an error returned by OnReceiveApiEvent is reported with
+func eventCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+
+	var e *eventError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return codes.Internal
+}
+
+var (
+	// ErrDeserialization is returned if the request or the response of an api event is not valid JSON.
+	ErrDeserialization error = &eventError{"failed to deserialize api event", codes.InvalidArgument}
+	// ErrMissingWizardId is returned if the wizard id could not be found in the request or the response.
+	ErrMissingWizardId error = &eventError{"failed to get wizardId from API request/response", codes.InvalidArgument}
+	// ErrUploadFailed is returned if an upload failed because of an error which may go away, e.g. a network error.
+	ErrUploadFailed error = &eventError{"SWARFARM upload failed", codes.Unavailable}
+	// ErrRejected is returned if SWARFARM rejected an upload and repeating it will not help.
+	ErrRejected error = &eventError{"SWARFARM rejected the upload", codes.FailedPrecondition}
+)
+
+// UploadError describes a failed upload. It wraps ErrUploadFailed or ErrRejected, depending on the status code.
+type UploadError struct {
+	LogType  string
+	WizardId int64
+	Command  string
+	// StatusCode is the HTTP status code of the response or 0 if no response was received.
+	StatusCode int
+	Detail     string
+}
+
+func (e *UploadError) Error() string {
+	description := logTypeDescriptions[e.LogType]
+
+	switch {
+	case e.StatusCode == 0:
+		return fmt.Sprintf("SWARFARM %s upload failed: %s", description, e.Detail)
+	case e.StatusCode == http.StatusUnauthorized:
+		return fmt.Sprintf("SWARFARM %s upload failed - authentication error. detail: %s", description, e.Detail)
+	default:
+		return fmt.Sprintf("SWARFARM %s upload failed - invalid status code %d. detail: %s",
+			description, e.StatusCode, e.Detail)
+	}
+}
+
+func (e *UploadError) Unwrap() error {
+	if isRetryable(e.StatusCode) {
+		return ErrUploadFailed
+	}
+	return ErrRejected
+}
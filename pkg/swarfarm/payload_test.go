@@ -0,0 +1,130 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/swarpf/plugins/internal/fixtures"
+)
+
+// goldenDataLogSchema and goldenSyncSchema select the uploaded fields of the corpus commands. They are fixed in the
+// test, so the goldens only change with the payload code and not with the schemas served by SWARFARM.
+var goldenDataLogSchema = map[string]map[string][]string{
+	"GetGuildSiegeMatchupInfo": {
+		"request":  {"wizard_id", "command"},
+		"response": {"match_info", "guild_list", "base_list"},
+	},
+	"GetGuildSiegeBattleLog": {
+		"request":  {"wizard_id", "command", "log_type"},
+		"response": {"log_type", "log_list"},
+	},
+	"GetGuildWarBattleLogByWizardId": {
+		"request":  {"wizard_id", "command", "log_type"},
+		"response": {"log_type", "battle_log_list_group"},
+	},
+	"GetGuildWarBattleLogByGuildId": {
+		"request":  {"wizard_id", "command", "guild_id", "log_type"},
+		"response": {"log_type", "battle_log_list_group"},
+	},
+}
+
+var goldenSyncSchema = map[string]map[string][]string{
+	"HubUserLogin": {
+		"request":  {"wizard_id", "command"},
+		"response": {"wizard_info", "unit_list", "runes", "building_list"},
+	},
+	"GuestLogin": {
+		"request":  {"wizard_id", "command"},
+		"response": {"wizard_info", "unit_list", "runes", "building_list"},
+	},
+	"GetGuildSiegeBaseDefenseUnitListPreset": {
+		"request":  {"wizard_id", "command", "base_number"},
+		"response": {"defense_deck_list", "defense_unit_list"},
+	},
+}
+
+// TestGoldenPayloads builds the data log and live sync payloads of the fixture corpus and compares them with
+// testdata/golden.
+func TestGoldenPayloads(t *testing.T) {
+	setSchema(t, dataLogSchema, goldenDataLogSchema)
+	setSchema(t, liveSyncSchema, goldenSyncSchema)
+
+	dataLogEnabled, liveSyncEnabled := DataLogEnabled, LiveSyncEnabled
+	DataLogEnabled, LiveSyncEnabled = true, true
+	t.Cleanup(func() { DataLogEnabled, LiveSyncEnabled = dataLogEnabled, liveSyncEnabled })
+
+	var commands []string
+	for command := range goldenDataLogSchema {
+		commands = append(commands, command)
+	}
+	for command := range goldenSyncSchema {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	for _, command := range commands {
+		list, err := fixtures.List(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 {
+			t.Errorf("the corpus has no fixture for %s", command)
+		}
+
+		for _, f := range list {
+			t.Run(f.Name, func(t *testing.T) {
+				request, err := decodeContent(f.RequestString())
+				if err != nil {
+					t.Fatal(err)
+				}
+				response, err := decodeContent(f.ResponseString())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wizardId, ok := tryExtractWizardId(f.RequestString(), f.ResponseString())
+				if !ok {
+					t.Fatal("fixture has no wizard id")
+				}
+
+				payloads := map[string]json.RawMessage{}
+				if isCommandLoggerCommand(f.Command) {
+					payload, err := makeDataLogPayload(wizardId, f.Command, request, response)
+					if err != nil {
+						t.Fatal(err)
+					}
+					payloads[dataLogType] = payload
+				}
+				if isProfileSyncCommand(f.Command) {
+					payload, err := makeLiveSyncPayload(wizardId, f.Command, request, response)
+					if err != nil {
+						t.Fatal(err)
+					}
+					payloads[liveSyncLogType] = payload
+				}
+
+				got, err := json.Marshal(payloads)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fixtures.CheckGolden(t, filepath.Join("testdata", "golden", f.Name+".json"), got)
+			})
+		}
+	}
+}
+
+// setSchema replaces the cached schema for the test
+func setSchema(t *testing.T, cache *schemaCache, commands map[string]map[string][]string) {
+	cache.mu.Lock()
+	previous, loaded := cache.commands, cache.loaded
+	cache.commands, cache.loaded = commands, true
+	cache.mu.Unlock()
+
+	t.Cleanup(func() {
+		cache.mu.Lock()
+		cache.commands, cache.loaded = previous, loaded
+		cache.mu.Unlock()
+	})
+}
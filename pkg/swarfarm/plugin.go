@@ -1,18 +1,217 @@
 package swarfarm
 
 import (
-	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
-	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 
-	pb "github.com/swarpf/plugins/swarpf-idl/proto-gen-go/proxyapi"
+	"github.com/swarpf/plugins/internal/configcheck"
+	"github.com/swarpf/plugins/internal/httpclient"
+	"github.com/swarpf/plugins/internal/workqueue"
 )
 
-// proxy API consumer
-type ProxyApiConsumer struct {
-	pb.UnimplementedProxyApiConsumerServer
+// Plugin implements pluginruntime.Plugin for the SWARFARM uploader plugin
+type Plugin struct {
+	drainTimeout    time.Duration
+	driftReportFile string
 }
 
-func (s *ProxyApiConsumer) OnReceiveApiEvent(_ context.Context, ev *pb.ApiEvent) (*empty.Empty, error) {
-	return &empty.Empty{}, OnReceiveApiEvent(ev.GetCommand(), ev.GetRequest(), ev.GetResponse())
+func (p *Plugin) Name() string        { return "swarfarmuploader" }
+func (p *Plugin) DisplayName() string { return "SWARFARM uploader" }
+func (p *Plugin) DefaultPort() int    { return 11103 }
+
+func (p *Plugin) RegisterFlags(flags *pflag.FlagSet) {
+	flags.String("swarfarm_url", DefaultBaseUrl, "Base URL of the SWARFARM instance to upload to")
+	flags.Bool("datalog_enabled", true, "Enable SWARFARM data log upload")
+	flags.Bool("livesync_enabled", false, "Enable SWARFARM live sync")
+	flags.StringToString("api_tokens", map[string]string{}, "List of API tokens for SWARFARM. Format: 'wizardId=Token,...'")
+	flags.String("token_file", "", "YAML or JSON file with the SWARFARM profiles and tokens. It is reloaded on changes")
+	flags.Bool("validate_tokens", true, "Validate the SWARFARM tokens on startup and whenever the token file is reloaded. A rejected token stops the live sync of its wizard until the token is changed")
+	flags.String("dry_run_directory", "", "Write the uploads as JSON files to this directory instead of sending them to SWARFARM")
+	flags.String("spool_directory", "", "Directory in which failed uploads are stored and retried. Empty disables retries")
+	flags.Duration("spool_max_backoff", 5*time.Minute, "Maximum time between two attempts to upload a spooled upload")
+	flags.Duration("schema_refresh_interval", time.Hour, "Interval in which the accepted commands are fetched from SWARFARM again")
+	flags.String("schema_cache_directory", "", "Directory in which a copy of the accepted commands is stored for offline starts")
+	flags.String("drift_report_file", "", "JSON file listing the fields of the SWARFARM schema the commands did not contain. Fields the schema does not know are only logged at debug level")
+	flags.Duration("drift_report_interval", time.Minute, "Interval in which the drift report file is updated")
+	flags.Int("upload_workers", 2, "Number of workers uploading to SWARFARM. 0 uploads synchronously and reports failed uploads to the proxy")
+	flags.Int("upload_queue_size", 100, "Maximum number of api events waiting for upload")
+	flags.String("upload_queue_policy", string(workqueue.Block), "What to do if the upload queue is full: block, drop-oldest or spill (to the spool)")
+	flags.Duration("upload_drain_timeout", 30*time.Second, "Time to wait for queued uploads on shutdown")
+	httpclient.RegisterFlags(flags, "SWARFARM", httpclient.DefaultOptions())
+}
+
+func (p *Plugin) Configure(config *viper.Viper) error {
+	SetBaseUrl(config.GetString("swarfarm_url"))
+	ConfigureHttpClient(httpclient.OptionsFromConfig(config))
+	DataLogEnabled = config.GetBool("datalog_enabled")
+	LiveSyncEnabled = config.GetBool("livesync_enabled")
+
+	// nothing is sent to SWARFARM in dry-run mode, not even the tokens for validation
+	dryRunDirectory := config.GetString("dry_run_directory")
+	validateTokens := config.GetBool("validate_tokens") && dryRunDirectory == ""
+
+	// Process all swarfarm API tokens
+	apiTokens := config.GetStringMapString("api_tokens")
+	for wizardId, token := range apiTokens {
+		log.Info().Str("wizardId", wizardId).Msgf("Adding token for wizard %s", wizardId)
+
+		AddProfile(wizardId, token)
+	}
+
+	if tokenFile := config.GetString("token_file"); tokenFile != "" {
+		if len(apiTokens) > 0 {
+			return errors.New("api_tokens and token_file can not be used together")
+		}
+
+		store, err := OpenFileTokenStore(tokenFile)
+		if err != nil {
+			return err
+		}
+
+		store.OnReload(ReloadTokens)
+		if validateTokens {
+			store.OnReload(func() { go ValidateTokens() })
+		}
+
+		SetTokenStore(store)
+	}
+
+	if dryRunDirectory != "" {
+		if err := EnableCapture(dryRunDirectory); err != nil {
+			return err
+		}
+	}
+
+	if err := EnableSchemaRefresh(config.GetDuration("schema_refresh_interval"),
+		config.GetString("schema_cache_directory")); err != nil {
+		return err
+	}
+
+	if p.driftReportFile = config.GetString("drift_report_file"); p.driftReportFile != "" {
+		EnableDriftReport(p.driftReportFile, config.GetDuration("drift_report_interval"))
+	}
+
+	if spoolDirectory := config.GetString("spool_directory"); spoolDirectory != "" {
+		if err := EnableSpool(spoolDirectory, config.GetDuration("spool_max_backoff")); err != nil {
+			return err
+		}
+	}
+
+	if workers := config.GetInt("upload_workers"); workers > 0 {
+		policy, err := workqueue.ParsePolicy(config.GetString("upload_queue_policy"))
+		if err != nil {
+			return err
+		}
+
+		if policy == workqueue.Spill && activeSpool() == nil {
+			return errors.New("upload queue policy spill requires a spool directory")
+		}
+
+		EnableUploadQueue(workqueue.Options{
+			Workers: workers,
+			Size:    config.GetInt("upload_queue_size"),
+			Policy:  policy,
+		})
+		p.drainTimeout = config.GetDuration("upload_drain_timeout")
+	}
+
+	// validate after the spool was enabled, so the uploads of quarantined wizards are kept in it
+	if validateTokens && (DataLogEnabled || LiveSyncEnabled) {
+		go ValidateTokens()
+	}
+
+	return nil
+}
+
+func (p *Plugin) ValidateConfig(config *viper.Viper) []error {
+	var errs configcheck.Errors
+	errs.Add("swarfarm_url", configcheck.HttpUrl(config.GetString("swarfarm_url")))
+
+	apiTokens := config.GetStringMapString("api_tokens")
+	for wizardId, token := range apiTokens {
+		errs.Add("api_tokens", checkProfile(Profile{WizardId: wizardId, Token: token}))
+	}
+
+	if tokenFile := config.GetString("token_file"); tokenFile != "" {
+		if len(apiTokens) > 0 {
+			errs.Addf("token_file", "can not be used together with api_tokens")
+		}
+
+		profiles, err := readTokenFile(tokenFile)
+		errs.Add("token_file", err)
+		for _, profile := range profiles {
+			errs.Add("token_file", checkProfile(profile))
+		}
+	}
+
+	for _, key := range []string{"dry_run_directory", "spool_directory", "schema_cache_directory"} {
+		if directory := config.GetString(key); directory != "" {
+			errs.Add(key, configcheck.WritableDirectory(directory))
+		}
+	}
+	if file := config.GetString("drift_report_file"); file != "" {
+		errs.Add("drift_report_file", configcheck.WritableFile(file))
+	}
+
+	for _, key := range []string{"schema_refresh_interval", "upload_drain_timeout"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetDuration(key))))
+	}
+	for _, key := range []string{"spool_max_backoff", "drift_report_interval"} {
+		errs.Add(key, configcheck.Positive(float64(config.GetDuration(key))))
+	}
+	for _, key := range []string{"upload_workers", "upload_queue_size"} {
+		errs.Add(key, configcheck.NotNegative(float64(config.GetInt(key))))
+	}
+
+	policy, err := workqueue.ParsePolicy(config.GetString("upload_queue_policy"))
+	errs.Add("upload_queue_policy", err)
+	if policy == workqueue.Spill && config.GetString("spool_directory") == "" {
+		errs.Addf("upload_queue_policy", "spill requires a spool_directory")
+	}
+
+	return append(errs, httpclient.ValidateConfig(config)...)
+}
+
+// checkProfile makes sure a profile has a numeric wizard id and a token which can be sent in a header
+func checkProfile(profile Profile) error {
+	if _, err := strconv.ParseUint(profile.WizardId, 10, 64); err != nil {
+		return fmt.Errorf("wizard id %q is not a number", profile.WizardId)
+	}
+
+	if profile.Token == "" {
+		return fmt.Errorf("token of wizard %s is empty", profile.WizardId)
+	}
+	if strings.IndexFunc(profile.Token, unicode.IsSpace) >= 0 || strings.ContainsAny(profile.Token, ",=") {
+		return fmt.Errorf("token of wizard %s contains whitespace, ',' or '='", profile.WizardId)
+	}
+
+	return nil
+}
+
+func (p *Plugin) Shutdown() {
+	Shutdown(p.drainTimeout)
+
+	if p.driftReportFile != "" {
+		if err := WriteDriftReport(p.driftReportFile); err != nil {
+			log.Error().Err(err).Str("driftReportFile", p.driftReportFile).Msg("Failed to write drift report")
+		}
+	}
+}
+
+func (p *Plugin) CheckReady() error { return CheckSchemas() }
+
+func (p *Plugin) SubscribedCommands() []string { return SubscribedCommands() }
+
+func (p *Plugin) WatchSubscribedCommands(f func(commands []string)) { OnSubscribedCommandsChanged(f) }
+
+func (p *Plugin) OnReceiveApiEvent(command, request, response string) error {
+	return OnReceiveApiEvent(command, request, response)
 }
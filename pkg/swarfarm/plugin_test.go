@@ -0,0 +1,465 @@
+package swarfarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/pkg/proxyfake"
+	"github.com/swarpf/plugins/pkg/swarfarm"
+	"github.com/swarpf/plugins/pkg/swarfarmfake"
+)
+
+func TestPlugin(t *testing.T) {
+	fake := swarfarmfake.New()
+	fake.AddToken("token123", 123)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	proxy, plugin := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":     server.URL,
+		"api_tokens":       map[string]string{"123": "token123"},
+		"livesync_enabled": true,
+		"validate_tokens":  false,
+		"upload_workers":   0,
+	})
+
+	// the plugin subscribes to the commands of the schemas served by SWARFARM
+	registration, _ := proxy.Consumer(plugin.Address)
+	commands := append([]string(nil), registration.Commands...)
+	sort.Strings(commands)
+	want := []string{"BattleDungeonResult_V2", "HubUserLogin", "SummonUnit", "UpgradeRune"}
+	if !equalStrings(commands, want) {
+		t.Fatalf("registered commands = %v, want %v", commands, want)
+	}
+
+	tests := []struct {
+		name       string
+		command    string
+		request    string
+		response   string
+		failStatus int
+		code       codes.Code
+		uploads    []swarfarmfake.Upload
+	}{
+		{
+			name:     "data log with token",
+			command:  "SummonUnit",
+			request:  `{"command": "SummonUnit", "wizard_id": 123, "mode": 1, "summon_id": 2, "session_key": "x"}`,
+			response: `{"unit_list": [], "item_list": []}`,
+			uploads:  []swarfarmfake.Upload{{Path: swarfarmfake.DataLogsPath, Token: "token123"}},
+		},
+		{
+			name:     "anonymous data log",
+			command:  "SummonUnit",
+			request:  `{"command": "SummonUnit", "wizard_id": 456, "mode": 1, "summon_id": 2}`,
+			response: `{"unit_list": [], "item_list": []}`,
+			uploads:  []swarfarmfake.Upload{{Path: swarfarmfake.DataLogsPath}},
+		},
+		{
+			name:     "live sync",
+			command:  "UpgradeRune",
+			request:  `{"command": "UpgradeRune", "wizard_id": 123, "rune_id": 7}`,
+			response: `{"rune": {"rune_id": 7}}`,
+			uploads:  []swarfarmfake.Upload{{Path: swarfarmfake.SyncPath, Token: "token123"}},
+		},
+		{
+			name:     "live sync without token",
+			command:  "UpgradeRune",
+			request:  `{"command": "UpgradeRune", "wizard_id": 456, "rune_id": 7}`,
+			response: `{"rune": {"rune_id": 7}}`,
+		},
+		{
+			name:     "wizard id from wizard info",
+			command:  "HubUserLogin",
+			request:  `{"command": "HubUserLogin"}`,
+			response: `{"wizard_info": {"wizard_id": 123}, "unit_list": [], "runes": [], "building_list": []}`,
+			uploads:  []swarfarmfake.Upload{{Path: swarfarmfake.SyncPath, Token: "token123"}},
+		},
+		{
+			name:     "invalid request",
+			command:  "SummonUnit",
+			request:  "{",
+			response: `{}`,
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:     "missing wizard id",
+			command:  "SummonUnit",
+			request:  `{"command": "SummonUnit"}`,
+			response: `{}`,
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:       "upload fails",
+			command:    "SummonUnit",
+			request:    `{"command": "SummonUnit", "wizard_id": 123}`,
+			response:   `{}`,
+			failStatus: http.StatusInternalServerError,
+			code:       codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.Reset()
+			if tt.failStatus != 0 {
+				fake.FailWith(tt.failStatus, 1)
+			}
+
+			err := proxy.Send(context.Background(), tt.command, tt.request, tt.response)
+			if status.Code(err) != tt.code {
+				t.Fatalf("Send() error = %v, want code %s", err, tt.code)
+			}
+
+			uploads := fake.Uploads()
+			if len(uploads) != len(tt.uploads) {
+				t.Fatalf("got %d uploads, want %d", len(uploads), len(tt.uploads))
+			}
+			for i, upload := range uploads {
+				if upload.Path != tt.uploads[i].Path || upload.Token != tt.uploads[i].Token {
+					t.Errorf("upload %d went to %s with token %q, want %s with token %q",
+						i, upload.Path, upload.Token, tt.uploads[i].Path, tt.uploads[i].Token)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadQueue(t *testing.T) {
+	fake := swarfarmfake.New()
+	fake.AddToken("token123", 123)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	proxy, _ := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":    server.URL,
+		"api_tokens":      map[string]string{"123": "token123"},
+		"validate_tokens": false,
+		"upload_workers":  2,
+	})
+
+	failed := metrics.QueuedJobs.WithLabelValues("swarfarm", "SummonUnit", codes.Unavailable.String())
+	before := testutil.ToFloat64(failed)
+
+	// the event is reported as handled before the upload failed
+	fake.FailWith(http.StatusInternalServerError, 1)
+	request := `{"command": "SummonUnit", "wizard_id": 123}`
+	if err := proxy.Send(context.Background(), "SummonUnit", request, `{}`); err != nil {
+		t.Fatalf("Send() error = %v, want nil for a queued event", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(failed) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed upload was not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchemaRefresh(t *testing.T) {
+	fake := swarfarmfake.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	proxy, plugin := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":            server.URL,
+		"livesync_enabled":        true,
+		"validate_tokens":         false,
+		"schema_refresh_interval": "20ms",
+	})
+
+	// SWARFARM accepts another command, the plugin registers again with it. The schemas are cached by the package, so
+	// the test ends with the default schema again.
+	schema := swarfarmfake.Schema{"UpgradeDeco": {"request": {"wizard_id", "command"}, "response": {"deco"}}}
+	for command, fields := range swarfarmfake.DefaultSyncSchema {
+		schema[command] = fields
+	}
+
+	for _, step := range []struct {
+		schema     swarfarmfake.Schema
+		registered bool
+	}{{schema, true}, {swarfarmfake.DefaultSyncSchema, false}} {
+		fake.SetSyncSchema(step.schema)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			registration, _ := proxy.Consumer(plugin.Address)
+			if contains(registration.Commands, "UpgradeDeco") == step.registered {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("registered commands = %v after the schema refresh, want UpgradeDeco: %v",
+					registration.Commands, step.registered)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	fake := swarfarmfake.New()
+	fake.AddToken("token789", 789)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	spoolDirectory, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(spoolDirectory) })
+
+	// SWARFARM does not know the token of the wizard
+	proxy, _ := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":     server.URL,
+		"api_tokens":       map[string]string{"789": "revoked789"},
+		"livesync_enabled": true,
+		"validate_tokens":  false,
+		"spool_directory":  spoolDirectory,
+		"upload_workers":   0,
+	})
+
+	liveSync := `{"command": "UpgradeRune", "wizard_id": 789, "rune_id": 7}`
+	dataLog := `{"command": "SummonUnit", "wizard_id": 789, "mode": 1, "summon_id": 2}`
+	send := func(command, request, response string) {
+		t.Helper()
+		if err := proxy.Send(context.Background(), command, request, response); err != nil {
+			t.Fatalf("Send(%s) error = %v, want nil", command, err)
+		}
+	}
+
+	// the 401 quarantines the live sync of the wizard, the upload is kept in the spool
+	send("UpgradeRune", liveSync, `{"rune": {"rune_id": 7}}`)
+	if uploads := fake.Uploads(); len(uploads) != 0 {
+		t.Fatalf("got %d uploads after the token was rejected, want 0", len(uploads))
+	}
+
+	// data logs are sent without token during the quarantine, live sync uploads are held back
+	for i := 0; i < 2; i++ {
+		send("SummonUnit", dataLog, `{"unit_list": [], "item_list": []}`)
+		send("UpgradeRune", liveSync, `{"rune": {"rune_id": 7}}`)
+	}
+	uploads := fake.Uploads()
+	if len(uploads) != 2 {
+		t.Fatalf("got %d uploads during the quarantine, want 2 data logs", len(uploads))
+	}
+	for i, upload := range uploads {
+		if upload.Path != swarfarmfake.DataLogsPath || upload.Token != "" {
+			t.Errorf("upload %d went to %s with token %q, want an anonymous data log", i, upload.Path, upload.Token)
+		}
+	}
+
+	// a new token releases the wizard and the held back uploads are sent with it
+	swarfarm.AddProfile("789", "token789")
+	swarfarm.ReloadTokens()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		synced := 0
+		for _, upload := range fake.Uploads() {
+			if upload.Path == swarfarmfake.SyncPath && upload.Token == "token789" {
+				synced++
+			}
+		}
+		if synced == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d live sync uploads after the token was changed, want 3", synced)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDriftReport(t *testing.T) {
+	fake := swarfarmfake.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	directory, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	reportFile := filepath.Join(directory, "drift.json")
+	proxy, _ := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":          server.URL,
+		"validate_tokens":       false,
+		"drift_report_file":     reportFile,
+		"drift_report_interval": "20ms",
+	})
+
+	// summon_id is missing, unknown_field is not part of the schema
+	request := `{"command": "SummonUnit", "wizard_id": 456, "mode": 1, "unknown_field": 1}`
+	if err := proxy.Send(context.Background(), "SummonUnit", request, `{"unit_list": [], "item_list": []}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the report is written by the periodic update, long before the plugin is shut down
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, _ := ioutil.ReadFile(reportFile)
+		if strings.Contains(string(content), `"field": "summon_id"`) {
+			if strings.Contains(string(content), "unknown_field") {
+				t.Errorf("drift report = %s, want only the missing fields", content)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("drift report = %s, want summon_id", content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	fake := swarfarmfake.New()
+	fake.AddToken("token123", 123)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	directory, err := ioutil.TempDir("", "dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	proxy, _ := proxyfake.StartTest(t, &swarfarm.Plugin{}, map[string]interface{}{
+		"swarfarm_url":      server.URL,
+		"api_tokens":        map[string]string{"123": "token123"},
+		"livesync_enabled":  true,
+		"validate_tokens":   true,
+		"dry_run_directory": directory,
+		"upload_workers":    0,
+	})
+
+	events := []struct {
+		command  string
+		request  string
+		response string
+	}{
+		{"SummonUnit", `{"command": "SummonUnit", "wizard_id": 123, "mode": 1, "summon_id": 2}`, `{"unit_list": [], "item_list": []}`},
+		{"SummonUnit", `{"command": "SummonUnit", "wizard_id": 456, "mode": 1, "summon_id": 2}`, `{"unit_list": [], "item_list": []}`},
+		{"UpgradeRune", `{"command": "UpgradeRune", "wizard_id": 123, "rune_id": 7}`, `{"rune": {"rune_id": 7}}`},
+	}
+	for _, event := range events {
+		if err := proxy.Send(context.Background(), event.command, event.request, event.response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the schemas are fetched, neither the uploads nor the token validation reach SWARFARM
+	for _, request := range fake.Requests() {
+		if request.Method != http.MethodGet || (request.Path != swarfarmfake.DataLogsPath && request.Path != swarfarmfake.AcceptedCommandsPath) {
+			t.Errorf("request %s %s reached SWARFARM in dry-run mode", request.Method, request.Path)
+		}
+	}
+	if uploads := fake.Uploads(); len(uploads) != 0 {
+		t.Errorf("got %d uploads in dry-run mode, want 0", len(uploads))
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(events) {
+		t.Fatalf("%d files written, want one per upload", len(files))
+	}
+
+	authorizations := map[string]string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "token123") {
+			t.Errorf("%s contains the token", file.Name())
+		}
+
+		upload := swarfarm.CapturedUpload{}
+		if err := json.Unmarshal(content, &upload); err != nil {
+			t.Fatalf("%s: %v", file.Name(), err)
+		}
+		authorizations[fmt.Sprintf("%s %d", upload.LogType, upload.WizardId)] = upload.Headers["Authorization"]
+	}
+
+	want := map[string]string{"data_log 123": "Token REDACTED", "data_log 456": "", "profile_sync 123": "Token REDACTED"}
+	if len(authorizations) != len(want) {
+		t.Errorf("captured uploads = %v, want %v", authorizations, want)
+	}
+	for upload, authorization := range want {
+		if got, ok := authorizations[upload]; !ok || got != authorization {
+			t.Errorf("Authorization header of the %s upload = %q, want %q", upload, got, authorization)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		errors   int
+	}{
+		{"defaults", nil, 0},
+		{"valid tokens", map[string]interface{}{"api_tokens": map[string]string{"123": "abc"}}, 0},
+		{"wizard id is not a number", map[string]interface{}{"api_tokens": map[string]string{"abc": "abc"}}, 1},
+		{"empty token", map[string]interface{}{"api_tokens": map[string]string{"123": ""}}, 1},
+		{"invalid url", map[string]interface{}{"swarfarm_url": "swarfarm.com"}, 1},
+		{"spill without spool", map[string]interface{}{"upload_queue_policy": "spill"}, 1},
+		{"unknown policy", map[string]interface{}{"upload_queue_policy": "drop"}, 1},
+		{"no spool backoff", map[string]interface{}{"spool_max_backoff": "0s"}, 1},
+		{"no drift report interval", map[string]interface{}{"drift_report_interval": "0s"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := &swarfarm.Plugin{}
+			config, err := proxyfake.Config(plugin, tt.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if errs := plugin.ValidateConfig(config); len(errs) != tt.errors {
+				t.Errorf("ValidateConfig() = %v, want %d errors", errs, tt.errors)
+			}
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// schemaRetryInterval is the minimum time between two attempts to fetch a schema which could not be loaded yet
+const schemaRetryInterval = time.Minute
+
+var (
+	dataLogSchema  = newSchemaCache("data log commands", "/data_logs", "data_logs.json")
+	liveSyncSchema = newSchemaCache("live sync commands", "/profiles/accepted-commands/", "accepted_commands.json")
+)
+
+// schemaCacheDirectory stores a copy of the last successfully fetched schemas. Empty disables the on-disk copy.
+var schemaCacheDirectory string
+
+// commandsChanged is called with the new list of subscribed commands whenever a refresh changed them
+var commandsChanged func(commands []string)
+
+// stopSchemaRefresh ends the periodic refresh and waits for it. It is nil as long as no refresh runs.
+var stopSchemaRefresh func()
+
+// EnableSchemaRefresh fetches the schemas of all enabled upload types and refreshes them in the given interval.
+// If directory is not empty, the last successfully fetched schemas are stored in it and used as a fallback if
+// SWARFARM can not be reached.
+func EnableSchemaRefresh(interval time.Duration, directory string) error {
+	if directory != "" {
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return fmt.Errorf("failed to create schema cache directory: %w", err)
+		}
+	}
+	schemaCacheDirectory = directory
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	stopSchemaRefresh = func() {
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		for {
+			// retry schemas which could not be loaded yet more often than the regular refresh
+			wait := interval
+			if !schemasLoaded() {
+				wait = schemaRetryInterval
+			} else if interval <= 0 {
+				return
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			refreshSchemas()
+		}
+	}()
+
+	return nil
+}
+
+// OnSubscribedCommandsChanged sets the function which is called whenever a schema refresh changed the list of
+// subscribed commands.
+func OnSubscribedCommandsChanged(f func(commands []string)) {
+	commandsChanged = f
+}
+
+func refreshSchemas() {
+	changed := false
+	if DataLogEnabled && dataLogSchema.Refresh() {
+		changed = true
+	}
+	if LiveSyncEnabled && liveSyncSchema.Refresh() {
+		changed = true
+	}
+
+	if changed {
+		notifyCommandsChanged()
+	}
+}
+
+func notifyCommandsChanged() {
+	if commandsChanged != nil {
+		go commandsChanged(SubscribedCommands())
+	}
+}
+
+// CheckSchemas returns an error as long as the schemas of the enabled upload types could not be loaded.
+func CheckSchemas() error {
+	if DataLogEnabled && !dataLogSchema.Loaded() {
+		return fmt.Errorf("SWARFARM %s are not loaded", dataLogSchema.tag)
+	}
+	if LiveSyncEnabled && !liveSyncSchema.Loaded() {
+		return fmt.Errorf("SWARFARM %s are not loaded", liveSyncSchema.tag)
+	}
+
+	return nil
+}
+
+func schemasLoaded() bool {
+	return (!DataLogEnabled || dataLogSchema.Loaded()) && (!LiveSyncEnabled || liveSyncSchema.Loaded())
+}
+
+// schemaCache holds the commands and their fields accepted by one of the SWARFARM endpoints.
+type schemaCache struct {
+	tag      string
+	path     string
+	fileName string
+
+	mu          sync.RWMutex
+	commands    map[string]map[string][]string
+	loaded      bool
+	lastAttempt time.Time
+	refreshing  chan struct{}
+}
+
+func newSchemaCache(tag, path, fileName string) *schemaCache {
+	return &schemaCache{
+		tag:      tag,
+		path:     path,
+		fileName: fileName,
+		commands: make(map[string]map[string][]string),
+	}
+}
+
+// Get returns the cached schema. As long as no schema could be loaded, every call after the retry interval tries to
+// fetch the schema again, and calls while the schema is fetched wait for the result.
+func (c *schemaCache) Get() map[string]map[string][]string {
+	c.mu.RLock()
+	commands, loaded := c.commands, c.loaded
+	c.mu.RUnlock()
+
+	if loaded {
+		log.Trace().Str("cache_tag", c.tag).Msg("Using cached version of the schema")
+		return commands
+	}
+
+	if c.refresh(true) {
+		notifyCommandsChanged()
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.commands
+}
+
+func (c *schemaCache) Loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.loaded
+}
+
+// Refresh fetches the schema from SWARFARM. If that fails and no schema was loaded yet, the on-disk copy is used.
+// It reports whether the set of commands changed. Concurrent calls wait for the running fetch instead of starting
+// another one, only the call which fetched the schema reports a change.
+func (c *schemaCache) Refresh() bool {
+	return c.refresh(false)
+}
+
+// refresh fetches the schema like Refresh. With onlyIfStale the schema is only fetched if none is loaded and the
+// last attempt is longer ago than the retry interval, checked under the same lock which starts the fetch.
+func (c *schemaCache) refresh(onlyIfStale bool) bool {
+	c.mu.Lock()
+	if running := c.refreshing; running != nil {
+		c.mu.Unlock()
+		<-running
+		return false
+	}
+	if onlyIfStale && (c.loaded || time.Since(c.lastAttempt) < schemaRetryInterval) {
+		c.mu.Unlock()
+		return false
+	}
+
+	done := make(chan struct{})
+	c.refreshing, c.lastAttempt = done, time.Now()
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.refreshing = nil
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	commands, err := fetchSchema(c.tag, apiUrl+c.path)
+	if err != nil {
+		log.Error().Err(err).Str("cache_tag", c.tag).Msgf("Unable to retrieve %s from SWARFARM.", c.tag)
+
+		c.mu.RLock()
+		loaded := c.loaded
+		c.mu.RUnlock()
+		if loaded {
+			return false
+		}
+
+		if commands, err = c.readFile(); err != nil {
+			log.Error().Err(err).
+				Str("cache_tag", c.tag).
+				Msgf("No stored copy of %s available. SWARFARM logging is disabled until they can be retrieved.", c.tag)
+			return false
+		}
+
+		log.Warn().Str("cache_tag", c.tag).Msgf("Using stored copy of %s.", c.tag)
+	} else if err := c.writeFile(commands); err != nil {
+		log.Error().Err(err).Str("cache_tag", c.tag).Msgf("Failed to store copy of %s.", c.tag)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := !sameCommands(c.commands, commands)
+	c.commands = commands
+	c.loaded = true
+
+	return changed
+}
+
+func (c *schemaCache) filePath() string {
+	return filepath.Join(schemaCacheDirectory, c.fileName)
+}
+
+func (c *schemaCache) readFile() (map[string]map[string][]string, error) {
+	if schemaCacheDirectory == "" {
+		return nil, errors.New("schema cache directory is not configured")
+	}
+
+	content, err := ioutil.ReadFile(c.filePath())
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make(map[string]map[string][]string)
+	if err := json.Unmarshal(content, &commands); err != nil {
+		return nil, err
+	}
+
+	return commands, nil
+}
+
+func (c *schemaCache) writeFile(commands map[string]map[string][]string) error {
+	if schemaCacheDirectory == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(commands)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.filePath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, c.filePath())
+}
+
+func fetchSchema(cacheTag, url string) (map[string]map[string][]string, error) {
+	log.Debug().Msgf("Fetching %s from SWARFARM...", cacheTag)
+
+	resp, err := httpClient.R().Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, fmt.Errorf("endpoint %s not found", url)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("invalid status code %d", resp.StatusCode())
+	}
+
+	content := map[string]interface{}{}
+	err = json.Unmarshal(resp.Body(), &content)
+	if err != nil {
+		return nil, fmt.Errorf("error while deserializing SWARFARM %s: %w", cacheTag, err)
+	}
+
+	commandCache := make(map[string]map[string][]string)
+	for k, v := range content {
+		// skip non-commands
+		if strings.HasPrefix(k, "__") {
+			continue
+		}
+
+		contentCmd, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected schema of command %s", k)
+		}
+
+		cmd := make(map[string][]string, 1)
+		for cmdDirection, validValues := range contentCmd {
+			values, ok := validValues.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("unexpected schema of command %s", k)
+			}
+
+			for _, validValue := range values {
+				if value, ok := validValue.(string); ok {
+					cmd[cmdDirection] = append(cmd[cmdDirection], value)
+				}
+			}
+		}
+
+		commandCache[k] = cmd
+	}
+
+	keys := make([]string, 0, len(commandCache))
+	for k := range commandCache {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	log.Info().
+		Str("cache_tag", cacheTag).
+		Strs("commands", keys).
+		Msgf("Successfully retrieved %s from SWARFARM.", cacheTag)
+
+	return commandCache, nil
+}
+
+// sameCommands reports whether both schemas contain the same commands
+func sameCommands(a, b map[string]map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
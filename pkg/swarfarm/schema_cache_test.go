@@ -0,0 +1,96 @@
+package swarfarm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swarpf/plugins/pkg/swarfarmfake"
+)
+
+func TestSchemaCacheOfflineCopy(t *testing.T) {
+	fake := swarfarmfake.New()
+	useTestServer(t, fake, tempDirectory(t))
+
+	cache := newTestSchemaCache()
+	if !cache.Refresh() {
+		t.Fatal("Refresh() = false, want the first schema to be a change")
+	}
+
+	// SWARFARM can not be reached after a restart, the stored copy is used
+	fake.FailWith(http.StatusServiceUnavailable, 0)
+	restarted := newTestSchemaCache()
+	want := map[string]map[string][]string(swarfarmfake.DefaultDataLogSchema)
+	if commands := restarted.Get(); !reflect.DeepEqual(commands, want) {
+		t.Errorf("Get() = %v, want the stored copy %v", commands, want)
+	}
+	if !restarted.Loaded() {
+		t.Errorf("Loaded() = false with a stored copy")
+	}
+
+	// a schema which was loaded is kept if a later refresh fails
+	if restarted.Refresh() || !reflect.DeepEqual(restarted.Get(), want) {
+		t.Errorf("failed refresh changed the schema to %v", restarted.Get())
+	}
+
+	// without stored copy the schema is not loaded
+	schemaCacheDirectory = tempDirectory(t)
+	empty := newTestSchemaCache()
+	if commands := empty.Get(); len(commands) != 0 || empty.Loaded() {
+		t.Errorf("Get() = %v and Loaded() = %v without stored copy, want no schema", commands, empty.Loaded())
+	}
+}
+
+func TestSchemaCacheConcurrentGet(t *testing.T) {
+	fake := swarfarmfake.New()
+	fake.SetDelay(50 * time.Millisecond)
+
+	var requests int32
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fake.ServeHTTP(w, r)
+	}), "")
+
+	cache := newTestSchemaCache()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if commands := cache.Get(); len(commands) != len(swarfarmfake.DefaultDataLogSchema) {
+				t.Errorf("Get() = %v, want the schema served by SWARFARM", commands)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("concurrent calls of Get() fetched the schema %d times, want once", n)
+	}
+}
+
+func newTestSchemaCache() *schemaCache {
+	return newSchemaCache(dataLogSchema.tag, dataLogSchema.path, dataLogSchema.fileName)
+}
+
+// useTestServer points the uploader at handler and stores the schemas in directory until the test ends. Changes of
+// the subscribed commands are not reported during the test.
+func useTestServer(t *testing.T, handler http.Handler, directory string) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	previousChanged := commandsChanged
+	SetBaseUrl(server.URL)
+	schemaCacheDirectory, commandsChanged = directory, nil
+	t.Cleanup(func() {
+		SetBaseUrl(DefaultBaseUrl)
+		schemaCacheDirectory, commandsChanged = "", previousChanged
+	})
+}
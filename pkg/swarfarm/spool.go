@@ -0,0 +1,442 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+)
+
+const (
+	spoolFileExtension = ".json"
+	spoolMinBackoff    = time.Second
+)
+
+// uploadSpool is nil as long as the spool is disabled
+var (
+	uploadSpoolMu sync.RWMutex
+	uploadSpool   *Spool
+)
+
+func activeSpool() *Spool {
+	uploadSpoolMu.RLock()
+	defer uploadSpoolMu.RUnlock()
+
+	return uploadSpool
+}
+
+// EnableSpool opens the spool in the given directory and starts replaying the uploads stored in it.
+func EnableSpool(directory string, maxBackoff time.Duration) error {
+	spool, err := OpenSpool(directory, maxBackoff)
+	if err != nil {
+		return err
+	}
+
+	uploadSpoolMu.Lock()
+	uploadSpool = spool
+	uploadSpoolMu.Unlock()
+
+	go spool.Run(func(e *SpoolEntry) (int, error) {
+		return uploadPayload(e.LogType, e.WizardId, e.Command, e.Payload)
+	})
+
+	return nil
+}
+
+// SpoolEntry is a single upload stored in the spool.
+type SpoolEntry struct {
+	Sequence uint64          `json:"sequence"`
+	LogType  string          `json:"log_type"`
+	WizardId int64           `json:"wizard_id"`
+	Command  string          `json:"command"`
+	Created  time.Time       `json:"created"`
+	Payload  json.RawMessage `json:"payload"`
+
+	attempts    int
+	nextAttempt time.Time
+}
+
+// spoolKey identifies the queue of an upload. Data logs and live sync are independent of each other.
+type spoolKey struct {
+	wizardId int64
+	logType  string
+}
+
+func (e *SpoolEntry) key() spoolKey {
+	return spoolKey{wizardId: e.WizardId, logType: e.LogType}
+}
+
+// Spool stores uploads which failed because of temporary errors on disk, one file per upload, and replays them in
+// order. The uploads of every wizard and log type are replayed strictly in the order they were added, so a failing
+// upload blocks all later uploads of the same wizard and log type until it succeeds or is rejected permanently.
+type Spool struct {
+	directory  string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu           sync.Mutex
+	nextSequence uint64
+	queues       map[spoolKey][]*SpoolEntry
+	paused       map[spoolKey]bool
+	wake         chan struct{}
+	stop         chan struct{}
+	closed       bool
+	running      sync.WaitGroup
+}
+
+// OpenSpool creates the spool directory if necessary and loads all uploads stored in it. A maximum backoff below one
+// second is raised to one second.
+func OpenSpool(directory string, maxBackoff time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	if maxBackoff < spoolMinBackoff {
+		maxBackoff = spoolMinBackoff
+	}
+
+	s := &Spool{
+		directory:    directory,
+		minBackoff:   spoolMinBackoff,
+		maxBackoff:   maxBackoff,
+		nextSequence: 1,
+		queues:       make(map[spoolKey][]*SpoolEntry),
+		paused:       make(map[spoolKey]bool),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	entries := make([]*SpoolEntry, 0, len(files))
+	for _, fi := range files {
+		// an entry which was still being written when the plugin crashed was never added to the spool
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), spoolFileExtension+".tmp") {
+			if err := os.Remove(filepath.Join(directory, fi.Name())); err != nil {
+				log.Error().Err(err).Str("file", fi.Name()).Msg("Failed to remove partially written spool entry")
+			}
+			continue
+		}
+
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), spoolFileExtension) {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(directory, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool entry: %w", err)
+		}
+
+		entry := &SpoolEntry{}
+		if err := json.Unmarshal(content, entry); err != nil {
+			log.Error().Err(err).Str("file", fi.Name()).Msg("Skipping corrupt spool entry")
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sequence < entries[j].Sequence })
+	for _, entry := range entries {
+		s.queues[entry.key()] = append(s.queues[entry.key()], entry)
+		if entry.Sequence >= s.nextSequence {
+			s.nextSequence = entry.Sequence + 1
+		}
+	}
+
+	metrics.SpoolLength.Set(float64(len(entries)))
+
+	if len(entries) > 0 {
+		log.Info().
+			Str("spoolDirectory", directory).
+			Int("entries", len(entries)).
+			Msg("Loaded pending SWARFARM uploads from spool")
+	}
+
+	return s, nil
+}
+
+// HasPending reports whether uploads of the wizard and log type are waiting in the spool.
+func (s *Spool) HasPending(logType string, wizardId int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queues[spoolKey{wizardId: wizardId, logType: logType}]) > 0
+}
+
+// Len returns the number of uploads waiting in the spool.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lenLocked()
+}
+
+func (s *Spool) lenLocked() int {
+	n := 0
+	for _, queue := range s.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// Pause stops replaying the uploads of the wizard and log type until Resume is called. Uploads are still added.
+func (s *Spool) Pause(logType string, wizardId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paused[spoolKey{wizardId: wizardId, logType: logType}] = true
+}
+
+// Resume continues replaying the paused uploads of the wizard and log type immediately.
+func (s *Spool) Resume(logType string, wizardId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := spoolKey{wizardId: wizardId, logType: logType}
+	if !s.paused[key] {
+		return
+	}
+	delete(s.paused, key)
+
+	if queue := s.queues[key]; len(queue) > 0 {
+		queue[0].attempts = 0
+		queue[0].nextAttempt = time.Time{}
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) isPaused(key spoolKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.paused[key]
+}
+
+// Add appends an upload to the spool. The upload is written to disk before Add returns.
+func (s *Spool) Add(logType string, wizardId int64, command string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &SpoolEntry{
+		Sequence: s.nextSequence,
+		LogType:  logType,
+		WizardId: wizardId,
+		Command:  command,
+		Created:  time.Now(),
+		Payload:  payload,
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize spool entry: %w", err)
+	}
+
+	// write to a temporary file first, so a crash never leaves a partially written entry behind
+	tmpPath := s.entryPath(entry) + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.entryPath(entry)); err != nil {
+		return fmt.Errorf("failed to write spool entry: %w", err)
+	}
+
+	s.nextSequence++
+	s.queues[entry.key()] = append(s.queues[entry.key()], entry)
+	metrics.SpoolLength.Set(float64(s.lenLocked()))
+
+	log.Warn().
+		Str("swarfarmLogType", logType).
+		Str("command", command).
+		Int64("wizardId", wizardId).
+		Uint64("sequence", entry.Sequence).
+		Msg("SWARFARM upload added to spool")
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run replays the uploads in the spool using upload, which has to return the HTTP status code of the response or 0
+// if no response was received. Run returns after Close was called, the uploads left in the spool are replayed after
+// the next start.
+func (s *Spool) Run(upload func(e *SpoolEntry) (int, error)) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.running.Add(1)
+	s.mu.Unlock()
+	defer s.running.Done()
+
+	for {
+		for _, entry := range s.dueEntries() {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+
+			statusCode, err := upload(entry)
+
+			switch {
+			case err == nil:
+				log.Info().
+					Str("swarfarmLogType", entry.LogType).
+					Int64("wizardId", entry.WizardId).
+					Uint64("sequence", entry.Sequence).
+					Msg("Spooled SWARFARM upload successful")
+				s.remove(entry)
+			case s.isPaused(entry.key()):
+				// the queue was paused because of this upload, keep it until it is resumed
+				log.Warn().Err(err).
+					Str("swarfarmLogType", entry.LogType).
+					Int64("wizardId", entry.WizardId).
+					Uint64("sequence", entry.Sequence).
+					Msg("Spooled SWARFARM upload is kept until the queue is resumed")
+			case statusCode == http.StatusUnauthorized && isQuarantined(entry.WizardId):
+				// the token of the wizard was rejected by this upload, a data log is repeated without token
+				s.retryLater(entry)
+			case !isRetryable(statusCode):
+				log.Error().Err(err).
+					Str("swarfarmLogType", entry.LogType).
+					Int64("wizardId", entry.WizardId).
+					Uint64("sequence", entry.Sequence).
+					Int("statusCode", statusCode).
+					Msg("Spooled SWARFARM upload was rejected permanently. Dropping it")
+				s.remove(entry)
+			default:
+				s.retryLater(entry)
+			}
+		}
+
+		timer := time.NewTimer(s.nextWakeup())
+		select {
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Close stops Run and waits until a running upload finished. Uploads can still be added to the spool afterwards,
+// they are kept on disk.
+func (s *Spool) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	s.running.Wait()
+}
+
+// dueEntries returns the first entry of every queue which is due for another attempt
+func (s *Spool) dueEntries() []*SpoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	due := make([]*SpoolEntry, 0)
+	for key, queue := range s.queues {
+		if len(queue) > 0 && !s.paused[key] && !queue[0].nextAttempt.After(now) {
+			due = append(due, queue[0])
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].Sequence < due[j].Sequence })
+	return due
+}
+
+func (s *Spool) nextWakeup() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wakeup := s.maxBackoff
+	now := time.Now()
+	for key, queue := range s.queues {
+		if len(queue) == 0 || s.paused[key] {
+			continue
+		}
+
+		if d := queue[0].nextAttempt.Sub(now); d < wakeup {
+			wakeup = d
+		}
+	}
+
+	if wakeup < 0 {
+		wakeup = 0
+	}
+	return wakeup
+}
+
+func (s *Spool) retryLater(entry *SpoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.attempts++
+
+	backoff := s.minBackoff << uint(entry.attempts-1)
+	if backoff > s.maxBackoff || backoff < s.minBackoff {
+		backoff = s.maxBackoff
+	}
+	entry.nextAttempt = time.Now().Add(backoff)
+
+	log.Warn().
+		Str("swarfarmLogType", entry.LogType).
+		Int64("wizardId", entry.WizardId).
+		Uint64("sequence", entry.Sequence).
+		Int("attempts", entry.attempts).
+		Dur("retryIn", backoff).
+		Msg("Spooled SWARFARM upload failed again")
+}
+
+func (s *Spool) remove(entry *SpoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.entryPath(entry)); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Uint64("sequence", entry.Sequence).Msg("Failed to remove spool entry")
+	}
+
+	queue := s.queues[entry.key()]
+	if len(queue) > 0 && queue[0] == entry {
+		queue = queue[1:]
+	}
+
+	if len(queue) == 0 {
+		delete(s.queues, entry.key())
+	} else {
+		s.queues[entry.key()] = queue
+	}
+	metrics.SpoolLength.Set(float64(s.lenLocked()))
+}
+
+func (s *Spool) entryPath(entry *SpoolEntry) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%020d-%d%s", entry.Sequence, entry.WizardId, spoolFileExtension))
+}
@@ -0,0 +1,278 @@
+package swarfarm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swarpf/plugins/pkg/swarfarmfake"
+)
+
+func TestSpoolOrderPerWizard(t *testing.T) {
+	spool := openTestSpool(t, tempDirectory(t))
+
+	for _, wizardId := range []int64{1, 2, 1, 2, 1} {
+		if err := spool.Add(dataLogType, wizardId, "SummonUnit", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first upload of wizard 1 fails twice, the uploads of wizard 2 are not held back by it
+	var mu sync.Mutex
+	failures := 2
+	uploaded := map[int64][]uint64{}
+	runTestSpool(t, spool, func(e *SpoolEntry) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if e.Sequence == 1 && failures > 0 {
+			failures--
+			return http.StatusServiceUnavailable, errors.New("unavailable")
+		}
+		uploaded[e.WizardId] = append(uploaded[e.WizardId], e.Sequence)
+		return http.StatusOK, nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[int64][]uint64{1: {1, 3, 5}, 2: {2, 4}}; !reflect.DeepEqual(uploaded, want) {
+		t.Errorf("uploaded = %v, want %v", uploaded, want)
+	}
+}
+
+func TestSpoolReplayAfterRestart(t *testing.T) {
+	directory := tempDirectory(t)
+
+	spool := openTestSpool(t, directory)
+	for _, wizardId := range []int64{1, 2, 1} {
+		if err := spool.Add(liveSyncLogType, wizardId, "HubUserLogin", []byte(`{"data":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spool.Close()
+
+	// a partially written entry is removed
+	partial := filepath.Join(directory, "00000000000000000009-1.json.tmp")
+	if err := ioutil.WriteFile(partial, []byte(`{"seq`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	spool = openTestSpool(t, directory)
+	if n := spool.Len(); n != 3 {
+		t.Fatalf("Len() after restart = %d, want 3", n)
+	}
+	if !spool.HasPending(liveSyncLogType, 1) || !spool.HasPending(liveSyncLogType, 2) {
+		t.Errorf("HasPending() after restart = false, want true")
+	}
+	if err := spool.Add(dataLogType, 2, "SummonUnit", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	uploaded := map[int64][]uint64{}
+	runTestSpool(t, spool, func(e *SpoolEntry) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if string(e.Payload) == "" {
+			t.Errorf("entry %d has no payload", e.Sequence)
+		}
+		uploaded[e.WizardId] = append(uploaded[e.WizardId], e.Sequence)
+		return http.StatusOK, nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[int64][]uint64{1: {1, 3}, 2: {2, 4}}; !reflect.DeepEqual(uploaded, want) {
+		t.Errorf("uploaded = %v, want %v", uploaded, want)
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d files left in the spool directory, want 0", len(files))
+	}
+}
+
+func TestUploadOrSpoolOrder(t *testing.T) {
+	fake := swarfarmfake.New()
+	useTestServer(t, fake, "")
+
+	spool := openTestSpool(t, tempDirectory(t))
+	uploadSpoolMu.Lock()
+	uploadSpool = spool
+	uploadSpoolMu.Unlock()
+	t.Cleanup(func() {
+		uploadSpoolMu.Lock()
+		uploadSpool = nil
+		uploadSpoolMu.Unlock()
+	})
+
+	// the first upload fails slowly, the second one must not overtake it while it is written to the spool
+	fake.SetDelay(100 * time.Millisecond)
+	fake.FailWith(http.StatusServiceUnavailable, 1)
+
+	errs := make(chan error, 2)
+	for i := 1; i <= 2; i++ {
+		payload := []byte(fmt.Sprintf(`{"data": {"n": %d}}`, i))
+		go func() { errs <- uploadOrSpool(dataLogType, 1, "SummonUnit", payload) }()
+		time.Sleep(20 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if uploads := fake.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d payloads uploaded while an earlier one was spooled, want 0", len(uploads))
+	}
+
+	var payloads []string
+	for _, entry := range spool.queues[spoolKey{wizardId: 1, logType: dataLogType}] {
+		payloads = append(payloads, string(entry.Payload))
+	}
+	if want := []string{`{"data": {"n": 1}}`, `{"data": {"n": 2}}`}; !reflect.DeepEqual(payloads, want) {
+		t.Errorf("spooled payloads = %v, want %v", payloads, want)
+	}
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	spool, err := OpenSpool(tempDirectory(t), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &SpoolEntry{Sequence: 1, WizardId: 1}
+	for _, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		checkBackoff(t, spool, entry, want*time.Second)
+	}
+
+	// a maximum backoff of zero would replay the spool in a busy loop
+	spool, err = OpenSpool(tempDirectory(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry = &SpoolEntry{Sequence: 1, WizardId: 1}
+	spool.queues[entry.key()] = []*SpoolEntry{entry}
+	checkBackoff(t, spool, entry, spoolMinBackoff)
+	checkBackoff(t, spool, entry, spoolMinBackoff)
+
+	if wakeup := spool.nextWakeup(); wakeup <= spoolMinBackoff/2 {
+		t.Errorf("nextWakeup() = %v, want about %v", wakeup, spoolMinBackoff)
+	}
+}
+
+func TestSpoolCloseWaitsForUpload(t *testing.T) {
+	spool := openTestSpool(t, tempDirectory(t))
+	if err := spool.Add(dataLogType, 1, "SummonUnit", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go spool.Run(func(e *SpoolEntry) (int, error) {
+		close(started)
+		<-release
+		return http.StatusOK, nil
+	})
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		spool.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close() returned while an upload was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() did not return after the upload finished")
+	}
+
+	// a closed spool is not replayed anymore
+	done := make(chan struct{})
+	go func() {
+		spool.Run(func(e *SpoolEntry) (int, error) { return http.StatusOK, nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() after Close() did not return")
+	}
+}
+
+func checkBackoff(t *testing.T, spool *Spool, entry *SpoolEntry, want time.Duration) {
+	t.Helper()
+
+	before := time.Now()
+	spool.retryLater(entry)
+	if backoff := entry.nextAttempt.Sub(before); backoff < want || backoff > want+time.Second {
+		t.Errorf("backoff after %d attempts = %v, want %v", entry.attempts, backoff, want)
+	}
+}
+
+// openTestSpool opens a spool which retries failed uploads after a few milliseconds
+func openTestSpool(t *testing.T, directory string) *Spool {
+	t.Helper()
+
+	spool, err := OpenSpool(directory, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.minBackoff = 10 * time.Millisecond
+	t.Cleanup(spool.Close)
+
+	return spool
+}
+
+// runTestSpool replays the spool until it is empty
+func runTestSpool(t *testing.T, spool *Spool, upload func(e *SpoolEntry) (int, error)) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		spool.Run(upload)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for spool.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d uploads left in the spool", spool.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	spool.Close()
+	<-done
+}
+
+func tempDirectory(t *testing.T) string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "swarfarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	return directory
+}
@@ -2,14 +2,90 @@ package swarfarm
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/swarpf/plugins/internal/metrics"
+	"github.com/swarpf/plugins/internal/workqueue"
+	"github.com/swarpf/plugins/pkg/gamemodel"
 )
 
 var DataLogEnabled = true
 var LiveSyncEnabled = false
 
+// uploadQueue is nil as long as uploads are done synchronously
+var (
+	uploadQueueMu sync.RWMutex
+	uploadQueue   *workqueue.Queue
+)
+
+func activeUploadQueue() *workqueue.Queue {
+	uploadQueueMu.RLock()
+	defer uploadQueueMu.RUnlock()
+
+	return uploadQueue
+}
+
+// EnableUploadQueue makes OnReceiveApiEvent hand the uploads to a queue instead of uploading them right away. Queued
+// events are reported as handled, the outcomes of the uploads are counted in metrics.QueuedJobs.
+func EnableUploadQueue(options workqueue.Options) {
+	uploadQueueMu.Lock()
+	defer uploadQueueMu.Unlock()
+
+	uploadQueue = workqueue.New("swarfarm", options)
+}
+
+// Shutdown stops the schema refresh, the drift report and the spool and waits up to timeout for the queued uploads
+// to finish. The dry-run mode ends afterwards and a token store implementing io.Closer, like FileTokenStore, is
+// closed and replaced by the tokens added with AddProfile.
+func Shutdown(timeout time.Duration) {
+	if stopSchemaRefresh != nil {
+		stopSchemaRefresh()
+		stopSchemaRefresh = nil
+	}
+	if stopDriftReport != nil {
+		stopDriftReport()
+		stopDriftReport = nil
+	}
+
+	uploadQueueMu.Lock()
+	queue := uploadQueue
+	uploadQueue = nil
+	uploadQueueMu.Unlock()
+	if queue != nil {
+		queue.Close(timeout)
+	}
+
+	uploadSpoolMu.Lock()
+	spool := uploadSpool
+	uploadSpool = nil
+	uploadSpoolMu.Unlock()
+	if spool != nil {
+		spool.Close()
+	}
+
+	captureMu.Lock()
+	captureDirectory = ""
+	captureMu.Unlock()
+
+	// stop watching the token file
+	tokenStoreMu.Lock()
+	store := tokenStore
+	tokenStore = defaultTokenStore
+	tokenStoreMu.Unlock()
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close token store")
+		}
+	}
+}
+
 func SubscribedCommands() []string {
 	commands := make([]string, 0)
 
@@ -37,32 +113,62 @@ func OnReceiveApiEvent(command, request, response string) error {
 		return nil
 	}
 
-	requestContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(request), &requestContent); err != nil {
+	requestContent, err := decodeContent(request)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie SWARFARM request")
-		return errors.New("error while deserializing SWARFARM request")
+		return fmt.Errorf("%w: request: %v", ErrDeserialization, err)
 	}
 
-	responseContent := map[string]interface{}{}
-	if err := json.Unmarshal([]byte(response), &responseContent); err != nil {
+	responseContent, err := decodeContent(response)
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to deserializie SWARFARM response")
-		return errors.New("error while deserializing SWARFARM response")
+		return fmt.Errorf("%w: response: %v", ErrDeserialization, err)
 	}
 
-	if DataLogEnabled && isCommandLoggerCommand(command) {
-		wizardId, ok := tryExtractWizardId(requestContent, responseContent)
-		if !ok {
-			log.Error().Msg("Failed to get wizardId from API request/response.")
-			return errors.New("failed to get wizardId from API request/response")
+	wizardId, ok := tryExtractWizardId(request, response)
+	if !ok {
+		log.Error().Msg("Failed to get wizardId from API request/response.")
+		return ErrMissingWizardId
+	}
+
+	queue := activeUploadQueue()
+	if queue == nil {
+		return uploadApiEvent(wizardId, command, request, response, requestContent, responseContent)
+	}
+
+	// queued events are reported as handled, the outcome of the upload is logged and counted by the worker
+	upload := func() {
+		err := uploadApiEvent(wizardId, command, request, response, requestContent, responseContent)
+		metrics.QueuedJobs.WithLabelValues("swarfarm", command, eventCode(err).String()).Inc()
+	}
+
+	job := workqueue.Job{Key: strconv.FormatInt(wizardId, 10), Name: command, Run: upload}
+	if spool := activeSpool(); spool != nil {
+		job.Spill = func() error {
+			return spoolApiEvent(spool, wizardId, command, requestContent, responseContent)
 		}
+	}
 
+	queue.Enqueue(job)
+	return nil
+}
+
+// uploadApiEvent uploads the api event to all enabled endpoints. If more than one upload fails, the first error is
+// returned.
+func uploadApiEvent(wizardId int64, command, request, response string,
+	requestContent, responseContent map[string]interface{}) error {
+	var result error
+
+	if DataLogEnabled && isCommandLoggerCommand(command) {
 		if err := UploadSwarfarmCommand(wizardId, command, requestContent, responseContent); err != nil {
+			result = err
+
 			log.Error().Err(err).
-				Str("swarfarmLogType", "data_log").
+				Str("swarfarmLogType", dataLogType).
 				Msg("Failed to upload SWARFARM data log command.")
 
 			log.Debug().
-				Str("swarfarmLogType", "data_log").
+				Str("swarfarmLogType", dataLogType).
 				Str("request", request).
 				Str("response", response).
 				Msg("Details of the failed data log command")
@@ -70,50 +176,95 @@ func OnReceiveApiEvent(command, request, response string) error {
 	}
 
 	if LiveSyncEnabled && isProfileSyncCommand(command) {
-		wizardId, ok := tryExtractWizardId(requestContent, responseContent)
-		if !ok {
-			log.Error().Msg("Failed to get wizardId from API request/response.")
-			return errors.New("failed to get wizardId from API request/response")
-		}
-
 		if err := UploadSwarfarmLiveSyncCommand(wizardId, command, requestContent, responseContent); err != nil {
+			if result == nil {
+				result = err
+			}
+
 			log.Error().Err(err).
-				Str("swarfarmLogType", "profile_sync").
+				Str("swarfarmLogType", liveSyncLogType).
 				Msg("Failed to upload SWARFARM profile sync command.")
 
 			log.Debug().
-				Str("swarfarmLogType", "profile_sync").
+				Str("swarfarmLogType", liveSyncLogType).
 				Str("request", request).
 				Str("response", response).
 				Msg("Details of the failed sync command")
 		}
 	}
 
-	return errors.New("unknown command")
+	return result
 }
 
-func tryExtractWizardId(request, response map[string]interface{}) (wizardId int64, ok bool) {
-	// try to extract wizardId using the request
-	if wizardIdField, found := request["wizard_id"]; found {
-		if wizardId, ok := wizardIdField.(float64); ok {
-			return int64(wizardId), true
+// spoolApiEvent writes the uploads of an api event to the spool instead of uploading them
+func spoolApiEvent(spool *Spool, wizardId int64, command string, requestContent, responseContent map[string]interface{}) error {
+	if dataLogEnabledFor(wizardId) && isCommandLoggerCommand(command) {
+		jsonBytes, err := makeDataLogPayload(wizardId, command, requestContent, responseContent)
+		if err != nil {
+			return err
+		}
+
+		if err := spool.Add(dataLogType, wizardId, command, jsonBytes); err != nil {
+			return err
 		}
 	}
 
-	// try to extract wizardId using the response directly
-	if wizardIdField, found := response["wizard_id"]; found {
-		if wizardId, ok := wizardIdField.(float64); ok {
-			return int64(wizardId), true
+	if liveSyncEnabledFor(wizardId) && isProfileSyncCommand(command) {
+		jsonBytes, err := makeLiveSyncPayload(wizardId, command, requestContent, responseContent)
+		if err != nil {
+			return err
+		}
+
+		if err := spool.Add(liveSyncLogType, wizardId, command, jsonBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dataLogEnabledFor reports whether data logs of the wizard are uploaded. Data logs of wizards without profile are
+// uploaded anonymously.
+func dataLogEnabledFor(wizardId int64) bool {
+	profile, ok := FindProfile(strconv.FormatInt(wizardId, 10))
+	return DataLogEnabled && (!ok || profile.DataLogEnabled())
+}
+
+// liveSyncEnabledFor reports whether the profile of the wizard is synced. This requires a token.
+func liveSyncEnabledFor(wizardId int64) bool {
+	profile, ok := FindProfile(strconv.FormatInt(wizardId, 10))
+	return LiveSyncEnabled && ok && profile.Token != "" && profile.LiveSyncEnabled()
+}
+
+// decodeContent decodes a request or response. Numbers are kept as json.Number, so ids are uploaded without losing
+// precision.
+func decodeContent(content string) (map[string]interface{}, error) {
+	decoded := map[string]interface{}{}
+
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+func tryExtractWizardId(request, response string) (wizardId int64, ok bool) {
+	// try to extract wizardId using the request
+	gameRequest := gamemodel.Request{}
+	if err := json.Unmarshal([]byte(request), &gameRequest); err == nil {
+		if wizardId, err := gameRequest.WizardId.Int64(); err == nil {
+			return wizardId, true
 		}
 	}
 
-	// try to extract wizardId using the response and the wizard_info field
-	if wizardInfoField, found := response["wizard_info"]; found {
-		if wizardInfo, ok := wizardInfoField.(map[string]interface{}); ok {
-			if wizardIdField, found := wizardInfo["wizard_id"]; found {
-				if wizardId, ok := wizardIdField.(float64); ok {
-					return int64(wizardId), true
-				}
+	// try to extract wizardId using the response directly or the wizard_info field
+	gameResponse := gamemodel.Response{}
+	if err := json.Unmarshal([]byte(response), &gameResponse); err == nil {
+		for _, wizardIdField := range []json.Number{gameResponse.WizardId, gameResponse.WizardInfo.WizardId} {
+			if wizardId, err := wizardIdField.Int64(); err == nil {
+				return wizardId, true
 			}
 		}
 	}
@@ -0,0 +1,207 @@
+package swarfarm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// tokenFileReloadDelay collects the burst of events editors cause when saving a file into a single reload
+const tokenFileReloadDelay = 200 * time.Millisecond
+
+type tokenFile struct {
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// FileTokenStore is a TokenStore backed by a YAML or JSON file, which is reloaded whenever it changes:
+//
+//	profiles:
+//	  - wizard_id: 123456
+//	    token: 0123456789abcdef
+//	    live_sync: true
+//
+// The file must not be accessible by other users, because it contains the tokens in plain text.
+type FileTokenStore struct {
+	path string
+
+	mu       sync.RWMutex
+	profiles map[string]Profile
+	onReload []func()
+
+	watcher *fsnotify.Watcher
+}
+
+// OpenFileTokenStore loads the token file and starts watching it for changes.
+func OpenFileTokenStore(path string) (*FileTokenStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileTokenStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch token file: %w", err)
+	}
+
+	// watch the directory instead of the file, since editors usually replace the file when saving it
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch token file: %w", err)
+	}
+
+	s.watcher = watcher
+	go s.watch()
+
+	return s, nil
+}
+
+func (s *FileTokenStore) FindProfile(wizardId string) (Profile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile, ok := s.profiles[wizardId]
+	return profile, ok
+}
+
+func (s *FileTokenStore) Profiles() []Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedProfiles(s.profiles)
+}
+
+// OnReload adds a function which is called after the token file was reloaded successfully.
+func (s *FileTokenStore) OnReload(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onReload = append(s.onReload, f)
+}
+
+// Close stops watching the token file.
+func (s *FileTokenStore) Close() error {
+	return s.watcher.Close()
+}
+
+func (s *FileTokenStore) watch() {
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Clean(event.Name) == s.path {
+				reload = time.After(tokenFileReloadDelay)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Error().Err(err).Str("tokenFile", s.path).Msg("Error while watching token file")
+		case <-reload:
+			reload = nil
+
+			if err := s.load(); err != nil {
+				log.Error().Err(err).Str("tokenFile", s.path).Msg("Failed to reload token file. Keeping the previous tokens")
+				continue
+			}
+
+			s.mu.RLock()
+			callbacks := s.onReload
+			s.mu.RUnlock()
+
+			for _, f := range callbacks {
+				f()
+			}
+		}
+	}
+}
+
+func (s *FileTokenStore) load() error {
+	profiles, err := readTokenFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.profiles = profiles
+	s.mu.Unlock()
+
+	log.Info().
+		Str("tokenFile", s.path).
+		Int("profiles", len(profiles)).
+		Msg("Loaded SWARFARM tokens from token file")
+
+	return nil
+}
+
+// readTokenFile reads and checks the profiles of a token file
+func readTokenFile(path string) (map[string]Profile, error) {
+	if err := checkTokenFilePermissions(path); err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	file := tokenFile{}
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+
+	profiles := make(map[string]Profile, len(file.Profiles))
+	for i, profile := range file.Profiles {
+		profile.WizardId = strings.TrimSpace(profile.WizardId)
+		if profile.WizardId == "" {
+			return nil, fmt.Errorf("profile %d in token file has no wizard_id", i+1)
+		}
+
+		if _, ok := profiles[profile.WizardId]; ok {
+			return nil, fmt.Errorf("wizard %s is listed more than once in token file", profile.WizardId)
+		}
+
+		profiles[profile.WizardId] = profile
+	}
+
+	return profiles, nil
+}
+
+// checkTokenFilePermissions makes sure that the token file is only accessible by its owner
+func checkTokenFilePermissions(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	// windows does not support unix permissions
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("token file %s must not be accessible by other users (mode %04o). Use chmod 600",
+			path, fi.Mode().Perm())
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package swarfarm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swarpf/plugins/pkg/swarfarm"
+)
+
+func TestFileTokenStoreReload(t *testing.T) {
+	directory := tokenDirectory(t)
+	path := filepath.Join(directory, "tokens.yml")
+	writeTokenFile(t, path, "profiles:\n  - wizard_id: 123\n    token: first\n", 0600)
+
+	store, err := swarfarm.OpenFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	reloads := make(chan struct{}, 10)
+	store.OnReload(func() { reloads <- struct{}{} })
+	checkToken(t, store, "first")
+
+	// a burst of writes is reloaded once after it settled
+	for _, token := range []string{"a", "b", "second"} {
+		writeTokenFile(t, path, "profiles:\n  - wizard_id: 123\n    token: "+token+"\n", 0600)
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitForReload(t, reloads)
+	checkToken(t, store, "second")
+	checkNoReload(t, reloads)
+
+	// editors replace the file with a renamed one when saving
+	tmpPath := filepath.Join(directory, ".tokens.yml.swp")
+	writeTokenFile(t, tmpPath, `{"profiles": [{"wizard_id": "123", "token": "renamed"}]}`, 0600)
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatal(err)
+	}
+	waitForReload(t, reloads)
+	checkToken(t, store, "renamed")
+
+	// other files in the directory are ignored
+	writeTokenFile(t, filepath.Join(directory, "other.yml"), "profiles: []\n", 0600)
+	checkNoReload(t, reloads)
+
+	// an invalid file is not loaded, the previous tokens are kept
+	writeTokenFile(t, path, "profiles:\n  - wizard_id: 123\n    tokn: broken\n", 0600)
+	checkNoReload(t, reloads)
+	checkToken(t, store, "renamed")
+}
+
+func TestShutdownClosesTokenStore(t *testing.T) {
+	path := filepath.Join(tokenDirectory(t), "tokens.yml")
+	writeTokenFile(t, path, "profiles:\n  - wizard_id: 123\n    token: first\n", 0600)
+
+	store, err := swarfarm.OpenFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan struct{}, 10)
+	store.OnReload(func() { reloads <- struct{}{} })
+
+	swarfarm.SetTokenStore(store)
+	swarfarm.Shutdown(0)
+
+	if token, _ := swarfarm.FindToken("123"); token == "first" {
+		t.Error("token store is still used after Shutdown")
+	}
+
+	// the file is not watched anymore
+	writeTokenFile(t, path, "profiles:\n  - wizard_id: 123\n    token: second\n", 0600)
+	checkNoReload(t, reloads)
+}
+
+func TestReadTokenFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+		err     string
+	}{
+		{"yaml", "profiles:\n  - wizard_id: 123\n    token: abc\n    live_sync: false\n", 0600, ""},
+		{"json", `{"profiles": [{"wizard_id": "123", "token": "abc", "data_log": true}]}`, 0600, ""},
+		{"readable by group", "profiles: []\n", 0640, "chmod 600"},
+		{"readable by others", "profiles: []\n", 0604, "chmod 600"},
+		{"unknown key", "profiles: []\ntokens: []\n", 0600, "parse"},
+		{"unknown profile key", `{"profiles": [{"wizard_id": "123", "tokn": "abc"}]}`, 0600, "parse"},
+		{"wrong type", "profiles:\n  - wizard_id: 123\n    live_sync: sometimes\n", 0600, "parse"},
+		{"no wizard id", "profiles:\n  - token: abc\n", 0600, "no wizard_id"},
+		{"duplicate wizard", "profiles:\n  - wizard_id: 1\n  - wizard_id: 1\n", 0600, "more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && tt.mode != 0600 {
+				t.Skip("windows does not support unix permissions")
+			}
+
+			path := filepath.Join(tokenDirectory(t), "tokens.yml")
+			writeTokenFile(t, path, tt.content, tt.mode)
+
+			store, err := swarfarm.OpenFileTokenStore(path)
+			if err == nil {
+				_ = store.Close()
+			}
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("OpenFileTokenStore() error = %v", err)
+				}
+				if profile, ok := store.FindProfile("123"); !ok || profile.Token != "abc" {
+					t.Errorf("FindProfile(123) = %+v, %v, want token abc", profile, ok)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("OpenFileTokenStore() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func tokenDirectory(t *testing.T) string {
+	t.Helper()
+
+	directory, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+
+	return directory
+}
+
+func writeTokenFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile does not change the mode of existing files and applies the umask to new ones
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkToken(t *testing.T, store *swarfarm.FileTokenStore, want string) {
+	t.Helper()
+
+	if profile, ok := store.FindProfile("123"); !ok || profile.Token != want {
+		t.Errorf("FindProfile(123) = %+v, %v, want token %s", profile, ok, want)
+	}
+}
+
+func waitForReload(t *testing.T, reloads chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("token file was not reloaded")
+	}
+}
+
+// checkNoReload makes sure that the token file is not reloaded within twice the reload delay
+func checkNoReload(t *testing.T, reloads chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-reloads:
+		t.Fatal("token file was reloaded")
+	case <-time.After(400 * time.Millisecond):
+	}
+}
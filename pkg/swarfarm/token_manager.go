@@ -2,37 +2,123 @@ package swarfarm
 
 import (
 	"errors"
+	"sort"
 	"strings"
+	"sync"
 )
 
-var apiTokenMap map[string]string
+// Profile holds the SWARFARM settings of a single wizard.
+type Profile struct {
+	WizardId string `yaml:"wizard_id"`
+	Token    string `yaml:"token"`
+	// DataLog and LiveSync can disable the uploads of the wizard. Both default to enabled. Uploads are only done
+	// if they are enabled for the plugin as well.
+	DataLog  *bool `yaml:"data_log"`
+	LiveSync *bool `yaml:"live_sync"`
+}
 
-func AddProfile(summonerId, token string) {
-	if apiTokenMap == nil {
-		apiTokenMap = make(map[string]string)
+func (p Profile) DataLogEnabled() bool {
+	return p.DataLog == nil || *p.DataLog
+}
+
+func (p Profile) LiveSyncEnabled() bool {
+	return p.LiveSync == nil || *p.LiveSync
+}
+
+// TokenStore looks up the SWARFARM profile of a wizard. Implementations have to be safe for concurrent use.
+type TokenStore interface {
+	FindProfile(wizardId string) (Profile, bool)
+	Profiles() []Profile
+}
+
+// MemoryTokenStore is a TokenStore holding the profiles in memory.
+type MemoryTokenStore struct {
+	mu       sync.RWMutex
+	profiles map[string]Profile
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{profiles: make(map[string]Profile)}
+}
+
+func (s *MemoryTokenStore) AddProfile(profile Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiles[profile.WizardId] = profile
+}
+
+func (s *MemoryTokenStore) FindProfile(wizardId string) (Profile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile, ok := s.profiles[wizardId]
+	return profile, ok
+}
+
+func (s *MemoryTokenStore) Profiles() []Profile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedProfiles(s.profiles)
+}
+
+func sortedProfiles(profiles map[string]Profile) []Profile {
+	result := make([]Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		result = append(result, profile)
 	}
 
+	sort.Slice(result, func(i, j int) bool { return result[i].WizardId < result[j].WizardId })
+	return result
+}
+
+// defaultTokenStore is used by AddProfile and is the active token store unless another one is set
+var defaultTokenStore = NewMemoryTokenStore()
+
+var tokenStoreMu sync.RWMutex
+var tokenStore TokenStore = defaultTokenStore
+
+// SetTokenStore replaces the token store used to look up the tokens of the wizards.
+func SetTokenStore(store TokenStore) {
+	tokenStoreMu.Lock()
+	defer tokenStoreMu.Unlock()
+
+	tokenStore = store
+}
+
+func activeTokenStore() TokenStore {
+	tokenStoreMu.RLock()
+	defer tokenStoreMu.RUnlock()
+
+	return tokenStore
+}
+
+func AddProfile(summonerId, token string) {
 	if strings.TrimSpace(summonerId) == "" {
 		return
 	}
 
-	apiTokenMap[summonerId] = token
+	defaultTokenStore.AddProfile(Profile{WizardId: summonerId, Token: token})
 }
 
-func FindToken(summonerId string) (string, error) {
-	if apiTokenMap == nil {
-		apiTokenMap = make(map[string]string)
+func FindProfile(summonerId string) (Profile, bool) {
+	if strings.TrimSpace(summonerId) == "" {
+		return Profile{}, false
 	}
 
+	return activeTokenStore().FindProfile(summonerId)
+}
+
+func FindToken(summonerId string) (string, error) {
 	if strings.TrimSpace(summonerId) == "" {
 		return "", errors.New("summonerId is empty")
 	}
 
-	token, ok := apiTokenMap[summonerId]
-
+	profile, ok := activeTokenStore().FindProfile(summonerId)
 	if !ok {
 		return "", errors.New("no associated token found")
 	}
 
-	return token, nil
+	return profile.Token, nil
 }
@@ -0,0 +1,139 @@
+package swarfarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// profileEndpoint returns the SWARFARM profile the token belongs to
+const profileEndpoint = "/profiles/me/"
+
+var errQuarantined = fmt.Errorf("%w: live sync of the wizard is quarantined because of its token", ErrRejected)
+
+// quarantinedTokens maps the wizards whose token was rejected by SWARFARM to the rejected token
+var (
+	quarantineMu      sync.Mutex
+	quarantinedTokens = make(map[int64]string)
+)
+
+// ValidateTokens checks the tokens of all profiles against SWARFARM. Wizards with an invalid token or a token of
+// another profile are quarantined, wizards with a valid token are released from quarantine.
+func ValidateTokens() {
+	for _, profile := range activeTokenStore().Profiles() {
+		wizardId, err := strconv.ParseInt(profile.WizardId, 10, 64)
+		if err != nil {
+			log.Error().Str("wizardId", profile.WizardId).Msg("Invalid wizard id. Skipping SWARFARM token validation")
+			continue
+		}
+
+		if profile.Token == "" {
+			continue
+		}
+
+		validateToken(wizardId, profile.Token)
+	}
+}
+
+// ReloadTokens is called after the tokens were reloaded. It releases the wizards whose token changed from quarantine.
+func ReloadTokens() {
+	quarantineMu.Lock()
+	for wizardId, rejectedToken := range quarantinedTokens {
+		if token, _ := FindToken(strconv.FormatInt(wizardId, 10)); token != rejectedToken {
+			releaseLocked(wizardId, "token was changed")
+		}
+	}
+	quarantineMu.Unlock()
+}
+
+func validateToken(wizardId int64, token string) {
+	resp, err := makeAuthorizedRequest(token).Get(apiUrl + profileEndpoint)
+	if err != nil {
+		log.Error().Err(err).Int64("wizardId", wizardId).Msg("Unable to validate SWARFARM token")
+		return
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		quarantine(wizardId, token, "token is invalid")
+		return
+	default:
+		log.Error().
+			Int64("wizardId", wizardId).
+			Int("statusCode", resp.StatusCode()).
+			Msg("Unable to validate SWARFARM token - invalid status code")
+		return
+	}
+
+	profile := struct {
+		Username string      `json:"username"`
+		Com2usId json.Number `json:"com2us_id"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &profile); err != nil {
+		log.Error().Err(err).
+			Int64("wizardId", wizardId).
+			Str("body", string(resp.Body())).
+			Msg("Failed to deserialize SWARFARM profile")
+		return
+	}
+
+	// profiles which were never imported are not bound to a wizard yet
+	if profile.Com2usId != "" && profile.Com2usId.String() != strconv.FormatInt(wizardId, 10) {
+		quarantine(wizardId, token,
+			fmt.Sprintf("token belongs to profile %s of wizard %s", profile.Username, profile.Com2usId))
+		return
+	}
+
+	log.Info().Int64("wizardId", wizardId).Str("username", profile.Username).Msg("SWARFARM token is valid")
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	releaseLocked(wizardId, "token is valid")
+}
+
+// quarantine stops the live sync of the wizard until its token is changed. The live sync uploads of the wizard are
+// kept in the spool instead of being sent, data logs are still sent without token.
+func quarantine(wizardId int64, token, reason string) {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	if rejectedToken, ok := quarantinedTokens[wizardId]; ok && rejectedToken == token {
+		return
+	}
+	quarantinedTokens[wizardId] = token
+
+	log.Error().
+		Int64("wizardId", wizardId).
+		Str("reason", reason).
+		Msg("SWARFARM rejected the token of the wizard. Live sync is quarantined until the token is changed")
+
+	if spool := activeSpool(); spool != nil {
+		spool.Pause(liveSyncLogType, wizardId)
+	}
+}
+
+func releaseLocked(wizardId int64, reason string) {
+	if _, ok := quarantinedTokens[wizardId]; !ok {
+		return
+	}
+	delete(quarantinedTokens, wizardId)
+
+	log.Info().Int64("wizardId", wizardId).Str("reason", reason).Msg("Live sync of the wizard released from quarantine")
+
+	if spool := activeSpool(); spool != nil {
+		spool.Resume(liveSyncLogType, wizardId)
+	}
+}
+
+func isQuarantined(wizardId int64) bool {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	_, ok := quarantinedTokens[wizardId]
+	return ok
+}
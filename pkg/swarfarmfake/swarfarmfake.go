@@ -0,0 +1,335 @@
+// Package swarfarmfake implements a stand-in for the parts of the SWARFARM API used by the SWARFARM uploader. It
+// serves the accepted command schemas and the profile of a token, accepts data log and live sync uploads, records them
+// and can be told to fail.
+package swarfarmfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DataLogsPath         = "/api/v2/data_logs/"
+	AcceptedCommandsPath = "/api/v2/profiles/accepted-commands/"
+	SyncPath             = "/api/v2/profiles/sync/"
+	ProfilePath          = "/api/v2/profiles/me/"
+)
+
+// Schema maps a command to the request and response fields SWARFARM accepts for it.
+type Schema map[string]map[string][]string
+
+// DefaultDataLogSchema is served on the data log endpoint if no other schema was set.
+var DefaultDataLogSchema = Schema{
+	"SummonUnit": {
+		"request":  {"wizard_id", "command", "mode", "summon_id"},
+		"response": {"unit_list", "item_list"},
+	},
+	"BattleDungeonResult_V2": {
+		"request":  {"wizard_id", "command", "dungeon_id", "stage_id", "win_lose", "clear_time"},
+		"response": {"wizard_info", "reward", "changed_item_list"},
+	},
+}
+
+// DefaultSyncSchema is served on the accepted commands endpoint if no other schema was set.
+var DefaultSyncSchema = Schema{
+	"HubUserLogin": {
+		"request":  {"wizard_id", "command"},
+		"response": {"wizard_info", "unit_list", "runes", "building_list"},
+	},
+	"UpgradeRune": {
+		"request":  {"wizard_id", "command", "rune_id"},
+		"response": {"rune"},
+	},
+}
+
+// Upload is a single upload received by the server.
+type Upload struct {
+	Path     string
+	Token    string
+	Body     map[string]interface{}
+	Received time.Time
+}
+
+// Request is a request received by the server, whether it succeeded or not.
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Server is a fake SWARFARM server. It implements http.Handler, so it can be used with httptest.NewServer or be
+// served by a http.Server.
+type Server struct {
+	mu            sync.Mutex
+	dataLogSchema Schema
+	syncSchema    Schema
+	tokens        map[string]int64
+	requests      []Request
+	uploads       []Upload
+
+	failStatus int
+	failCount  int
+	delay      time.Duration
+}
+
+// New creates a server serving the default schemas which does not accept any token yet.
+func New() *Server {
+	return &Server{
+		dataLogSchema: DefaultDataLogSchema,
+		syncSchema:    DefaultSyncSchema,
+		tokens:        make(map[string]int64),
+	}
+}
+
+// AddToken makes the server accept the token as the token of the wizard.
+func (s *Server) AddToken(token string, wizardId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token] = wizardId
+}
+
+// RemoveToken makes the server reject the token.
+func (s *Server) RemoveToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, token)
+}
+
+func (s *Server) SetDataLogSchema(schema Schema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dataLogSchema = schema
+}
+
+func (s *Server) SetSyncSchema(schema Schema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.syncSchema = schema
+}
+
+// FailWith makes the next count requests fail with the status code. A count of 0 or less fails all requests until
+// FailWith is called with a status code of 0.
+func (s *Server) FailWith(statusCode int, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failStatus = statusCode
+	s.failCount = count
+}
+
+// SetDelay delays every response, which can be used to provoke client timeouts.
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delay = delay
+}
+
+// Uploads returns all successful uploads received so far.
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploads := make([]Upload, len(s.uploads))
+	copy(uploads, s.uploads)
+	return uploads
+}
+
+// Requests returns all requests received so far, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Reset removes all recorded requests, uploads and failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+	s.uploads = nil
+	s.failStatus = 0
+	s.failCount = 0
+	s.delay = 0
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path})
+	s.mu.Unlock()
+
+	if delay := s.currentDelay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if statusCode := s.nextFailure(); statusCode != 0 {
+		writeJson(w, statusCode, map[string]string{"detail": http.StatusText(statusCode)})
+		return
+	}
+
+	path := r.URL.Path
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	switch {
+	case path == DataLogsPath && r.Method == http.MethodGet:
+		s.serveSchema(w, func() Schema { return s.dataLogSchema })
+	case path == AcceptedCommandsPath && r.Method == http.MethodGet:
+		s.serveSchema(w, func() Schema { return s.syncSchema })
+	case path == DataLogsPath && r.Method == http.MethodPost:
+		s.serveUpload(w, r, DataLogsPath, false)
+	case path == SyncPath && r.Method == http.MethodPost:
+		s.serveUpload(w, r, SyncPath, true)
+	case path == ProfilePath && r.Method == http.MethodGet:
+		s.serveProfile(w, r)
+	default:
+		writeJson(w, http.StatusNotFound, map[string]string{"detail": "Not found."})
+	}
+}
+
+func (s *Server) serveSchema(w http.ResponseWriter, schema func() Schema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJson(w, http.StatusOK, schema())
+}
+
+// serveProfile returns the profile the token belongs to. The profile of a wizard is named after its wizard id.
+func (s *Server) serveProfile(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.authenticate(r)
+	if !ok || token == "" {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	wizardId := s.tokens[token]
+	s.mu.Unlock()
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"username":  fmt.Sprintf("wizard%d", wizardId),
+		"com2us_id": wizardId,
+	})
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, path string, tokenRequired bool) {
+	token, ok := s.authenticate(r)
+	if !ok || (tokenRequired && token == "") {
+		writeUnauthorized(w, r)
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(content, &body); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"detail": fmt.Sprintf("JSON parse error - %v", err)})
+		return
+	}
+
+	if _, ok := body["data"].(map[string]interface{}); !ok {
+		writeJson(w, http.StatusBadRequest, map[string]string{"detail": "data field is missing"})
+		return
+	}
+
+	s.mu.Lock()
+	s.uploads = append(s.uploads, Upload{
+		Path:     path,
+		Token:    token,
+		Body:     body,
+		Received: time.Now(),
+	})
+	s.mu.Unlock()
+
+	log.Debug().Str("path", path).Bool("authenticated", token != "").Msg("Received upload")
+
+	writeJson(w, http.StatusOK, map[string]string{"detail": "Log saved"})
+}
+
+// authenticate returns the token of the request. A request without Authorization header is anonymous, a request
+// with an unknown token is rejected.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", true
+	}
+
+	if !strings.HasPrefix(header, "Token ") {
+		return "", false
+	}
+
+	token := strings.TrimPrefix(header, "Token ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.tokens[token]
+	return token, ok
+}
+
+func (s *Server) currentDelay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delay
+}
+
+func (s *Server) nextFailure() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failStatus == 0 {
+		return 0
+	}
+
+	statusCode := s.failStatus
+	if s.failCount > 0 {
+		s.failCount--
+		if s.failCount == 0 {
+			s.failStatus = 0
+		}
+	}
+
+	return statusCode
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+	detail := "Invalid token."
+	if r.Header.Get("Authorization") == "" {
+		detail = "Authentication credentials were not provided."
+	}
+
+	writeJson(w, http.StatusUnauthorized, map[string]string{"detail": detail})
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(content); err != nil {
+		log.Error().Err(err).Msg("Failed to write response")
+	}
+}